@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// writeArrow writes stations as an arrow ipc stream: a schema message, one record batch and the end-of-stream marker.
+// pyarrow.ipc.open_stream (and so pandas) reads it without any parsing. the message metadata is flatbuffers, which
+// we build by hand with fbBuilder, since it's only a handful of tables.
+func writeArrow(w io.Writer, stations []brc.Station) error {
+	cols := arrowColumns(stations)
+
+	fields := make([]*fbTable, len(cols))
+	for i, c := range cols {
+		f := &fbTable{}
+		f.ref(0, fbString(c.name))
+		f.bool(1, false) // nullable
+		f.u8(2, c.typeType)
+		f.ref(3, c.typ)
+		f.ref(5, fbTableVector(nil)) // children
+		fields[i] = f
+	}
+	schema := &fbTable{}
+	schema.i16(0, 0) // endianness: little
+	schema.ref(1, fbTableVector(fields))
+
+	out := appendArrowMessage(nil, arrowSchemaHeader, schema, nil)
+
+	// every column is non-nullable, so there are no validity bitmaps, just an empty buffer in their place
+	var nodes, buffers, body []byte
+	addBuffer := func(data []byte) {
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(body)))
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(data)))
+		body = append(body, data...)
+		for len(body)%8 != 0 {
+			body = append(body, 0)
+		}
+	}
+	for _, c := range cols {
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(len(stations)))
+		nodes = binary.LittleEndian.AppendUint64(nodes, 0) // null count
+		addBuffer(nil)
+		for _, b := range c.buffers {
+			addBuffer(b)
+		}
+	}
+	batch := &fbTable{}
+	batch.i64(0, int64(len(stations)))
+	batch.ref(1, fbStructVector{data: nodes, align: 8, n: len(cols)})
+	batch.ref(2, fbStructVector{data: buffers, align: 8, n: len(buffers) / 16})
+	out = appendArrowMessage(out, arrowRecordBatchHeader, batch, body)
+
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff) // end of stream
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	_, err := w.Write(out)
+	return err
+}
+
+// the MessageHeader union
+const (
+	arrowSchemaHeader      = 1
+	arrowRecordBatchHeader = 3
+)
+
+// appendArrowMessage appends an encapsulated ipc message: the continuation marker, the metadata length, the Message
+// flatbuffer padded to 8 bytes, then the body.
+func appendArrowMessage(out []byte, headerType uint8, header *fbTable, body []byte) []byte {
+	msg := &fbTable{}
+	msg.i16(0, 4) // version: V5
+	msg.u8(1, headerType)
+	msg.ref(2, header)
+	msg.i64(3, int64(len(body)))
+	meta := fbFinish(msg)
+	for len(meta)%8 != 0 {
+		meta = append(meta, 0)
+	}
+
+	out = binary.LittleEndian.AppendUint32(out, 0xffffffff)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(meta)))
+	out = append(out, meta...)
+	return append(out, body...)
+}
+
+// the Type union
+const (
+	arrowInt           = 2
+	arrowFloatingPoint = 3
+	arrowUtf8          = 5
+	arrowTimestamp     = 10
+)
+
+type arrowColumn struct {
+	name     string
+	typeType uint8
+	typ      *fbTable
+	buffers  [][]byte // after the validity bitmap
+}
+
+func arrowColumns(stations []brc.Station) []arrowColumn {
+	double := func(name string, v func(s *brc.Station) float64) arrowColumn {
+		t := &fbTable{}
+		t.i16(0, 2) // precision: DOUBLE
+		var data []byte
+		for i := range stations {
+			data = binary.LittleEndian.AppendUint64(data, math.Float64bits(v(&stations[i])))
+		}
+		return arrowColumn{name: name, typeType: arrowFloatingPoint, typ: t, buffers: [][]byte{data}}
+	}
+	str := func(name string, v func(s *brc.Station) string) arrowColumn {
+		offsets := binary.LittleEndian.AppendUint32(nil, 0)
+		var data []byte
+		for i := range stations {
+			data = append(data, v(&stations[i])...)
+			offsets = binary.LittleEndian.AppendUint32(offsets, uint32(len(data)))
+		}
+		return arrowColumn{name: name, typeType: arrowUtf8, typ: &fbTable{}, buffers: [][]byte{offsets, data}}
+	}
+	int64s := func(name string, typeType uint8, typ *fbTable, v func(s *brc.Station) int64) arrowColumn {
+		var data []byte
+		for i := range stations {
+			data = binary.LittleEndian.AppendUint64(data, uint64(v(&stations[i])))
+		}
+		return arrowColumn{name: name, typeType: typeType, typ: typ, buffers: [][]byte{data}}
+	}
+
+	cols := []arrowColumn{str("station", func(s *brc.Station) string { return escapeName(s.Name) })}
+	if *window > 0 {
+		ts := &fbTable{}
+		ts.i16(0, 1) // unit: MILLISECOND
+		ts.ref(1, fbString("UTC"))
+		cols = append(cols, int64s("window", arrowTimestamp, ts, func(s *brc.Station) int64 { return s.Window.UnixMilli() }))
+	}
+	if *columns != "" {
+		cols = append(cols, str("metric", func(s *brc.Station) string { return s.Metric }))
+	}
+	cols = append(cols,
+		double("min", func(s *brc.Station) float64 { return s.Min }),
+		double("mean", func(s *brc.Station) float64 { return s.Mean }),
+		double("max", func(s *brc.Station) float64 { return s.Max }),
+		double("stddev", func(s *brc.Station) float64 { return s.Stddev }),
+	)
+	i64 := &fbTable{}
+	i64.i32(0, 64)    // bitWidth
+	i64.bool(1, true) // is_signed
+	return append(cols, int64s("count", arrowInt, i64, func(s *brc.Station) int64 { return s.Count }))
+}
+
+// fbNode is something that can be referenced from a flatbuffers table: a table, a string or a vector.
+type fbNode interface {
+	write(b *fbBuilder) int // returns its position
+}
+
+// fbTable is a flatbuffers table under construction. fields are set by slot, the field's position in the schema
+// (unions take two: the type, then the value).
+type fbTable struct {
+	fields []fbField
+}
+
+type fbField struct {
+	scalar []byte // little endian, its length is the size
+	ref    fbNode
+}
+
+func (t *fbTable) set(slot int, f fbField) {
+	for len(t.fields) <= slot {
+		t.fields = append(t.fields, fbField{})
+	}
+	t.fields[slot] = f
+}
+
+func (t *fbTable) u8(slot int, v uint8) { t.set(slot, fbField{scalar: []byte{v}}) }
+
+func (t *fbTable) bool(slot int, v bool) {
+	var b uint8
+	if v {
+		b = 1
+	}
+	t.u8(slot, b)
+}
+
+func (t *fbTable) i16(slot int, v int16) {
+	t.set(slot, fbField{scalar: binary.LittleEndian.AppendUint16(nil, uint16(v))})
+}
+
+func (t *fbTable) i32(slot int, v int32) {
+	t.set(slot, fbField{scalar: binary.LittleEndian.AppendUint32(nil, uint32(v))})
+}
+
+func (t *fbTable) i64(slot int, v int64) {
+	t.set(slot, fbField{scalar: binary.LittleEndian.AppendUint64(nil, uint64(v))})
+}
+
+func (t *fbTable) ref(slot int, n fbNode) { t.set(slot, fbField{ref: n}) }
+
+// fbBuilder lays a flatbuffer out front to back. offsets to other objects have to point forward, so a table's
+// children go after it and their offsets get patched in once they're written.
+type fbBuilder struct {
+	buf []byte
+}
+
+func fbFinish(root *fbTable) []byte {
+	b := &fbBuilder{buf: make([]byte, 4)}
+	pos := root.write(b)
+	binary.LittleEndian.PutUint32(b.buf, uint32(pos))
+	return b.buf
+}
+
+func (b *fbBuilder) pad(align int) {
+	for len(b.buf)%align != 0 {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+// writeRef writes n and points the offset at pos to it.
+func (b *fbBuilder) writeRef(pos int, n fbNode) {
+	target := n.write(b)
+	binary.LittleEndian.PutUint32(b.buf[pos:], uint32(target-pos))
+}
+
+func (t *fbTable) write(b *fbBuilder) int {
+	// the vtable goes first: its size, the table's size, then each field's offset in the table (0 if absent)
+	b.pad(2)
+	vtable := len(b.buf)
+	start := vtable + 4 + 2*len(t.fields)
+	start += (8 - start%8) % 8
+
+	offsets := make([]int, len(t.fields))
+	end := start + 4 // the table starts with the offset back to its vtable
+	for i, f := range t.fields {
+		size := len(f.scalar)
+		if f.ref != nil {
+			size = 4
+		}
+		if size == 0 {
+			continue
+		}
+		end += (size - end%size) % size
+		offsets[i] = end - start
+		end += size
+	}
+
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(4+2*len(t.fields)))
+	b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(end-start))
+	for _, off := range offsets {
+		b.buf = binary.LittleEndian.AppendUint16(b.buf, uint16(off))
+	}
+	b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	binary.LittleEndian.PutUint32(b.buf[start:], uint32(start-vtable))
+	for i, f := range t.fields {
+		if f.scalar != nil {
+			copy(b.buf[start+offsets[i]:], f.scalar)
+		}
+	}
+	for i, f := range t.fields {
+		if f.ref != nil {
+			b.writeRef(start+offsets[i], f.ref)
+		}
+	}
+	return start
+}
+
+type fbString string
+
+func (s fbString) write(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(s)))
+	b.buf = append(b.buf, s...)
+	b.buf = append(b.buf, 0)
+	return pos
+}
+
+// fbStructVector is a vector of n inline structs (or scalars), already encoded.
+type fbStructVector struct {
+	data  []byte
+	align int
+	n     int
+}
+
+func (v fbStructVector) write(b *fbBuilder) int {
+	// the elements have to be aligned, and they come right after the length
+	for (len(b.buf)+4)%v.align != 0 || len(b.buf)%4 != 0 {
+		b.buf = append(b.buf, 0)
+	}
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(v.n))
+	b.buf = append(b.buf, v.data...)
+	return pos
+}
+
+type fbTableVector []*fbTable
+
+func (v fbTableVector) write(b *fbBuilder) int {
+	b.pad(4)
+	pos := len(b.buf)
+	b.buf = binary.LittleEndian.AppendUint32(b.buf, uint32(len(v)))
+	b.buf = append(b.buf, make([]byte, 4*len(v))...)
+	for i, t := range v {
+		b.writeRef(pos+4+4*i, t)
+	}
+	return pos
+}
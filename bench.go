@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// runBench is the `bench` subcommand, a built-in replacement for `hyperfine -w1 -m5 ./bin/1brc`. it prints a line in
+// the same shape as the benchmark log in main.go so results can be pasted straight in.
+func runBench(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("bench", slices.Concat(aggregationFlags, []string{"gc"})...)
+	runs := fs.Int("runs", 5, "number of measured runs")
+	warmups := fs.Int("warmup", 1, "number of unmeasured warmup runs")
+	dropCaches := fs.Bool("drop-caches", false, "drop the page cache before every run, for cold-cache numbers (linux only, needs root)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *runs < 1 {
+		return fmt.Errorf("-runs must be at least 1")
+	}
+	if err := applyGC(); err != nil {
+		return err
+	}
+
+	var durations []time.Duration
+	var rows int64
+	for i := range *warmups + *runs {
+		// don't let garbage from the previous run (-gc off...) bleed into this one
+		runtime.GC()
+		if *dropCaches {
+			if err := dropPageCache(); err != nil {
+				return fmt.Errorf("dropping page cache: %w", err)
+			}
+		}
+
+		start := time.Now()
+		res, err := aggregate(ctx, log)
+		if err != nil {
+			return fmt.Errorf("run %d: %w", i, err)
+		}
+		elapsed := time.Since(start)
+
+		warmup := i < *warmups
+		log.Debug("bench run", "run", i, "warmup", warmup, "elapsed", elapsed)
+		if warmup {
+			continue
+		}
+		durations = append(durations, elapsed)
+		rows = res.Rows()
+	}
+
+	mean, stddev := meanStddev(durations)
+	fmt.Printf("%.3f s ± %.3f s (min %.3f s, %d runs, %.1fM rows/s)\n",
+		mean.Seconds(), stddev.Seconds(), slices.Min(durations).Seconds(), len(durations), float64(rows)/mean.Seconds()/1e6)
+	return nil
+}
+
+// meanStddev returns the mean and (sample) standard deviation of ds, like hyperfine reports.
+func meanStddev(ds []time.Duration) (time.Duration, time.Duration) {
+	var sum float64
+	for _, d := range ds {
+		sum += float64(d)
+	}
+	mean := sum / float64(len(ds))
+	if len(ds) < 2 {
+		return time.Duration(mean), 0
+	}
+	var sq float64
+	for _, d := range ds {
+		sq += (float64(d) - mean) * (float64(d) - mean)
+	}
+	return time.Duration(mean), time.Duration(math.Sqrt(sq / float64(len(ds)-1)))
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
+)
+
+var collation = flag.String("collate", "byte", "how to order station names: byte (by their utf-8 bytes, like the official output) or unicode (with the unicode collation algorithm, so e.g. Abéché comes right after Abha, and Ürümqi among the u's)")
+
+// compareNames orders station names in the output, see -collate.
+var compareNames = strings.Compare
+
+// applyCollation sets compareNames for -collate, and returns stations in that order.
+func applyCollation(stations []brc.Station) ([]brc.Station, error) {
+	switch *collation {
+	case "byte":
+		compareNames = strings.Compare
+		if *groupBy == "" {
+			return stations, nil // they're in byte order already
+		}
+	case "unicode":
+		compareNames = collate.New(language.Und).CompareString
+	default:
+		return nil, fmt.Errorf("unknown -collate %q, want byte or unicode", *collation)
+	}
+	// stable, so the entries of a station (time windows, metrics) stay in their order
+	sorted := slices.Clone(stations)
+	if *groupBy != "" {
+		slices.SortStableFunc(sorted, func(a, b brc.Station) int { return compareKeys(a.Keys, b.Keys) })
+	} else {
+		slices.SortStableFunc(sorted, func(a, b brc.Station) int { return compareNames(a.Name, b.Name) })
+	}
+	return sorted, nil
+}
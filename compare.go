@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"time"
+)
+
+// runCompare is the `compare` subcommand, a built-in replacement for running hyperfine on two -impl values and diffing
+// their outputs. the runs of the two alternate, so drift (thermals, other load) hits both alike.
+func runCompare(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("compare", slices.Concat(without(aggregationFlags, "impl"), []string{"gc"})...) // -impl-a and -impl-b instead
+	implA := fs.String("impl-a", "mmap", "the first -impl to compare")
+	implB := fs.String("impl-b", "scanner", "the second -impl to compare")
+	runs := fs.Int("runs", 5, "number of measured runs of each")
+	warmups := fs.Int("warmup", 1, "number of unmeasured warmup runs of each")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *runs < 1 {
+		return fmt.Errorf("-runs must be at least 1")
+	}
+	if err := applyGC(); err != nil {
+		return err
+	}
+
+	names := []string{*implA, *implB}
+	durations := make([][]time.Duration, len(names))
+	var expected []byte
+	for i := range *warmups + *runs {
+		for j, name := range names {
+			*implName = name
+			runtime.GC()
+			start := time.Now()
+			res, err := aggregate(ctx, log)
+			if err != nil {
+				return fmt.Errorf("%s run %d: %w", name, i, err)
+			}
+			elapsed := time.Since(start)
+
+			var out bytes.Buffer
+			printRes(&out, res.Stations, nil)
+			if expected == nil {
+				expected = out.Bytes()
+			} else if _, err := compareOutputsLoosely(expected, out.Bytes()); err != nil {
+				return fmt.Errorf("%s output doesn't match %s's: %w", name, names[0], err)
+			}
+
+			log.Debug("compare run", "impl", name, "run", i, "warmup", i < *warmups, "elapsed", elapsed)
+			if i >= *warmups {
+				durations[j] = append(durations[j], elapsed)
+			}
+		}
+	}
+
+	means := make([]time.Duration, len(names))
+	for j, name := range names {
+		mean, stddev := meanStddev(durations[j])
+		means[j] = mean
+		fmt.Printf("%s: %.3f s ± %.3f s (min %.3f s, %d runs)\n",
+			name, mean.Seconds(), stddev.Seconds(), slices.Min(durations[j]).Seconds(), len(durations[j]))
+	}
+	fast, slow := 0, 1
+	if means[1] < means[0] {
+		fast, slow = 1, 0
+	}
+	fmt.Printf("outputs match. %s is %.2fx as fast as %s\n", names[fast], means[slow].Seconds()/means[fast].Seconds(), names[slow])
+	return nil
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var configPath = flag.String("config", "", "read flag values from `file`, a flat yaml (name: value) or toml (name = value) file keyed by flag name, e.g. to check benchmark setups into a repo. flags on the command line override it")
+
+// parseFlags parses a subcommand's args into fs, then fills in the flags that weren't given from the -config file, if
+// there is one. flags a subcommand doesn't have but the top level command does are set too, so one file works for
+// run and bench alike.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	_ = fs.Parse(args)
+	if *configPath == "" {
+		return nil
+	}
+	values, err := readConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	given := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, kv := range values {
+		name, value := kv[0], kv[1]
+		if given[name] {
+			continue
+		}
+		set := fs.Set
+		if fs.Lookup(name) == nil {
+			if flag.Lookup(name) == nil {
+				return fmt.Errorf("%s: unknown flag %q", *configPath, name)
+			}
+			set = flag.Set
+		}
+		if err := set(name, value); err != nil {
+			return fmt.Errorf("%s: %s: %w", *configPath, name, err)
+		}
+	}
+	return nil
+}
+
+// readConfig reads the name/value pairs of a config file, in order. it understands the subset of yaml and toml that
+// flags need: one `name: value` or `name = value` per line, # comments, quoted strings and [a, b] lists, which become
+// comma separated values. underscores in names are read as dashes, since toml keys tend to use them.
+func readConfig(path string) ([][2]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	defer f.Close()
+
+	var values [][2]string
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line == "---" {
+			continue
+		}
+		i := strings.IndexAny(line, ":=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: want `name: value` or `name = value`", path, n)
+		}
+		name := strings.ReplaceAll(strings.TrimSpace(line[:i]), "_", "-")
+		value, err := configValue(strings.TrimSpace(line[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		values = append(values, [2]string{name, value})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	return values, nil
+}
+
+// configValue unquotes a value and joins lists, dropping any trailing comment.
+func configValue(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		end := strings.IndexByte(s, ']')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated list %s", s)
+		}
+		var elems []string
+		for _, e := range strings.Split(s[1:end], ",") {
+			if e = strings.TrimSpace(e); e == "" {
+				continue
+			}
+			v, err := configValue(e)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, v)
+		}
+		return strings.Join(elems, ","), nil
+	case strings.HasPrefix(s, `"`):
+		q, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", s)
+		}
+		return strconv.Unquote(q)
+	case strings.HasPrefix(s, "'"):
+		end := strings.IndexByte(s[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", s)
+		}
+		return s[1 : end+1], nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s, nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	brcpb "go.coldcutz.net/1brc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// runCoordinate is the `coordinate` subcommand: it splits the inputs into byte ranges, has `1brc grpc-serve` workers
+// on other machines aggregate them (AggregateRange), and merges what they send back. the workers open the inputs
+// themselves, so they have to be urls or paths every worker can read, and the workers need -allow-urls or -allow-paths.
+// they also have to run with the same -window, -columns and -metrics as the coordinator.
+//
+//	1brc coordinate -remote-workers host1:9090,host2:9090 s3://bucket/measurements.txt
+func runCoordinate(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("coordinate", slices.Concat([]string{"input"}, keyFlags)...) // the workers read and aggregate
+	remoteWorkers := fs.String("remote-workers", "", "comma separated `addresses` of grpc-serve workers")
+	rangeSize := fs.Int64("range-size", 256<<20, "split the inputs into ranges of this many `bytes`")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *remoteWorkers == "" {
+		return errors.New("no -remote-workers")
+	}
+	inputs := fs.Args()
+	if len(inputs) == 0 {
+		inputs = []string{*input}
+	}
+
+	ranges, err := splitRanges(ctx, inputs, *rangeSize)
+	if err != nil {
+		return err
+	}
+	opts, err := aggregationOptions(ctx, log)
+	if err != nil {
+		return err
+	}
+	partials, err := dispatchRanges(ctx, log, strings.Split(*remoteWorkers, ","), ranges)
+	if err != nil {
+		return err
+	}
+	return writeResults(brc.Merge(partials, opts...), nil)
+}
+
+// splitRanges splits the inputs into ranges of rangeSize bytes, the last one of each input being shorter.
+func splitRanges(ctx context.Context, inputs []string, rangeSize int64) ([]*brcpb.Range, error) {
+	rangeSize = max(rangeSize, 1)
+	var ranges []*brcpb.Range
+	for _, in := range inputs {
+		_, size, closeInput, err := openInput(ctx, in)
+		if err != nil {
+			return nil, err
+		}
+		closeInput()
+		for start := int64(0); start < size; start += rangeSize {
+			ranges = append(ranges, &brcpb.Range{Input: in, Start: start, End: min(start+rangeSize, size)})
+		}
+	}
+	return ranges, nil
+}
+
+// dispatchRanges hands the ranges out to the workers, one at a time per worker, and collects the partials they return.
+// the first failure cancels the rest.
+func dispatchRanges(ctx context.Context, log *slog.Logger, addrs []string, ranges []*brcpb.Range) ([]*brc.Partial, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	todo := make(chan *brcpb.Range, len(ranges))
+	for _, r := range ranges {
+		todo <- r
+	}
+	close(todo)
+
+	var mu sync.Mutex
+	var partials []*brc.Partial
+	var wg sync.WaitGroup
+	for _, addr := range addrs {
+		conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", addr, err)
+		}
+		defer conn.Close()
+		client := brcpb.NewAggregatorClient(conn)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range todo {
+				if ctx.Err() != nil {
+					return
+				}
+				resp, err := client.AggregateRange(ctx, r)
+				if err != nil {
+					cancel(fmt.Errorf("%s aggregating %s [%d, %d): %w", addr, r.Input, r.Start, r.End, err))
+					return
+				}
+				for _, b := range resp.Partials {
+					p := new(brc.Partial)
+					if err := p.UnmarshalBinary(b); err != nil {
+						cancel(fmt.Errorf("from %s: %w", addr, err))
+						return
+					}
+					mu.Lock()
+					partials = append(partials, p)
+					mu.Unlock()
+				}
+				log.Debug("range done", "worker", addr, "input", r.Input, "start", r.Start, "end", r.End)
+			}
+		}()
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); err != nil {
+		return nil, err
+	}
+	return partials, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	brcpb "go.coldcutz.net/1brc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// startWorker serves the Aggregator service like `1brc grpc-serve` on a free local port, and counts the AggregateRange
+// calls it gets.
+func startWorker(t *testing.T, allowPaths bool) (string, *atomic.Int64) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	calls := new(atomic.Int64)
+	srv := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		calls.Add(1)
+		return handler(ctx, req)
+	}))
+	brcpb.RegisterAggregatorServer(srv, &aggregatorServer{log: discardLogger(), allowPaths: allowPaths})
+	go srv.Serve(ln)
+	t.Cleanup(srv.Stop)
+	return ln.Addr().String(), calls
+}
+
+func discardLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// writeMeasurements writes n random lines for a handful of stations to a file in dir.
+func writeMeasurements(t *testing.T, dir, name string, n int, rng *rand.Rand) string {
+	t.Helper()
+	var b []byte
+	for range n {
+		b = fmt.Appendf(b, "station%d;%.1f\n", rng.Intn(20), float64(rng.Intn(1999)-999)/10)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCoordinate(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	a := writeMeasurements(t, dir, "a.txt", 3000, rng)
+	b := writeMeasurements(t, dir, "b.txt", 2000, rng)
+
+	// what a single run over both inputs gets
+	da, _ := os.ReadFile(a)
+	db, _ := os.ReadFile(b)
+	both := filepath.Join(dir, "both.txt")
+	if err := os.WriteFile(both, append(da, db...), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	want, err := brc.ProcessFile(both)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// small ranges, so most of them start and end mid line
+	ranges, err := splitRanges(ctx, []string{a, b}, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := (len(da)+999)/1000 + (len(db)+999)/1000; len(ranges) != n {
+		t.Fatalf("got %d ranges, want %d", len(ranges), n)
+	}
+	addr1, calls1 := startWorker(t, true)
+	addr2, calls2 := startWorker(t, true)
+	partials, err := dispatchRanges(ctx, discardLogger(), []string{addr1, addr2}, ranges)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1, c2 := calls1.Load(), calls2.Load(); c1+c2 != int64(len(ranges)) || c1 == 0 || c2 == 0 {
+		t.Errorf("workers got %d and %d of %d ranges, want them all between both", c1, c2, len(ranges))
+	}
+
+	got := brc.Merge(partials)
+	if got.Rows() != want.Rows() {
+		t.Errorf("got %d rows, want %d", got.Rows(), want.Rows())
+	}
+	if len(got.Stations) != len(want.Stations) {
+		t.Fatalf("got %d stations, want %d", len(got.Stations), len(want.Stations))
+	}
+	for i, g := range got.Stations {
+		w := want.Stations[i]
+		if g.Name != w.Name || g.Min != w.Min || g.Max != w.Max || g.Count != w.Count || round1(g.Mean) != round1(w.Mean) {
+			t.Errorf("got %s=%.1f/%.1f/%.1f (%d), want %s=%.1f/%.1f/%.1f (%d)", g.Name, g.Min, g.Mean, g.Max, g.Count, w.Name, w.Min, w.Mean, w.Max, w.Count)
+		}
+	}
+}
+
+func TestCoordinateWorkerError(t *testing.T) {
+	ctx := context.Background()
+	path := writeMeasurements(t, t.TempDir(), "m.txt", 100, rand.New(rand.NewSource(1)))
+	ranges, err := splitRanges(ctx, []string{path}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a worker without -allow-paths turns the ranges down, which fails the whole run
+	addr, _ := startWorker(t, false)
+	_, err = dispatchRanges(ctx, discardLogger(), []string{addr}, ranges)
+	if status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("got %v, want PermissionDenied", err)
+	}
+}
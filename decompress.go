@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// compressedInput reports whether path is compressed like `1brc generate` writes it, going by its extension.
+func compressedInput(path string) bool {
+	switch filepath.Ext(path) {
+	case ".gz", ".zst":
+		return true
+	}
+	return false
+}
+
+// processCompressed aggregates a .gz or .zst file, decompressing it on the way in. there's no telling where a byte of
+// the measurements is in the file without decompressing everything before it, so it can't be mapped or split between
+// the workers, and it's always read like -impl scanner.
+func processCompressed(path string, opts []brc.Option) (*brc.Results, error) {
+	if *ioMode != "cached" {
+		return nil, fmt.Errorf("-io %s doesn't work with a compressed -input", *ioMode)
+	}
+	if *implName != "mmap" && *implName != "scanner" {
+		return nil, fmt.Errorf("-impl %s doesn't work with a compressed -input, it's always read like -impl scanner", *implName)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompressor(path, f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	defer r.Close()
+	return brc.Process(r, opts...)
+}
+
+// decompressor returns a reader decompressing r, which is compressed the way path's extension says. it reads all the
+// gzip members or zstd frames in a row as one stream, like generate writes them.
+func decompressor(path string, r io.Reader) (io.ReadCloser, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return gzip.NewReader(r)
+	case ".zst":
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%s isn't compressed", path)
+}
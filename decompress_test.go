@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// what generate writes compressed, blocks compressed one at a time, reads back like the uncompressed file.
+func TestProcessCompressed(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeMeasurements(t, dir, "m.txt", 5000, rand.New(rand.NewSource(1)))
+	data, err := os.ReadFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := brc.ProcessFile(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"m.txt.gz", "m.txt.zst"} {
+		path := filepath.Join(dir, name)
+		newCompressor, err := blockCompressor(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compress := newCompressor()
+		var out []byte
+		for i := 0; i < len(data); i += 4096 {
+			if out, err = compress(out, data[i:min(i+4096, len(data))]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := os.WriteFile(path, out, 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := processCompressed(path, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if got.Rows() != want.Rows() || len(got.Stations) != len(want.Stations) {
+			t.Fatalf("%s: got %d rows and %d stations, want %d and %d", name, got.Rows(), len(got.Stations), want.Rows(), len(want.Stations))
+		}
+		for i, g := range got.Stations {
+			if w := want.Stations[i]; g.Name != w.Name || g.Min != w.Min || g.Max != w.Max || g.Count != w.Count {
+				t.Errorf("%s: got %+v, want %+v", name, g, w)
+			}
+		}
+		if _, _, _, err := openInput(context.Background(), path); err == nil {
+			t.Errorf("%s: openInput didn't refuse to split it into byte ranges", name)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !linux || purego
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+type directFile struct {
+	io.ReaderAt
+	size int64
+}
+
+func openDirect(path string) (*directFile, error) {
+	return nil, fmt.Errorf("-io direct is only supported on linux, and not with -tags purego")
+}
+
+func (d *directFile) Close() error {
+	return nil
+}
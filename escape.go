@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var escapeNames = flag.String("escape-names", "raw", "how to print non-ascii characters in station names: raw (as utf-8), unicode (as \\uXXXX escapes, like json's) or ascii (transliterated, so Ürümqi becomes Urumqi). it only changes the output, not how stations are told apart or ordered")
+
+// checkEscapeNames checks -escape-names.
+func checkEscapeNames() error {
+	switch *escapeNames {
+	case "raw", "unicode", "ascii":
+		return nil
+	default:
+		return fmt.Errorf("unknown -escape-names %q, want raw, unicode or ascii", *escapeNames)
+	}
+}
+
+// appendName appends a station name to the output, escaped as -escape-names says.
+func appendName(b []byte, name string) []byte {
+	if *escapeNames == "raw" || isASCII(name) {
+		return append(b, name...)
+	}
+	if *escapeNames == "ascii" {
+		return appendTransliterated(b, name)
+	}
+	for _, r := range name {
+		switch {
+		case r < utf8.RuneSelf:
+			b = append(b, byte(r))
+		case r > 0xffff:
+			r1, r2 := utf16.EncodeRune(r)
+			b = appendUEscape(appendUEscape(b, r1), r2)
+		default:
+			b = appendUEscape(b, r)
+		}
+	}
+	return b
+}
+
+// escapeName is appendName for a string.
+func escapeName(name string) string {
+	if *escapeNames == "raw" || isASCII(name) {
+		return name
+	}
+	return string(appendName(nil, name))
+}
+
+func appendUEscape(b []byte, r rune) []byte {
+	b = append(b, `\u`...)
+	for i := len(strconv.FormatInt(int64(r), 16)); i < 4; i++ {
+		b = append(b, '0')
+	}
+	return strconv.AppendInt(b, int64(r), 16)
+}
+
+// asciiReplacements are letters that don't decompose into an ascii letter plus accents.
+var asciiReplacements = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'ø': "o", 'Ø': "O", 'œ': "oe", 'Œ': "OE", 'ł': "l", 'Ł': "L", 'đ': "d",
+	'Đ': "D", 'ð': "d", 'Ð': "D", 'þ': "th", 'Þ': "Th", 'ı': "i", 'ʻ': "'", '’': "'", '‘': "'",
+}
+
+// appendTransliterated appends name with its accents stripped (decomposed, compatibility forms included, with the
+// combining marks dropped) and the other non-ascii letters spelled out where there's a usual way to, or replaced by ?.
+func appendTransliterated(b []byte, name string) []byte {
+	for _, r := range norm.NFKD.String(name) {
+		switch {
+		case r < utf8.RuneSelf:
+			b = append(b, byte(r))
+		case unicode.Is(unicode.Mn, r):
+		case asciiReplacements[r] != "":
+			b = append(b, asciiReplacements[r]...)
+		default:
+			b = append(b, '?')
+		}
+	}
+	return b
+}
+
+func isASCII(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r >= utf8.RuneSelf }) < 0
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/fs"
+	"log/slog"
+	"os"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var errorFormat = flag.String("errors", "text", "how to report a failure on stderr: text (a log line) or json (an object with the error, its kind and exit code, and where the line is for malformed lines)")
+
+// exit codes, so scripts can tell failures apart without parsing messages. 2 is taken by the flag package, for bad
+// usage.
+const (
+	exitInternal      = 1
+	exitInputNotFound = 3
+	exitParseError    = 4
+)
+
+// errorKind classifies err, returning its kind (as reported by -errors json) and exit code.
+func errorKind(err error) (string, int) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return "input-not-found", exitInputNotFound
+	case errors.Is(err, brc.ErrMalformedLine):
+		return "parse-error", exitParseError
+	default:
+		return "internal", exitInternal
+	}
+}
+
+type errorReport struct {
+	Command   string            `json:"command"`
+	Kind      string            `json:"kind"`
+	ExitCode  int               `json:"exit_code"`
+	Error     string            `json:"error"`
+	Malformed *malformedLineErr `json:"malformed_line,omitempty"`
+}
+
+type malformedLineErr struct {
+	Line   int64  `json:"line"`
+	Offset int64  `json:"offset"`
+	Text   string `json:"text"`
+}
+
+// reportError reports that subcommand cmd failed with err in the -errors format, and returns the exit code for it.
+func reportError(log *slog.Logger, cmd string, err error) int {
+	kind, code := errorKind(err)
+	if *errorFormat != "json" {
+		log.Error("error", "cmd", cmd, "err", err)
+		return code
+	}
+	report := errorReport{Command: cmd, Kind: kind, ExitCode: code, Error: err.Error()}
+	var le *brc.LineError
+	if errors.As(err, &le) {
+		report.Malformed = &malformedLineErr{Line: le.Line, Offset: le.Offset, Text: string(le.Text)}
+	}
+	b, _ := json.Marshal(report)
+	_, _ = os.Stderr.Write(append(b, '\n'))
+	return code
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+)
+
+var gcMode = flag.String("gc", "off", "garbage collection during the run: off (fastest, since aggregating hardly allocates), env (leave it to GOGC) or a GOGC style `percent`. -max-memory still holds with it off")
+
+// applyGC configures the garbage collector for -gc, so runs are fast without having to set GOGC=off.
+func applyGC() error {
+	switch *gcMode {
+	case "env":
+	case "off":
+		debug.SetGCPercent(-1)
+	default:
+		n, err := strconv.Atoi(*gcMode)
+		if err != nil || n < 0 {
+			return fmt.Errorf("bad -gc %q (want off, env or a percentage)", *gcMode)
+		}
+		debug.SetGCPercent(n)
+	}
+	return nil
+}
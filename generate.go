@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+//go:embed weather_stations.csv
+var weatherStationsCSV string
+
+type weatherStation struct {
+	name         string
+	mean, stddev float64
+}
+
+// runGenerate is the `generate` subcommand. by default it does what the official create_measurements script does:
+// picks a random station for every row and draws its temperature from a gaussian (stddev 10) around the station's
+// mean. the parameters used are recorded in a # comment at the top of the file (which the aggregator skips) so
+// benchmark numbers can be tied back to the exact data they were measured on. the same flags with the same -seed
+// make the same file, byte for byte.
+func runGenerate(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	shareFlags(fs, "errors", "v", "q")
+	rows := fs.Int("n", 1_000_000_000, "number of rows to generate")
+	out := fs.String("o", defaultInput, "write measurements to `file`, compressed if it ends in .gz or .zst (which run reads back like -impl scanner)")
+	names := fs.String("names", "official", "where station names come from: official, random (random UTF-8 names) or file:`path` (name[;mean[;stddev]] per line)")
+	stationsFile := fs.String("stations", "", "read stations from `file`, a name;mean[;stddev] per line like weather_stations.csv, instead of the built-in list. the same as -names file:file")
+	randomStations := fs.Int("random-stations", 10_000, "how many stations to make up with -names random")
+	mean := fs.Float64("mean", 15, "mean temperature for stations that don't have their own")
+	stddev := fs.Float64("stddev", 10, "temperature stddev for stations that don't have their own")
+	distribution := fs.String("distribution", "uniform", "how often each station comes up: uniform, or zipf:`s` (s > 1) for a few stations making up most rows")
+	seed := fs.Int64("seed", -1, "seed for everything random, so runs with the same flags write identical files (-1 picks one)")
+	shuffleSeed := fs.Int64("shuffle-seed", -1, "seed for made-up station names and the order stations are interleaved in, if it should differ from -seed (-1 uses -seed)")
+	workers := fs.Int("workers", runtime.GOMAXPROCS(0), "generate with `N` workers")
+	header := fs.Bool("header", true, "record the generator parameters in a # comment on the first line")
+	_ = fs.Parse(args)
+	if *rows < 0 {
+		return fmt.Errorf("-n must be at least 0, not %d", *rows)
+	}
+	if *stationsFile != "" {
+		if *names != "official" {
+			return fmt.Errorf("-stations and -names %s don't go together", *names)
+		}
+		*names = "file:" + *stationsFile
+	}
+
+	if *seed < 0 {
+		*seed = rand.Int64()
+	}
+	if *shuffleSeed < 0 {
+		*shuffleSeed = *seed
+	}
+	shuffle := rand.New(rand.NewPCG(uint64(*shuffleSeed), 0))
+
+	stations, err := loadGeneratorStations(*names, *randomStations, shuffle)
+	if err != nil {
+		return fmt.Errorf("loading stations: %w", err)
+	}
+	newPicker, err := stationPicker(*distribution, len(stations), shuffle)
+	if err != nil {
+		return err
+	}
+	for i := range stations {
+		if math.IsNaN(stations[i].mean) {
+			stations[i].mean = *mean
+		}
+		if math.IsNaN(stations[i].stddev) {
+			stations[i].stddev = *stddev
+		}
+	}
+
+	newCompressor, err := blockCompressor(*out)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(*out)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", *out, err)
+	}
+	defer f.Close()
+
+	var offset int64
+	if *header {
+		hdr := fmt.Appendf(nil, "# 1brc generate -n %d -names %s -random-stations %d -mean %g -stddev %g -distribution %s -seed %d -shuffle-seed %d (%d stations)\n",
+			*rows, *names, *randomStations, *mean, *stddev, *distribution, *seed, *shuffleSeed, len(stations))
+		if newCompressor != nil {
+			if hdr, err = newCompressor()(nil, hdr); err != nil {
+				return fmt.Errorf("compressing header: %w", err)
+			}
+		}
+		n, err := f.Write(hdr)
+		if err != nil {
+			return fmt.Errorf("writing header: %w", err)
+		}
+		offset = int64(n)
+	}
+
+	if err := generate(ctx, f, offset, stations, *rows, max(*workers, 1), *seed, *shuffleSeed, newPicker, newCompressor); err != nil {
+		return fmt.Errorf("generating: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	log.Info("generated measurements", "file", *out, "rows", *rows, "stations", len(stations))
+	return nil
+}
+
+func loadGeneratorStations(names string, randomStations int, r *rand.Rand) ([]weatherStation, error) {
+	switch {
+	case names == "official":
+		return parseWeatherStations(strings.NewReader(weatherStationsCSV))
+	case names == "random":
+		return randomWeatherStations(randomStations, r), nil
+	case strings.HasPrefix(names, "file:"):
+		path := strings.TrimPrefix(names, "file:")
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", path, err)
+		}
+		defer f.Close()
+		return parseWeatherStations(f)
+	default:
+		return nil, fmt.Errorf("unknown name source %q", names)
+	}
+}
+
+// randomNameRunes are the ranges random station names are drawn from: ascii and latin-1 letters, greek, cyrillic
+// and some CJK, so names come out at a mix of 1-3 bytes per rune.
+var randomNameRunes = [][2]rune{{'a', 'z'}, {'A', 'Z'}, {'à', 'ÿ'}, {'α', 'ω'}, {'а', 'я'}, {'一', '龥'}}
+
+// randomWeatherStations makes up n distinct stations with names of 1-100 bytes (the limits from the challenge rules)
+// and means spread across typical temperatures.
+func randomWeatherStations(n int, r *rand.Rand) []weatherStation {
+	seen := make(map[string]struct{}, n)
+	stations := make([]weatherStation, 0, n)
+	var name []byte
+	for len(stations) < n {
+		name = name[:0]
+		targetLen := 1 + r.IntN(100)
+		for {
+			rng := randomNameRunes[r.IntN(len(randomNameRunes))]
+			c := rng[0] + r.Int32N(rng[1]-rng[0]+1)
+			if len(name)+utf8.RuneLen(c) > targetLen {
+				break
+			}
+			name = utf8.AppendRune(name, c)
+		}
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := seen[string(name)]; ok {
+			continue
+		}
+		seen[string(name)] = struct{}{}
+		stations = append(stations, weatherStation{name: string(name), mean: float64(r.IntN(400)-100) / 10, stddev: math.NaN()})
+	}
+	return stations
+}
+
+// stationPicker returns a func that makes a picker of the station for each row, per -distribution, for every block of
+// rows to have its own. with zipf, the station that comes up the most is picked at random rather than being the first
+// one in the list.
+func stationPicker(distribution string, n int, r *rand.Rand) (func(r *rand.Rand) func() int, error) {
+	if distribution == "uniform" {
+		return func(r *rand.Rand) func() int {
+			return func() int { return r.IntN(n) }
+		}, nil
+	}
+	arg, ok := strings.CutPrefix(distribution, "zipf:")
+	if !ok {
+		return nil, fmt.Errorf("unknown distribution %q (want uniform or zipf:s)", distribution)
+	}
+	skew, err := strconv.ParseFloat(arg, 64)
+	if err != nil || !(skew > 1) {
+		return nil, fmt.Errorf("bad zipf exponent %q: it has to be a number above 1", arg)
+	}
+	rank := r.Perm(n)
+	return func(r *rand.Rand) func() int {
+		z := rand.NewZipf(r, skew, 1, uint64(n-1))
+		return func() int { return rank[z.Uint64()] }
+	}, nil
+}
+
+// generateBlockRows is how many rows make up a block. blocks are generated independently, with their own random
+// sources seeded from the block number, so the file comes out the same however many workers there are.
+const generateBlockRows = 1 << 18
+
+// compressFunc appends the compressed form of src to dst.
+type compressFunc func(dst, src []byte) ([]byte, error)
+
+// blockCompressor returns a func making a compressFunc per worker if path calls for compressed output, or nil. every
+// block becomes a gzip member or zstd frame of its own, which decompressors read as one stream, so the blocks can be
+// compressed in parallel. the compression package has assembly of its own, which it leaves out with -tags noasm rather
+// than purego.
+func blockCompressor(path string) (func() compressFunc, error) {
+	switch filepath.Ext(path) {
+	case ".gz":
+		return func() compressFunc {
+			zw := gzip.NewWriter(nil)
+			return func(dst, src []byte) ([]byte, error) {
+				buf := bytes.NewBuffer(dst)
+				zw.Reset(buf)
+				if _, err := zw.Write(src); err != nil {
+					return nil, err
+				}
+				if err := zw.Close(); err != nil {
+					return nil, err
+				}
+				return buf.Bytes(), nil
+			}
+		}, nil
+	case ".zst":
+		return func() compressFunc {
+			// NewWriter only fails on bad options
+			enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+			return func(dst, src []byte) ([]byte, error) {
+				return enc.EncodeAll(src, dst), nil
+			}
+		}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// generate writes rows to f from offset on. workers each generate a block into their own buffer (and compress it, if
+// newCompressor isn't nil), then wait for the block before theirs to know its size, and pwrite theirs right after
+// it, so the blocks end up in order without going through a single writer.
+func generate(ctx context.Context, f *os.File, offset int64, stations []weatherStation, rows, workers int, seed, shuffleSeed int64, newPicker func(r *rand.Rand) func() int, newCompressor func() compressFunc) error {
+	blocks := (rows + generateBlockRows - 1) / generateBlockRows
+	// ends[b] gets the offset block b ends at, once it's known
+	ends := make([]chan int64, blocks+1)
+	for b := range ends {
+		ends[b] = make(chan int64, 1)
+	}
+	ends[0] <- offset
+
+	// size the buffers for a block of average lines, with some room to spare, so they rarely have to grow
+	var names int
+	for _, s := range stations {
+		names += len(s.name)
+	}
+	blockBytes := generateBlockRows * (names/len(stations) + len(";-99.9\n")) * 9 / 8
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for range min(workers, blocks) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, 0, blockBytes)
+			var compress compressFunc
+			var compressed []byte
+			if newCompressor != nil {
+				compress = newCompressor()
+			}
+			for {
+				b := int(next.Add(1) - 1)
+				if b >= blocks || ctx.Err() != nil {
+					return
+				}
+				n := min(generateBlockRows, rows-b*generateBlockRows)
+				pick := newPicker(rand.New(rand.NewPCG(uint64(shuffleSeed), uint64(2*b+2))))
+				temps := rand.New(rand.NewPCG(uint64(seed), uint64(2*b+3)))
+				buf = buf[:0]
+				for range n {
+					s := stations[pick()]
+					buf = append(buf, s.name...)
+					buf = append(buf, ';')
+					buf = strconv.AppendFloat(buf, randomTemp(temps, s.mean, s.stddev), 'f', 1, 64)
+					buf = append(buf, '\n')
+				}
+				out := buf
+				if compress != nil {
+					var err error
+					if compressed, err = compress(compressed[:0], buf); err != nil {
+						cancel(err)
+						return
+					}
+					out = compressed
+				}
+
+				var start int64
+				select {
+				case start = <-ends[b]:
+				case <-ctx.Done():
+					return
+				}
+				ends[b+1] <- start + int64(len(out))
+				if _, err := f.WriteAt(out, start); err != nil {
+					cancel(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	return nil
+}
+
+func randomTemp(r *rand.Rand, mean, stddev float64) float64 {
+	t := math.Round((r.NormFloat64()*stddev+mean)*10) / 10
+	// the parser only handles -99.9..99.9, and we don't want to print "-0.0"
+	t = max(-99.9, min(99.9, t))
+	if t == 0 {
+		t = 0
+	}
+	return t
+}
+
+// parseWeatherStations reads the name;mean format used by the official weather_stations.csv, skipping # comments.
+// the mean can be left out, and a stddev can be added as a third field; missing values are NaN.
+func parseWeatherStations(r io.Reader) ([]weatherStation, error) {
+	var stations []weatherStation
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ";")
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("too many fields in line %q", line)
+		}
+		if fields[0] == "" {
+			return nil, fmt.Errorf("empty station name in line %q", line)
+		}
+		s := weatherStation{name: fields[0], mean: math.NaN(), stddev: math.NaN()}
+		for i, dst := range []*float64{&s.mean, &s.stddev} {
+			if len(fields) <= i+1 {
+				break
+			}
+			v, err := strconv.ParseFloat(fields[i+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("parsing line %q: %w", line, err)
+			}
+			*dst = v
+		}
+		stations = append(stations, s)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(stations) == 0 {
+		return nil, fmt.Errorf("no stations")
+	}
+	return stations, nil
+}
@@ -7,16 +7,16 @@ toolchain go1.22.1
 require (
 	github.com/cespare/xxhash/v2 v2.2.0
 	github.com/kamstrup/intmap v0.2.0
-	go.coldcutz.net/go-stuff v0.0.0-20240222020121-e7bc41ea880c
+	github.com/klauspost/compress v1.17.4
 	golang.org/x/exp v0.0.0-20240318143956-a85f2c67cd81
+	golang.org/x/sys v0.14.0
+	golang.org/x/text v0.14.0
+	google.golang.org/grpc v1.61.1
+	google.golang.org/protobuf v1.31.0
 )
 
 require (
 	github.com/golang/protobuf v1.5.3 // indirect
 	golang.org/x/net v0.18.0 // indirect
-	golang.org/x/sys v0.14.0 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20231106174013-bbf56f31fb17 // indirect
-	google.golang.org/grpc v1.61.1 // indirect
-	google.golang.org/protobuf v1.31.0 // indirect
 )
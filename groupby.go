@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// groupByColumns are the -group-by key columns, nil without it.
+func groupByColumns() []string {
+	if *groupBy == "" {
+		return nil
+	}
+	return strings.Split(*groupBy, ",")
+}
+
+// checkGroupByFlags rejects the flags -group-by doesn't go with. the text format nests stations in their groups, so
+// it can't print them in any other order.
+func checkGroupByFlags() error {
+	if *groupBy == "" {
+		return nil
+	}
+	if *format == "text" && (*top > 0 || *bottom > 0 || *sortBy != "name" || *desc || *includeMissing != "") {
+		return fmt.Errorf("-group-by prints nested groups, which doesn't go with -top, -bottom, -sort, -desc or -include-missing (try -format tsv)")
+	}
+	return nil
+}
+
+// checkGroupKeys makes sure every station had as many key columns as -group-by names, which the aggregation doesn't
+// check as it goes.
+func checkGroupKeys(stations []brc.Station) error {
+	want := len(groupByColumns())
+	for _, s := range stations {
+		if len(s.Keys) != want {
+			return fmt.Errorf("%w: %q has %d key columns, but -group-by has %d", brc.ErrMalformedLine, s.Name, len(s.Keys), want)
+		}
+	}
+	return nil
+}
+
+// compareKeys orders stations by their -group-by keys, outermost first, so that groups are contiguous.
+func compareKeys(a, b []string) int {
+	for i := range min(len(a), len(b)) {
+		if c := compareNames(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+	return len(a) - len(b)
+}
+
+// appendGrouped appends the results in the 1brc format, with the stations nested in their groups:
+// {France={Lyon=...,Paris=...,},Germany={...},}. stations have to be sorted by compareKeys.
+func appendGrouped(b []byte, stations []brc.Station) []byte {
+	b = append(b, '{')
+	var open []string // keys of the groups we're in
+	for i := range stations {
+		s := stations[i]
+		keys := s.Keys
+		common := 0
+		for common < len(open) && common < len(keys)-1 && open[common] == keys[common] {
+			common++
+		}
+		for len(open) > common {
+			b = append(b, "},"...)
+			open = open[:len(open)-1]
+		}
+		for _, k := range keys[common : len(keys)-1] {
+			b = appendName(b, k)
+			b = append(b, "={"...)
+			open = append(open, k)
+		}
+		s.Name = keys[len(keys)-1]
+		b = appendStation(b, &s)
+	}
+	for range open {
+		b = append(b, "},"...)
+	}
+	return append(b, "}\n"...)
+}
+
+// keyLabels returns the names of the label columns tsv and table output start with: the -group-by columns, or just
+// station.
+func keyLabels() []string {
+	if cols := groupByColumns(); cols != nil {
+		return cols
+	}
+	return []string{"station"}
+}
+
+// keyValues returns s's values for keyLabels.
+func keyValues(s *brc.Station) []string {
+	keys := []string{s.Name}
+	if s.Keys != nil {
+		keys = slices.Clone(s.Keys)
+	}
+	for i, k := range keys {
+		keys[i] = escapeName(k)
+	}
+	return keys
+}
+
+var groupPrefix = flag.String("group-prefix", "", "aggregate stations by the first `N` bytes of their names (extended to a whole character), or by their first word with token, to roll them up without changing the input")
+
+// groupPrefixKey returns the key -group-prefix rolls stations up by.
+func groupPrefixKey(prefix string) (func(station string) string, error) {
+	if prefix == "token" {
+		return func(station string) string {
+			word, _, _ := strings.Cut(station, " ")
+			return word
+		}, nil
+	}
+	n, err := strconv.Atoi(prefix)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("bad -group-prefix %q, want a number of bytes or token", prefix)
+	}
+	return func(station string) string {
+		if len(station) <= n {
+			return station
+		}
+		i := n
+		for i < len(station) && !utf8.RuneStart(station[i]) {
+			i++
+		}
+		return station[:i]
+	}, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	"go.coldcutz.net/1brc/pkg/objstore"
+	brcpb "go.coldcutz.net/1brc/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// runGRPCServe is the `grpc-serve` subcommand, which serves the Aggregator service from proto/brc.proto.
+func runGRPCServe(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("grpc-serve", without(aggregationFlags, "input")...) // the input comes with the call
+	addr := fs.String("addr", "localhost:9090", "`address` to listen on")
+	allowPaths := fs.Bool("allow-paths", false, "let AggregateRange calls read arbitrary files on this machine")
+	allowURLs := fs.Bool("allow-urls", false, "let AggregateRange calls have the server fetch http(s), s3 and gs urls")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := loadEnginePlugins(); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", *addr, err)
+	}
+	srv := grpc.NewServer()
+	brcpb.RegisterAggregatorServer(srv, &aggregatorServer{log: log, allowPaths: *allowPaths, allowURLs: *allowURLs})
+	context.AfterFunc(ctx, srv.GracefulStop)
+
+	log.Info("serving grpc", "addr", ln.Addr().String())
+	return srv.Serve(ln)
+}
+
+type aggregatorServer struct {
+	brcpb.UnimplementedAggregatorServer
+	log                   *slog.Logger
+	allowPaths, allowURLs bool
+}
+
+// Aggregate feeds the chunks from stream to brc.Process through a pipe, which takes care of lines split across
+// chunks.
+func (s *aggregatorServer) Aggregate(stream brcpb.Aggregator_AggregateServer) error {
+	pr, pw := io.Pipe()
+	go func() {
+		for {
+			c, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					err = nil
+				}
+				pw.CloseWithError(err)
+				return
+			}
+			if _, err := pw.Write(c.Data); err != nil {
+				return // the reader gave up
+			}
+		}
+	}()
+
+	opts, err := aggregationOptions(stream.Context(), s.log)
+	if err != nil {
+		pr.CloseWithError(err)
+		return status.Error(codes.Internal, err.Error())
+	}
+	res, err := brc.Process(pr, opts...)
+	pr.CloseWithError(err) // unblock the receiver if we bailed early
+	if err != nil {
+		if stream.Context().Err() != nil {
+			return status.FromContextError(stream.Context().Err()).Err()
+		}
+		return status.Errorf(codes.InvalidArgument, "aggregating: %v", err)
+	}
+	s.log.Info("aggregated", "rows", res.Rows(), "stations", len(res.Stations))
+	return stream.SendAndClose(resultsProto(res))
+}
+
+// AggregateRange aggregates a byte range of an input for a coordinator, see `1brc coordinate`.
+func (s *aggregatorServer) AggregateRange(ctx context.Context, req *brcpb.Range) (*brcpb.Partials, error) {
+	if objstore.IsURL(req.Input) && !s.allowURLs {
+		return nil, status.Error(codes.PermissionDenied, "aggregating urls is disabled, see -allow-urls")
+	} else if !objstore.IsURL(req.Input) && !s.allowPaths {
+		return nil, status.Error(codes.PermissionDenied, "aggregating paths is disabled, see -allow-paths")
+	}
+	r, size, closeInput, err := openInput(ctx, req.Input)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "%v", err)
+	}
+	defer closeInput()
+
+	opts, err := aggregationOptions(ctx, s.log)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	// the coordinator merges them, and it's up to it whether the standard deviations are printed
+	partials, err := brc.ProcessRange(r, size, req.Start, min(req.End, size), append(opts, brc.WithStddev(true))...)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		return nil, status.Errorf(codes.InvalidArgument, "aggregating: %v", err)
+	}
+	resp := &brcpb.Partials{}
+	for _, p := range partials {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		resp.Partials = append(resp.Partials, b)
+	}
+	s.log.Info("aggregated range", "input", req.Input, "start", req.Start, "end", req.End)
+	return resp, nil
+}
+
+// resultsProto converts res to its message in brc.proto, with the values rounded like in the text output.
+func resultsProto(res *brc.Results) *brcpb.Results {
+	m := &brcpb.Results{Rows: res.Rows(), Stations: make([]*brcpb.Station, len(res.Stations))}
+	for i, s := range res.Stations {
+		m.Stations[i] = &brcpb.Station{Name: s.Name, Min: round1(s.Min), Mean: round1(s.Mean), Max: round1(s.Max), Count: s.Count}
+	}
+	return m
+}
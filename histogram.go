@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var histogramPath = flag.String("histogram", "", "also write a per-station histogram of readings (1°C bins) as json to `file`, for plotting")
+
+type jsonHistogram struct {
+	BinStart int                `json:"bin_start"` // lower edge of the first bin, in °C
+	BinWidth int                `json:"bin_width"`
+	Stations []jsonStationCount `json:"stations"`
+}
+
+type jsonStationCount struct {
+	Name   string  `json:"name"`
+	Counts []int64 `json:"counts"`
+}
+
+// writeHistograms writes res's histograms as json. stations without one are left out.
+func writeHistograms(path string, res *brc.Results) error {
+	out := jsonHistogram{BinStart: brc.HistogramStart, BinWidth: 1, Stations: make([]jsonStationCount, 0, len(res.Stations))}
+	for i := range res.Stations {
+		if counts, ok := res.Stations[i].Histogram(); ok {
+			out.Stations = append(out.Stations, jsonStationCount{Name: res.Stations[i].Name, Counts: counts})
+		}
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(out); err != nil {
+		f.Close()
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	"golang.org/x/exp/maps"
+)
+
+var implName = flag.String("impl", "mmap", "how to read a local -input: mmap (map the file and split it between the workers, fastest), scanner (read it front to back in blocks, like a pipe), pread (split it between the workers, which read their chunks with pread) or readahead (pread, with each worker reading its next block in the background while it parses the current one)")
+var arch = flag.String("arch", "chunks", "how the work is split between the workers: chunks (each one reads and aggregates its own share of the input) or pipeline (-readers goroutines read the input and send batches of lines over a channel to whichever worker is free, for comparison). with pipeline, local files are read like -impl pread unless it's -impl scanner")
+var pipelineReaders = flag.Int("readers", 2, "with -arch pipeline, how many goroutines read the input. inputs that can only be read front to back get one")
+var batchSize = flag.Int("batch-size", 256<<10, "with -arch pipeline, send the workers batches of lines of about this many `bytes`")
+var adaptWorkers = flag.Duration("adapt-workers", 0, "with -arch pipeline, start with half the workers and every `interval` spawn or park one, depending on whether the readers or the workers are waiting on the other. -worker-stats shows the decisions")
+var chunkSize = flag.String("chunk-size", "", "split the input into chunks of about this `size`, e.g. 32M, which the workers take from a shared queue as they go, instead of one chunk per worker. auto picks it from the input size, the number of workers and the cpu's cache sizes. smaller chunks even out workers that run at different speeds. mmap, pread and readahead only")
+var ioMode = flag.String("io", "cached", "how to read a local -input: cached (through the page cache) or direct (with O_DIRECT into aligned buffers, bypassing it, to measure cold-disk performance. linux only, and always reads like -impl pread)")
+
+// impls are the ways of reading a local input, all built into the one binary so the slower ones keep building and can
+// be compared against mmap with the same flags, e.g. with `1brc bench -impl scanner`. they give the same results. the
+// file-only options (madvise, huge pages, prefault, index, follow) only apply to mmap.
+var impls = map[string]func(path string, opts []brc.Option) (*brc.Results, error){
+	"mmap": func(path string, opts []brc.Option) (*brc.Results, error) {
+		return brc.ProcessFile(path, opts...)
+	},
+	"scanner": func(path string, opts []brc.Option) (*brc.Results, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return brc.Process(f, opts...)
+	},
+	"pread": processPread,
+	"readahead": func(path string, opts []brc.Option) (*brc.Results, error) {
+		return processPread(path, append(opts, brc.WithReadAhead(true)))
+	},
+}
+
+func processPread(path string, opts []brc.Option) (*brc.Results, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return brc.ProcessReaderAt(f, fi.Size(), opts...)
+}
+
+// processLocal aggregates a local file with the -impl implementation, or with O_DIRECT reads for -io direct.
+func processLocal(path string, opts []brc.Option) (*brc.Results, error) {
+	switch *ioMode {
+	case "cached":
+	case "direct":
+		if *implName != "mmap" && *implName != "pread" {
+			return nil, fmt.Errorf("-io direct doesn't work with -impl %s", *implName)
+		}
+		d, err := openDirect(path)
+		if err != nil {
+			return nil, err
+		}
+		defer d.Close()
+		return brc.ProcessReaderAt(d, d.size, opts...)
+	default:
+		return nil, fmt.Errorf("unknown -io %q (want cached or direct)", *ioMode)
+	}
+	impl, ok := impls[*implName]
+	if !ok {
+		names := maps.Keys(impls)
+		slices.Sort(names)
+		return nil, fmt.Errorf("unknown -impl %q (have %s)", *implName, strings.Join(names, ", "))
+	}
+	if *arch == "pipeline" && *implName == "mmap" {
+		impl = impls["pread"] // the readers copy the lines out of the input either way
+	}
+	return impl(path, opts)
+}
+
+// archOptions returns the library options for -arch.
+func archOptions() ([]brc.Option, error) {
+	switch *arch {
+	case "chunks":
+		if *adaptWorkers != 0 {
+			return nil, fmt.Errorf("-adapt-workers only works with -arch pipeline")
+		}
+		switch *chunkSize {
+		case "":
+			return nil, nil
+		case "auto":
+			return []brc.Option{brc.WithChunkSize(brc.ChunkSizeAuto)}, nil
+		}
+		var size byteSize
+		if err := size.Set(*chunkSize); err != nil || size == 0 {
+			return nil, fmt.Errorf("bad -chunk-size %q, want a size like 32M or auto", *chunkSize)
+		}
+		return []brc.Option{brc.WithChunkSize(int64(size))}, nil
+	case "pipeline":
+		if *follow || *chunkSize != "" {
+			return nil, fmt.Errorf("-follow and -chunk-size only work with -arch chunks")
+		}
+		if *adaptWorkers < 0 {
+			return nil, fmt.Errorf("-adapt-workers must be positive")
+		}
+		if *pipelineReaders < 1 {
+			return nil, fmt.Errorf("-readers must be at least 1")
+		}
+		return []brc.Option{brc.WithPipeline(*pipelineReaders, *batchSize), brc.WithAdaptiveWorkers(*adaptWorkers)}, nil
+	default:
+		return nil, fmt.Errorf("unknown -arch %q (want chunks or pipeline)", *arch)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// writeJSONLines writes one json object per station, in output order, like the objects in serve's responses. each
+// one is flushed as soon as it's encoded instead of the whole output being built first, so a consumer reading a pipe
+// can get going on the first stations while the rest are still being written.
+func writeJSONLines(w *bufio.Writer, stations []brc.Station) error {
+	var b []byte
+	for i := range stations {
+		line, err := json.Marshal(newJSONStation(&stations[i]))
+		if err != nil {
+			return err
+		}
+		// non-ascii only appears inside strings, so escaping the whole line is the same as escaping the names
+		b = append(appendName(b[:0], string(line)), '\n')
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+)
+
+var verbose = flag.Bool("v", false, "also log debug messages")
+var quiet = flag.Bool("q", false, "only log errors")
+
+// flagLevel is the log level -v and -q ask for. it's looked up on every log call, so the logger can be created before
+// the flags are parsed.
+type flagLevel struct{}
+
+func (flagLevel) Level() slog.Level {
+	switch {
+	case *quiet:
+		return slog.LevelError
+	case *verbose:
+		return slog.LevelDebug
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// newLogger returns the logger for diagnostics. they all go to stderr, so stdout only ever has results on it and
+// `1brc > out.txt` is clean.
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: flagLevel{}}))
+}
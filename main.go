@@ -1,39 +1,220 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
+	"context"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
 	"runtime/pprof"
 	"runtime/trace"
 	"slices"
-	"sync"
+	"strconv"
+	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
-	"github.com/cespare/xxhash/v2"
-	"github.com/kamstrup/intmap"
-	"go.coldcutz.net/go-stuff/utils"
+	"go.coldcutz.net/1brc/pkg/brc"
+	"go.coldcutz.net/1brc/pkg/objstore"
 	"golang.org/x/exp/maps"
 )
 
+var input = flag.String("input", defaultInput, "read measurements from `path`, a local file, a named pipe, a unix socket to connect to, unix:path to listen on one, or an http(s)://, s3:// or gs:// url. local files ending in .gz or .zst are decompressed as they're read")
+var workers = flag.Int("workers", 0, "aggregate with `N` workers (default one per cpu, within the cgroup's cpu quota on linux)")
 var cpuprofile = flag.String("cpuprofile", "", "write cpu profile to `file`")
 var memprofile = flag.String("memprofile", "", "write memory profile to `file`")
 var traceprofile = flag.String("trace", "", "write trace to `file`")
+var madvise = flag.Bool("madvise", false, "madvise(MADV_SEQUENTIAL|MADV_WILLNEED) each worker's chunk so readahead keeps up on cold-cache runs")
+var hugepages = flag.String("hugepages", "off", "back the mapping with transparent huge pages: off, advise (madvise the file mapping) or copy (copy into an anonymous THP mapping)")
+var prefault = flag.Bool("prefault", false, "fault in all of the mapping before the workers start (MAP_POPULATE, linux only), so warm-cache benchmarks don't measure page faults")
+var pin = flag.Bool("pin", false, "pin each worker to its own cpu (linux only)")
+var smt = flag.String("smt", "on", "on runs a worker per logical cpu. off runs one per physical core, pinned to a thread of its own core (linux only), for when hyperthread siblings fighting over a core's caches and memory bandwidth cost more than they add")
+var nice = flag.Int("nice", 0, "set the process nice value (linux only)")
+var ionice = flag.String("ionice", "", "set the process io priority as `class[:level]`, e.g. idle or best-effort:7 (linux only)")
+var realtimeIsh = flag.Bool("realtime-ish", false, "for benchmark runs: nice -20 and realtime io priority, to cut down on scheduling jitter (needs privileges)")
+var follow = flag.Bool("follow", false, "if the file grows while it's being processed, keep consuming appended data until it stops growing")
+var followIdle = flag.Duration("follow-idle", time.Second, "with -follow, how long the file has to stop growing before results are printed")
+var followPrint = flag.Duration("follow-print", 0, "follow the file as a live aggregator: print the results once the data that's there is done, then again every `interval` that appended data, until interrupted (ignores -follow-idle)")
+var writeIndex = flag.Bool("write-index", false, "write a sidecar index of line-aligned offsets next to the input, so later runs can plan chunks without scanning")
+var useIndex = flag.Bool("use-index", true, "plan chunks from the sidecar index if there's an up to date one")
+var includeMissing = flag.String("include-missing", "", "also print every station listed in `file`, even if it's absent from the data. only with -format text")
+var workerStats = flag.Bool("worker-stats", false, "print a per-worker timing and throughput table to stderr after the run")
+var summary = flag.Bool("summary", false, "print the number of distinct stations, total rows and bytes read to stderr after the results, to sanity check that no lines went missing")
+var throughput = flag.Bool("stats", false, "print the wall time of the aggregation, without input setup and output, as rows/s and GB/s to stderr after the run")
+var tableStats = flag.Bool("table-stats", false, "print per-worker hash table statistics (size, load factor, probe lengths, collisions) to stderr after the run")
+var partialOnInterrupt = flag.Bool("partial", false, "if interrupted, print the results aggregated so far (and still exit non-zero)")
+var withStddev = flag.Bool("stddev", false, "also print each station's standard deviation, as min/mean/max/stddev")
+var percentiles = flag.String("percentiles", "", "also print approximate percentiles for each station, after min/mean/max, e.g. `p50,p95,p99` (keeps a t-digest per station, which is slower)")
+var stationList = flag.String("stations", "", "only aggregate and print these comma separated stations")
+var stationRegex = flag.String("station-regex", "", "only aggregate and print stations whose names match this regular expression")
+var limit = flag.Int64("limit", 0, "stop after `N` rows (spread over the workers' chunks, so not quite the first N of the file)")
+var sample = flag.Float64("sample", 0, "only aggregate this `fraction` of the lines, e.g. 0.01. the sample is picked by line offset, so it's the same every run")
+var window = flag.Duration("window", 0, "read the timestamped format (station;temperature;unix_seconds) and aggregate per station per `duration` (whole seconds), printing entries as station@window_start")
+var columns = flag.String("columns", "", "read the multi-metric format (station;value;value;...), with these comma separated names for the value columns, and print entries as station:metric")
+var metrics = flag.String("metrics", "", "with -columns, the comma separated columns to aggregate (default all)")
+var foldCase = flag.Bool("fold-case", false, "merge stations whose names only differ in case (PARIS, Paris, paris) into one, named by the most common spelling")
+var groupBy = flag.String("group-by", "", "read lines with comma separated key columns before the temperature, e.g. country;station;temp with -group-by country,station, aggregate by all of them, and print the stations nested in their groups")
+var top = flag.Int("top", 0, "only print the `N` stations with the highest -rank-by value, highest first")
+var bottom = flag.Int("bottom", 0, "only print the `N` stations with the lowest -rank-by value, lowest first")
+var sortBy = flag.String("sort", "name", "order the results by name, mean, min, max or count (ties go by name)")
+var desc = flag.Bool("desc", false, "with -sort, print the highest first")
+var rankBy = flag.String("rank-by", "mean", "what -top/-bottom rank stations by: mean or max")
+var checkpointDir = flag.String("checkpoint", "", "save progress to `dir` every -checkpoint-every, and when interrupted, so the run can be continued with -resume (local files only)")
+var checkpointEvery = flag.Duration("checkpoint-every", 30*time.Second, "with -checkpoint, how often to save progress")
+var resume = flag.Bool("resume", false, "with -checkpoint, continue from the checkpoints in its directory instead of starting over")
+var shard = flag.String("shard", "", "only aggregate the `k/n`th byte range of the input (1 <= k <= n), writing the partial aggregates to -output for `1brc merge` instead of printing results")
+var relaxed = flag.Bool("relaxed", false, "accept temperatures in any plain decimal format (12, +3.25...), not just the official one with one fractional digit. slower")
+var onError = flag.String("on-error", "", "what to do with malformed lines: skip (drop them and report how many at the end) or fail (stop with the line number, byte offset and contents of the first one). by default lines aren't checked, which is fastest")
+var logRejects = flag.Bool("log-rejects", false, "with -on-error skip, log every dropped line with its byte offset")
+var perfectHash = flag.Bool("perfect-hash", false, "sample the input for station names first and build a perfect hash over them, so known stations are aggregated by direct indexing instead of hash table probes (-impl mmap only)")
+var hashName = flag.String("hash", "xxhash", "the hash stations are keyed by: xxhash, fnv, bytesum or maphash. anything but xxhash checks names on every lookup, since weaker hashes collide")
+var missingPlaceholder = flag.String("missing-placeholder", "NA", "value printed for stations with no data (see -include-missing)")
+
+// subcommands are invoked as `1brc <name> [flags]`. without one, it's run.
+var subcommands = map[string]func(ctx context.Context, log *slog.Logger, args []string) error{
+	"run":        runCommand,
+	"generate":   runGenerate,
+	"validate":   runValidate,
+	"selftest":   runSelftest,
+	"bench":      runBench,
+	"compare":    runCompare,
+	"serve":      runServe,
+	"grpc-serve": runGRPCServe,
+	"coordinate": runCoordinate,
+	"merge":      runMerge,
+}
+
+// readFlags are the global flags that affect where the input comes from and how it's read and aggregated.
+var readFlags = []string{"input", "impl", "io", "arch", "readers", "batch-size", "adapt-workers", "chunk-size", "workers", "engine", "engine-plugin", "madvise", "hugepages", "prefault", "max-memory", "pin", "smt", "follow", "follow-idle", "write-index", "use-index", "perfect-hash", "hash"}
+
+// lineFlags are the global flags that pick which lines count and how they're parsed.
+var lineFlags = []string{"stations", "station-regex", "limit", "sample", "fold-case", "relaxed", "on-error", "log-rejects"}
+
+// keyFlags are the global flags that shape the results' keys. partials only store windows and metrics as numbers, so
+// merging them takes the same ones they were aggregated with.
+var keyFlags = []string{"window", "columns", "metrics", "group-by", "group-prefix"}
+
+// aggregationFlags are all the flags that affect how the input is aggregated, for the subcommands that run the
+// aggregation like run does.
+var aggregationFlags = slices.Concat(readFlags, lineFlags, keyFlags)
+
+// subcommandFlags returns a flag set for a subcommand, sharing the named top level flags, along with -config, -errors
+// and the verbosity flags, with the top level command.
+func subcommandFlags(name string, shared ...string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	shareFlags(fs, shared...)
+	shareFlags(fs, "config", "errors", "v", "q")
+	return fs
+}
+
+// without returns flags without the named ones.
+func without(flags []string, names ...string) []string {
+	return slices.DeleteFunc(slices.Clone(flags), func(f string) bool { return slices.Contains(names, f) })
+}
+
+// shareFlags adds the named top level flags to fs.
+func shareFlags(fs *flag.FlagSet, names ...string) {
+	for _, n := range names {
+		f := flag.Lookup(n)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+}
 
 func main() {
-	flag.Parse()
+	name, args := "run", os.Args[1:]
+	if len(args) > 0 {
+		if _, ok := subcommands[args[0]]; ok {
+			name, args = args[0], args[1:]
+		}
+	}
+	flag.Usage = usage
+
+	ctx, log := setup()
+	if err := subcommands[name](ctx, log, args); err != nil {
+		os.Exit(reportError(log, name, err))
+	}
+}
+
+// usage is the top level -help, which is also run's.
+func usage() {
+	names := maps.Keys(subcommands)
+	slices.Sort(names)
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: 1brc [run] [flags]\n       1brc <subcommand> [flags]\n\nsubcommands (see 1brc <subcommand> -help): %s\n\nexit status: 0 on success, 2 for bad usage, 3 if an input doesn't exist, 4 for malformed input and 1 for anything else (see -errors)\n\nspans for the run's stages are exported over OTLP http/json if OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is set, configured with the other standard OTEL_* variables\n\nrun aggregates the input and prints the results. its flags:\n", strings.Join(names, ", "))
+	flag.PrintDefaults()
+}
+
+// runCommand is the `run` subcommand, the default: aggregate the input and print the results. its flags are the top
+// level ones, which the other subcommands that aggregate share, see aggregationFlags.
+func runCommand(ctx context.Context, log *slog.Logger, args []string) error {
+	if err := parseFlags(flag.CommandLine, args); err != nil {
+		return err
+	}
+	if err := applyGC(); err != nil {
+		return err
+	}
+	if *pgoCollect {
+		if err := startPGO(); err != nil {
+			return err
+		}
+	}
+	stopProfiling := startProfiling()
+	if *pprofAddr != "" {
+		stop, err := servePprof(log, *pprofAddr)
+		if err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	if err := applyPriority(); err != nil {
+		log.Warn("couldn't set process priority", "err", err)
+	}
+	spans, err := otlpSpansFromEnv()
+	if err != nil {
+		return err
+	}
+	err = run(ctx, log, spans)
+	spans.export(log, err)
+	stopProfiling() // even if we were interrupted, the profiles are worth having
+	if err == nil && *pgoCollect {
+		return finishPGO(log)
+	}
+	return err
+}
+
+// setup sets up logging (see newLogger) and returns a context that's cancelled by the first SIGINT/SIGTERM. after
+// that, signals go back to their default behavior, so a second ctrl-c kills the process right away.
+func setup() (context.Context, *slog.Logger) {
+	log := newLogger()
+	slog.SetDefault(log)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	context.AfterFunc(ctx, stop)
+	return ctx, log
+}
+
+// startProfiling starts the -cpuprofile and -trace profiles. the returned func stops them and writes -memprofile.
+func startProfiling() func() {
+	var stops []func()
 	if *cpuprofile != "" {
 		f, err := os.Create(*cpuprofile)
 		if err != nil {
 			panic(err)
 		}
-		defer f.Close()
 		if err := pprof.StartCPUProfile(f); err != nil {
 			panic(err)
 		}
-		defer pprof.StopCPUProfile()
+		stops = append(stops, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
 	}
 
 	if *traceprofile != "" {
@@ -41,284 +222,591 @@ func main() {
 		if err != nil {
 			panic(err)
 		}
-		defer f.Close()
 		if err := trace.Start(f); err != nil {
 			panic(err)
 		}
-		defer trace.Stop()
+		stops = append(stops, func() {
+			trace.Stop()
+			f.Close()
+		})
 	}
 
-	_, done, log, err := utils.StdSetup()
-	if err != nil {
-		panic(err)
-	}
-	done() // use default signal stuff
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
 
-	if err := run(log); err != nil {
-		log.Error("error", "err", err)
-		os.Exit(1)
+		if *memprofile != "" {
+			f, err := os.Create(*memprofile)
+			if err != nil {
+				panic(err)
+			}
+			defer f.Close()
+			runtime.GC() // get up-to-date statistics
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				panic(err)
+			}
+		}
 	}
+}
 
-	if *memprofile != "" {
-		f, err := os.Create(*memprofile)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
-		runtime.GC() // get up-to-date statistics
-		if err := pprof.WriteHeapProfile(f); err != nil {
-			panic(err)
+// applyPriority applies -nice/-ionice, or -realtime-ish which overrides both.
+func applyPriority() error {
+	var n *int
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "nice" {
+			n = nice
 		}
+	})
+	io := *ionice
+	if *realtimeIsh {
+		highest := -20
+		n, io = &highest, "realtime:0"
 	}
+	return setPriority(n, io)
 }
 
-const filename = "measurements.txt"
+// run runs the aggregation and writes the results, reporting the stages to spans if it isn't nil.
+func run(ctx context.Context, log *slog.Logger, spans *otlpSpans) error {
+	if err := checkOutputFlags(); err != nil {
+		return err
+	}
+	var missing []string
+	if *includeMissing != "" {
+		var err error
+		if missing, err = readStationList(*includeMissing); err != nil {
+			return fmt.Errorf("reading station list: %w", err)
+		}
+	}
 
-type stats struct {
-	station              string
-	min, max, sum, count float32
-}
+	var progress *brc.Progress
+	if *metricsAddr != "" {
+		progress = &brc.Progress{}
+		stop, err := serveMetrics(log, *metricsAddr, progress)
+		if err != nil {
+			return err
+		}
+		defer stop()
+		defer func() {
+			if *metricsLinger > 0 {
+				log.Info("run done, still serving metrics", "for", *metricsLinger)
+				select {
+				case <-time.After(*metricsLinger):
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
 
-// invocation: $ ./make.sh && GOGC=off hyperfine -w1 -m5 ./bin/1brc
-
-// (for 100m rows)
-// 12.338 s ± 0.026 s - start
-// 11.989 s ±  0.095 s - increase scanner buffer
-// 3.544 s ±  0.061 s - parallelize
-// - trace analysis: workers are starved. can we increase the read speed?
-// 3.665 s ±  0.133 s - mmap char by char (a bit slower)
-// 3.329 s ±  0.075 s - mmap with buffered reading
-// 2.490 s ±  0.087 s - same but with GOGC=off. now we're just cpu bound i think
-// 2.402 s ±  0.068 s - custom float parsing
-// 1.538 s ±  0.068 s - custom semicolon splitting
-// 1.239 s ±  0.048 s - prealllocating hash tables with 10k size
-// 1.116 s ±  0.056 s - interning station names
-// ** swapping to 1b rows **
-// 11.552 s ±  0.409 s - above
-// 11.542 s ±  0.083 s - switch to float32s
-// 11.276 s ±  0.385 s - optimized parsefloat more
-// 10.921 s ±  0.275 s - fixed unnecessary conversions in hash+interning
-// 10.334 s ±  0.364 s - pgo
-// 9.893 s ±  0.162 s  - manual mmap
-// 9.389 s ±  0.105 s  - manual line handling
-// 9.085 s ±  0.121 s  - cleanup + better run?
-// 7.818 s ±  0.250 s - switch from hash to just byte sum
-// 7.346 s ±  0.144 s - swiss map
-// 4.530 s ±  0.077 s - intmap plus remove interning indirection
-// 4.134 s ±  0.118 s - guess based split on semi
-// 4.418 s ±  0.129 s - use a real hash function to make it more legit. slower :(
-//
-// graveyard:
-// - iterating in reverse order in splitOnSemi
-// - using [swiss maps](https://github.com/dolthub/swiss) instead of builtin
-// - replacing *stats with stats in maps
-// - manual loop var stuff
-// - using bytes.IndexByte instead of a for loop to split on lines
-func run(log *slog.Logger) error {
-	numWorkers := runtime.NumCPU()
-
-	wg := &sync.WaitGroup{}
-
-	mmappedFile, close, err := setupMmap()
-	if err != nil {
-		return fmt.Errorf("setting up mmap %w", err)
+	if *percentiles != "" {
+		var err error
+		if quantiles, err = parsePercentiles(*percentiles); err != nil {
+			return err
+		}
 	}
-	defer close()
 
-	fileLen := len(mmappedFile)
+	opts := []brc.Option{brc.WithProgress(progress), brc.WithQuantiles(len(quantiles) > 0), brc.WithHistograms(*histogramPath != ""), brc.WithStddev(stddevWanted())}
+	if err := checkGroupByFlags(); err != nil {
+		return err
+	}
+	if err := checkEscapeNames(); err != nil {
+		return err
+	}
+	reducerOpts, err := reducerOptions()
+	if err != nil {
+		return err
+	}
+	opts = append(opts, reducerOpts...)
+	if *checkpointDir != "" {
+		opts = append(opts, brc.WithCheckpoint(*checkpointDir, *checkpointEvery), brc.WithResume(*resume))
+	} else if *resume {
+		return fmt.Errorf("-resume needs -checkpoint")
+	}
+	if spans != nil {
+		opts = append(opts, brc.WithSpans(spans))
+	}
+	if *followPrint > 0 {
+		opts = append(opts, brc.WithFollow(*followIdle), brc.WithFollowUpdates(*followPrint, func(res *brc.Results) {
+			if err := writeResults(res, missing); err != nil {
+				log.Warn("writing results failed", "err", err)
+			}
+		}))
+	}
+	if *shard != "" {
+		return runShard(ctx, log, *shard, opts)
+	}
+	res, err := aggregate(ctx, log, opts...)
+	if err != nil && (res == nil || !*partialOnInterrupt && *followPrint == 0) {
+		return err
+	}
+	if err := checkGroupKeys(res.Stations); err != nil {
+		return err
+	}
 
-	type job struct {
-		start, end int // inclusive start, exclusive end
+	endOutput := spans.Start("output", "format", *format)
+	werr := writeResults(res, missing)
+	endOutput(werr)
+	if werr != nil {
+		return fmt.Errorf("writing results: %w", werr)
+	}
+	if res.Rejected > 0 {
+		log.Warn("skipped malformed lines", "count", res.Rejected)
+	}
+	if *summary {
+		printSummary(os.Stderr, res)
+	}
+	if *throughput {
+		printThroughput(os.Stderr, res)
 	}
 
-	// divvy up the file. each worker gets a slice of the file but we need to make sure we don't split in the middle of a line
-	chunks := make([]job, numWorkers)
-	chunkSize := fileLen / numWorkers
-	nextStart := 0
-	for ci := range chunks {
-		start := nextStart
-		chunks[ci].start = start
-		// if this is the last chunk, just take the rest of the file
-		if ci == numWorkers-1 {
-			chunks[ci].end = fileLen
-			break
-		}
-		// find the last EOL before the end of the chunk
-		theoreticalEnd := start + chunkSize
-		for i := theoreticalEnd; i > start; i-- {
-			if mmappedFile[i] == '\n' {
-				chunks[ci].end = i
-				break
-			}
+	if *sqlitePath != "" {
+		if err := writeSQLite(*sqlitePath, *runID, res.Stations); err != nil {
+			return fmt.Errorf("writing to sqlite: %w", err)
 		}
-		nextStart = chunks[ci].end + 1
 	}
 
-	resultses := make([]*intmap.Map[uint64, *stats], numWorkers)
-
-	for i := range numWorkers {
-		res := intmap.New[uint64, *stats](10_000)
-		resultses[i] = res
-		chunk := chunks[i]
+	if *histogramPath != "" {
+		if err := writeHistograms(*histogramPath, res); err != nil {
+			return fmt.Errorf("writing histograms: %w", err)
+		}
+	}
 
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
+	if *workerStats {
+		printWorkerStats(os.Stderr, res.Workers)
+		printScaling(os.Stderr, res.Scaling)
+	}
+	if *tableStats {
+		printTableStats(os.Stderr, res.Workers)
+	}
 
-			// would be cool to lock to one cpu using unix.SchedSetaffinity() but it's not available on mac i think :(
+	return err
+}
 
-			w := NewWorker()
-			if err := w.run(mmappedFile[chunk.start:chunk.end], res); err != nil {
-				log.Error("worker error", "err", err)
-			}
-		}()
+// aggregate runs the aggregation over the input file with the options from the command line, plus extra. if ctx is
+// cancelled, it returns partial results along with the error.
+func aggregate(ctx context.Context, log *slog.Logger, extra ...brc.Option) (*brc.Results, error) {
+	if err := loadEnginePlugins(); err != nil {
+		return nil, err
+	}
+	opts, err := aggregationOptions(ctx, log)
+	if err != nil {
+		return nil, err
 	}
+	opts = append(opts, extra...)
+	if objstore.IsURL(*input) {
+		if compressedInput(*input) {
+			return nil, fmt.Errorf("a compressed -input has to be a local file")
+		}
+		return processRemote(ctx, *input, opts)
+	}
+	if streamInput(*input) {
+		return processStream(ctx, *input, opts)
+	}
+	if compressedInput(*input) {
+		return processCompressed(*input, opts)
+	}
+	return processLocal(*input, opts)
+}
 
-	wg.Wait()
+// stddevWanted reports whether the output has standard deviations in it. they cost a bit of speed, so they're only
+// tracked then.
+func stddevWanted() bool {
+	switch *format {
+	case "tsv", "parquet", "arrow":
+		return true
+	}
+	return *withStddev || *sqlitePath != ""
+}
 
-	res := mergeResults(resultses)
+// aggregationOptions turns the aggregationFlags into library options.
+func aggregationOptions(ctx context.Context, log *slog.Logger) ([]brc.Option, error) {
+	var re *regexp.Regexp
+	if *stationRegex != "" {
+		var err error
+		if re, err = regexp.Compile(*stationRegex); err != nil {
+			return nil, fmt.Errorf("bad -station-regex: %w", err)
+		}
+	}
+	if *smt != "on" && *smt != "off" {
+		return nil, fmt.Errorf("unknown -smt %q (want on or off)", *smt)
+	}
+	opts := []brc.Option{
+		brc.WithContext(ctx),
+		brc.WithLogger(log),
+		brc.WithEngine(*engineName),
+		brc.WithMadvise(*madvise),
+		brc.WithHugePages(*hugepages),
+		brc.WithPrefault(*prefault),
+		brc.WithPinning(*pin),
+		brc.WithSMT(*smt == "on"),
+		brc.WithWriteIndex(*writeIndex),
+		brc.WithUseIndex(*useIndex),
+		brc.WithRelaxed(*relaxed),
+		brc.WithOnError(*onError),
+		brc.WithLogRejects(*logRejects),
+		brc.WithPerfectHash(*perfectHash),
+		brc.WithHash(*hashName),
+	}
+	opts = append(opts, memoryOptions()...)
+	archOpts, err := archOptions()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(opts, archOpts...)
+	if *workers > 0 {
+		opts = append(opts, brc.WithWorkers(*workers))
+	}
+	if *follow {
+		opts = append(opts, brc.WithFollow(*followIdle))
+	}
+	if *limit > 0 {
+		opts = append(opts, brc.WithLimit(*limit))
+	}
+	if *sample > 0 {
+		opts = append(opts, brc.WithSample(*sample))
+	}
+	if *window != 0 {
+		opts = append(opts, brc.WithWindow(*window))
+	}
+	if *groupBy != "" {
+		opts = append(opts, brc.WithGroupBy(groupByColumns()...))
+	}
+	if *foldCase {
+		opts = append(opts, brc.WithFoldCase(true))
+	}
+	if *groupPrefix != "" {
+		key, err := groupPrefixKey(*groupPrefix)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, brc.WithRollUp(key))
+	}
+	if *columns != "" {
+		opts = append(opts, brc.WithColumns(strings.Split(*columns, ",")...))
+	}
+	if *metrics != "" {
+		opts = append(opts, brc.WithMetrics(strings.Split(*metrics, ",")...))
+	}
+	if *stationList != "" {
+		opts = append(opts, brc.WithStations(strings.Split(*stationList, ",")...))
+	}
+	if re != nil {
+		opts = append(opts, brc.WithStationRegexp(re))
+	}
+	return opts, nil
+}
 
-	printRes(res)
+func printRes(w io.Writer, stations []brc.Station, missing []string) {
+	_, _ = w.Write(appendRes(make([]byte, 0, outputSize(len(stations)+len(missing))), stations, missing))
+}
 
-	return nil
+// outputSize estimates how long the output for n stations is, so it can be built without growing the buffer.
+func outputSize(n int) int {
+	perStation := 32 + 6*len(quantiles)
+	if *withStddev {
+		perStation += 6
+	}
+	if reducers != nil {
+		perStation = 16 + 8*len(reducers)
+	}
+	return 3 + n*perStation
 }
 
-func setupMmap() ([]byte, func(), error) {
-	// custom mmap since exp/mmap's ReaderAt does copies
-	f, err := os.Open(filename)
-	if err != nil {
-		return nil, func() {}, fmt.Errorf("opening file: %w", err)
+// appendRes appends the results in the 1brc format to b. the output is built in memory and written in one go, since
+// formatting each station with fmt showed up in profiles.
+func appendRes(b []byte, stations []brc.Station, missing []string) []byte {
+	// {Abha=-23.0/18.0/59.2, Abidjan=-16.2/26.0/67.3, Abéché=-10.0/29.4/69.0, Accra=-10.1/26.4/66.4, Addis Ababa=-23.7/16.0/67.0, Adelaide=-27.8/17.3/58.5, ...}
+	if *groupBy != "" {
+		return appendGrouped(b, stations)
 	}
-	defer f.Close()
-	fi, err := f.Stat()
-	if err != nil {
-		return nil, func() {}, fmt.Errorf("statting file: %w", err)
+	if *window > 0 || *columns != "" {
+		// a time series or several metrics, there can be several entries per station, which are already in order
+		return appendStations(b, stations)
 	}
 
-	size := fi.Size()
+	byName := make(map[string]*brc.Station, len(stations))
+	for i := range stations {
+		byName[stations[i].Name] = &stations[i]
+	}
+	names := maps.Keys(byName)
+	for _, name := range missing {
+		if _, ok := byName[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.SortFunc(names, compareNames)
+	names = slices.Compact(names) // the list may repeat names
 
-	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
-	if err != nil {
-		return nil, func() {}, fmt.Errorf("mmap: %w", err)
+	b = append(b, '{')
+	for _, name := range names {
+		stats, ok := byName[name]
+		if !ok {
+			b = appendMissing(b, name)
+			continue
+		}
+		b = appendStation(b, stats)
 	}
+	return append(b, "}\n"...)
+}
 
-	return data, func() { _ = syscall.Munmap(data) }, nil
+// appendMissing appends the entry of a station with no data, see -include-missing.
+func appendMissing(b []byte, name string) []byte {
+	fields := 3 + len(quantiles)
+	if *withStddev {
+		fields++
+	}
+	if reducers != nil {
+		fields = len(reducers)
+	}
+	b = appendName(b, name)
+	b = append(b, '=')
+	for i := range fields {
+		if i > 0 {
+			b = append(b, '/')
+		}
+		b = append(b, *missingPlaceholder...)
+	}
+	return append(b, ',')
 }
 
-type worker struct{}
+// appendStation appends one station's entry: min/mean/max, then the standard deviation and percentiles if asked for,
+// or the -aggregate values instead.
+func appendStation(b []byte, s *brc.Station) []byte {
+	b = appendName(b, s.Name)
+	if !s.Window.IsZero() {
+		b = append(b, '@')
+		b = s.Window.AppendFormat(b, time.RFC3339)
+	}
+	if s.Metric != "" {
+		b = append(b, ':')
+		b = append(b, s.Metric...)
+	}
+	b = append(b, '=')
+	if reducers != nil {
+		return append(appendReduced(b, s), ',')
+	}
+	b = appendTenths(b, s.Min)
+	b = append(b, '/')
+	b = appendTenths(b, s.Mean)
+	b = append(b, '/')
+	b = appendTenths(b, s.Max)
+	if *withStddev {
+		b = append(b, '/')
+		b = appendTenths(b, s.Stddev)
+	}
+	for _, q := range quantiles {
+		b = append(b, '/')
+		if v, ok := s.Quantile(q); ok {
+			b = appendTenths(b, v)
+		} else {
+			b = append(b, *missingPlaceholder...)
+		}
+	}
+	return append(b, ',')
+}
 
-func NewWorker() *worker {
-	return &worker{}
+// appendTenths formats v with one decimal, like %.1f.
+func appendTenths(b []byte, v float64) []byte {
+	return strconv.AppendFloat(b, v, 'f', 1, 64)
 }
 
-func (w *worker) run(chunk []byte, res *intmap.Map[uint64, *stats]) error {
-	// our chunk is guaranteed to be made of full lines only
-	lineStart := 0
-	for i := 0; i < len(chunk); i++ {
-		if chunk[i] == '\n' {
-			// handle line
-			stationBs, stationHash, temp, err := w.parseLineBytes(chunk[lineStart:i])
-			if err != nil {
-				return fmt.Errorf("parsing line %w", err)
-			}
-			s, ok := res.Get(stationHash)
-			if !ok {
-				s = &stats{min: temp, max: temp, station: string(stationBs)}
-				res.Put(stationHash, s)
-			}
-			s.min = min(s.min, temp)
-			s.max = max(s.max, temp)
-			s.sum += temp
-			s.count++
+// quantiles are the -percentiles as fractions, see parsePercentiles.
+var quantiles []float64
 
-			lineStart = i + 1
+// parsePercentiles parses a list like p50,p99.9 (the p is optional) into fractions.
+func parsePercentiles(list string) ([]float64, error) {
+	var qs []float64
+	for _, p := range strings.Split(list, ",") {
+		v, err := strconv.ParseFloat(strings.TrimPrefix(strings.TrimSpace(p), "p"), 64)
+		if err != nil || v < 0 || v > 100 {
+			return nil, fmt.Errorf("bad percentile %q", p)
 		}
+		qs = append(qs, v/100)
 	}
-	return nil
+	return qs, nil
 }
 
-func (w *worker) parseLineBytes(line []byte) ([]byte, uint64, float32, error) {
-	stationBs, tempStr := w.splitOnSemi(line)
+// rankStations returns the top and/or bottom n stations by mean or max. with both, the top ones come first.
+func rankStations(stations []brc.Station, top, bottom int, by string) ([]brc.Station, error) {
+	var key func(s *brc.Station) float64
+	switch by {
+	case "mean":
+		key = func(s *brc.Station) float64 { return s.Mean }
+	case "max":
+		key = func(s *brc.Station) float64 { return s.Max }
+	default:
+		return nil, fmt.Errorf("unknown -rank-by %q, want mean or max", by)
+	}
 
-	stationHash := stationHash(stationBs)
-	temp := parseFloat(tempStr)
-	return stationBs, stationHash, temp, nil
+	// stations are sorted by name already and the sorts are stable, so ties go by name
+	var out []brc.Station
+	if top > 0 {
+		ranked := slices.Clone(stations)
+		slices.SortStableFunc(ranked, func(a, b brc.Station) int { return cmp.Compare(key(&b), key(&a)) })
+		out = append(out, ranked[:min(top, len(ranked))]...)
+	}
+	if bottom > 0 {
+		ranked := slices.Clone(stations)
+		slices.SortStableFunc(ranked, func(a, b brc.Station) int { return cmp.Compare(key(&a), key(&b)) })
+		out = append(out, ranked[:min(bottom, len(ranked))]...)
+	}
+	return out, nil
 }
 
-func (w *worker) splitOnSemi(bs []byte) ([]byte, []byte) {
-	// the format is like ABC;-1.0. the semicolon can only be in a few places from the end: -5 (2 digit pos temp or 1 dig neg), -6 (neg), -4 (1 digit pos temp)
-	// the most common variant is 4 digits, then 3, then 5. so check in that order
-	if i := len(bs) - 5; bs[i] == ';' {
-		return bs[:i], bs[i+1:]
-	} else if i := len(bs) - 4; bs[i] == ';' {
-		return bs[:i], bs[i+1:]
-	} else if i := len(bs) - 6; bs[i] == ';' {
-		return bs[:i], bs[i+1:]
-	}
-	panic("no semicolon found")
+// sortStations sorts stations by name, mean, min, max or count, lowest first unless desc.
+func sortStations(stations []brc.Station, by string, desc bool) ([]brc.Station, error) {
+	var compare func(a, b *brc.Station) int
+	switch by {
+	case "name":
+		compare = func(a, b *brc.Station) int { return compareNames(a.Name, b.Name) }
+	case "mean":
+		compare = func(a, b *brc.Station) int { return cmp.Compare(a.Mean, b.Mean) }
+	case "min":
+		compare = func(a, b *brc.Station) int { return cmp.Compare(a.Min, b.Min) }
+	case "max":
+		compare = func(a, b *brc.Station) int { return cmp.Compare(a.Max, b.Max) }
+	case "count":
+		compare = func(a, b *brc.Station) int { return cmp.Compare(a.Count, b.Count) }
+	default:
+		return nil, fmt.Errorf("unknown -sort %q, want name, mean, min, max or count", by)
+	}
+
+	// stations come sorted by name (see -collate) and the sort is stable, so ties go by name either way
+	sorted := slices.Clone(stations)
+	slices.SortStableFunc(sorted, func(a, b brc.Station) int {
+		if desc {
+			return compare(&b, &a)
+		}
+		return compare(&a, &b)
+	})
+	return sorted, nil
 }
 
-func stationHash(name []byte) uint64 {
-	return xxhash.Sum64(name)
+// printStations prints stations in the same format as printRes, but in the order given, followed by the stations in
+// missing that aren't among them.
+func printStations(w io.Writer, stations []brc.Station, missing []string) {
+	b := appendStations(make([]byte, 0, outputSize(len(stations)+len(missing))), stations)
+	if len(missing) > 0 {
+		have := make(map[string]bool, len(stations))
+		for _, s := range stations {
+			have[s.Name] = true
+		}
+		missing = slices.Clone(missing)
+		slices.SortFunc(missing, compareNames)
+		missing = slices.Compact(missing)
+		b = b[:len(b)-2] // "}\n"
+		for _, name := range missing {
+			if !have[name] {
+				b = appendMissing(b, name)
+			}
+		}
+		b = append(b, "}\n"...)
+	}
+	_, _ = w.Write(b)
 }
 
-func parseFloat(bs []byte) float32 {
-	// Temperature value: non null double between -99.9 (inclusive) and 99.9 (inclusive), always with one fractional digit
-	sign := float32(1.)
-	if bs[0] == '-' {
-		sign = -1.
-		bs = bs[1:]
+func appendStations(b []byte, stations []brc.Station) []byte {
+	b = append(b, '{')
+	for i := range stations {
+		b = appendStation(b, &stations[i])
 	}
+	return append(b, "}\n"...)
+}
 
-	intPart := bs[:len(bs)-2]
-	fracPart := bs[len(bs)-1] - '0'
+// printSummary prints the totals of a run. rows are what made it into the results, so with a clean input and no
+// -limit or -sample they should match the line count of the file.
+func printSummary(w io.Writer, res *brc.Results) {
+	names := make(map[string]struct{}, len(res.Stations))
+	for _, s := range res.Stations {
+		names[s.Name] = struct{}{}
+	}
+	var bytes int64
+	for _, ws := range res.Workers {
+		bytes += ws.Bytes
+	}
+	fmt.Fprintf(w, "%d stations, %d rows, %d bytes read", len(names), res.Rows(), bytes)
+	if res.Rejected > 0 {
+		fmt.Fprintf(w, ", %d malformed lines skipped", res.Rejected)
+	}
+	fmt.Fprintln(w)
+}
 
-	var ip int
-	if len(intPart) == 2 {
-		ip = int((intPart[0]-'0')*10 + (intPart[1] - '0'))
-	} else {
-		ip = int(intPart[0] - '0')
+// printThroughput prints how fast the aggregation went, in the units of the benchmark log in pkg/brc/brc.go.
+func printThroughput(w io.Writer, res *brc.Results) {
+	if res.Elapsed == 0 {
+		fmt.Fprintln(w, "no timing for this input")
+		return
+	}
+	var bytes int64
+	for _, ws := range res.Workers {
+		bytes += ws.Bytes
 	}
+	secs := res.Elapsed.Seconds()
+	fmt.Fprintf(w, "%.3f s: %.1fM rows/s, %.2f GB/s\n", secs, float64(res.Rows())/secs/1e6, float64(bytes)/secs/1e9)
+}
 
-	return sign * (float32(ip) + float32(fracPart)/10)
+// printTableStats prints a table of how each worker's hash table did: long probes mean the keys cluster, and collisions
+// mean the hash isn't telling stations apart (see -hash).
+func printTableStats(w io.Writer, workers []brc.WorkerStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "worker\tstations\tslots\tload\tmean probe\tmax probe\tcollisions\t\n")
+	for _, ws := range workers {
+		fmt.Fprintf(tw, "%d\t%d\t%d\t%.2f\t%.3f\t%d\t%d\t\n",
+			ws.Worker, ws.Stations, ws.Slots, ws.LoadFactor, ws.MeanProbe, ws.MaxProbe, ws.Collisions)
+	}
+	tw.Flush()
 }
-func printRes(res *intmap.Map[uint64, *stats]) {
-	// {Abha=-23.0/18.0/59.2, Abidjan=-16.2/26.0/67.3, Abéché=-10.0/29.4/69.0, Accra=-10.1/26.4/66.4, Addis Ababa=-23.7/16.0/67.0, Adelaide=-27.8/17.3/58.5, ...}
-	namesTohashes := getStationsToHashes(res)
-	names := maps.Keys(namesTohashes)
-	slices.Sort(names)
 
-	fmt.Printf("{")
-	for _, name := range names {
-		stats, _ := res.Get(namesTohashes[name])
-		fmt.Printf("%s=%.1f/%.1f/%.1f,", name, stats.min, stats.sum/stats.count, stats.max)
+// printWorkerStats prints a table of where each worker's time went. large idle times mean either uneven chunks (for
+// some workers) or starvation (for all of them).
+func printWorkerStats(w io.Writer, workers []brc.WorkerStats) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "worker\tstart\tbusy\tidle\tMB\tMB/s\tlines\tstations\t\n")
+	for _, ws := range workers {
+		mb := float64(ws.Bytes) / (1 << 20)
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%.1f\t%.1f\t%d\t%d\t\n",
+			ws.Worker, ws.Start.Round(time.Microsecond), ws.Busy.Round(time.Microsecond), ws.Idle.Round(time.Microsecond),
+			mb, mb/ws.Busy.Seconds(), ws.Lines, ws.Stations)
 	}
-	fmt.Printf("}\n")
+	tw.Flush()
 }
 
-func mergeResults(resultses []*intmap.Map[uint64, *stats]) *intmap.Map[uint64, *stats] {
-	res := intmap.New[uint64, *stats](resultses[0].Len())
-	for _, r := range resultses {
-		r.ForEach(func(k uint64, v *stats) {
-			s, ok := res.Get(k)
-			if !ok {
-				s = v
-				res.Put(k, s)
-			} else {
-				s.min = min(s.min, v.min)
-				s.max = max(s.max, v.max)
-				s.sum += v.sum
-				s.count += v.count
-			}
-		})
+// printScaling prints the decisions -adapt-workers made, if it was on.
+func printScaling(w io.Writer, steps []brc.ScalingStep) {
+	if len(steps) == 0 {
+		return
+	}
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "at\tworkers\tstarved\tblocked\tMB/s\treason\n")
+	for _, s := range steps {
+		fmt.Fprintf(tw, "%s\t%d\t%.0f%%\t%.0f%%\t%.1f\t%s\n",
+			s.At.Round(time.Millisecond), s.Workers, 100*s.Starved, 100*s.Blocked, s.MBps, s.Reason)
 	}
-	return res
+	tw.Flush()
 }
 
-func getStationsToHashes(m *intmap.Map[uint64, *stats]) map[string]uint64 {
-	names := make(map[string]uint64, m.Len())
-	m.ForEach(func(k uint64, s *stats) {
-		names[s.station] = k
-	})
-	return names
+// readStationList reads one station name per line. it also accepts the official weather_stations.csv format
+// (name;mean, with # comments) so the upstream list can be used as-is.
+func readStationList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, _, _ := strings.Cut(line, ";")
+		names = append(names, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("scanning %s: %w", path, err)
+	}
+	return names, nil
 }
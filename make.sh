@@ -5,8 +5,8 @@ echo 'Building...'
 go build -o bin/1brc .
 
 echo 'Running...'
-GOGC=off ./bin/1brc >/dev/null # warm cache
-GOGC=off ./bin/1brc -cpuprofile default.pgo >/dev/null
+./bin/1brc >/dev/null # warm cache
+./bin/1brc -pgo-collect >/dev/null
 
 echo 'Building with pgo...'
 go build -o bin/1brc . # build again with pgo
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var maxMemory = byteSizeFlag("max-memory", "cap resident memory at about this `size`, e.g. 2G, by going through the mapped input a window at a time and releasing each one when done, and by having the gc hold the heap under it even with -gc off (0 means no cap)")
+
+// byteSize is a flag value of bytes, with an optional K, M or G suffix (powers of 1024).
+type byteSize int64
+
+func byteSizeFlag(name, usage string) *byteSize {
+	b := new(byteSize)
+	flag.Var(b, name, usage)
+	return b
+}
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	s, shift := value, 0
+	switch strings.ToUpper(s[len(s)-min(len(s), 1):]) {
+	case "K":
+		shift = 10
+	case "M":
+		shift = 20
+	case "G":
+		shift = 30
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 || n > 1<<(63-shift)-1 {
+		return fmt.Errorf("bad size %q", value)
+	}
+	*b = byteSize(n << shift)
+	return nil
+}
+
+// memoryOptions applies -max-memory: the library caps the mapping, and the rest is up to the gc.
+func memoryOptions() []brc.Option {
+	if *maxMemory == 0 {
+		return nil
+	}
+	debug.SetMemoryLimit(int64(*maxMemory))
+	return []brc.Option{brc.WithMaxMemory(int64(*maxMemory))}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// runMerge is the `merge` subcommand: it combines the partial aggregates written by -shard runs and prints the
+// results, like a single run over the whole input would. the shards have to have been run with the same -window,
+// -columns and -metrics as the merge.
+//
+//	1brc -shard 1/2 -output a.bin & 1brc -shard 2/2 -output b.bin; 1brc merge a.bin b.bin
+func runMerge(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("merge", slices.Concat(keyFlags, []string{"format", "output", "stddev", "percentiles", "top", "bottom", "rank-by", "sort", "desc", "missing-placeholder"})...)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("no partials files to merge")
+	}
+	if *percentiles != "" {
+		var err error
+		if quantiles, err = parsePercentiles(*percentiles); err != nil {
+			return err
+		}
+	}
+
+	var partials []*brc.Partial
+	for _, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		ps, err := brc.ReadPartials(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", path, err)
+		}
+		partials = append(partials, ps...)
+	}
+
+	opts, err := aggregationOptions(ctx, log)
+	if err != nil {
+		return err
+	}
+	res := brc.Merge(partials, opts...)
+	log.Debug("merged", "files", fs.NArg(), "partials", len(partials), "stations", len(res.Stations))
+	return writeResults(res, nil)
+}
+
+// runShard aggregates one byte range of the input for -shard and writes the partial aggregates to -output.
+func runShard(ctx context.Context, log *slog.Logger, spec string, extra []brc.Option) (err error) {
+	var k, n int64
+	if _, err := fmt.Sscanf(spec, "%d/%d", &k, &n); err != nil || n < 1 || k < 1 || k > n {
+		return fmt.Errorf("bad -shard %q, want k/n with 1 <= k <= n", spec)
+	}
+	opts, err := aggregationOptions(ctx, log)
+	if err != nil {
+		return err
+	}
+	r, size, closeInput, err := openInput(ctx, *input)
+	if err != nil {
+		return err
+	}
+	defer closeInput()
+	// whether the merge prints standard deviations isn't up to us
+	opts = slices.Concat(opts, extra, []brc.Option{brc.WithStddev(true)})
+	partials, err := brc.ProcessRange(r, size, size*(k-1)/n, size*k/n, opts...)
+	if err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *outputPath != "" {
+		if out, err = os.Create(*outputPath); err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+		}()
+	}
+	return brc.WritePartials(out, partials)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var metricsAddr = flag.String("metrics-addr", "", "serve prometheus metrics for the run at http://`addr`/metrics")
+var metricsLinger = flag.Duration("metrics-linger", 0, "with -metrics-addr, keep serving this long after the run so the final values get scraped")
+
+// serveMetrics serves p's counters in the prometheus text format until the returned func is called. we write the
+// format by hand, it's a handful of gauges and not worth a client library.
+func serveMetrics(log *slog.Logger, addr string, p *brc.Progress) (func(), error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		writeMetrics(w, p)
+	})
+	srv := &http.Server{Handler: mux}
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Warn("metrics server failed", "err", err)
+		}
+	}()
+	log.Info("serving metrics", "addr", ln.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}, nil
+}
+
+func writeMetrics(w http.ResponseWriter, p *brc.Progress) {
+	metric := func(name, typ, help string, v float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, typ, name, v)
+	}
+
+	elapsed := p.Elapsed().Seconds()
+	done := float64(p.BytesDone.Load())
+	total := float64(p.BytesTotal.Load())
+	workers := float64(p.Workers.Load())
+	busy := float64(p.BusyWorkers.Load())
+
+	metric("brc_bytes_processed_total", "counter", "Bytes of input aggregated so far.", done)
+	metric("brc_bytes_total", "gauge", "Size of the input in bytes, 0 if unknown.", total)
+	if total > 0 {
+		metric("brc_bytes_remaining", "gauge", "Bytes of input left to aggregate.", max(total-done, 0))
+	}
+	throughput := 0.0
+	if elapsed > 0 {
+		throughput = done / elapsed
+	}
+	metric("brc_throughput_bytes_per_second", "gauge", "Average aggregation throughput since the run started.", throughput)
+	metric("brc_workers", "gauge", "Number of workers.", workers)
+	metric("brc_workers_busy", "gauge", "Number of workers currently aggregating.", busy)
+	utilization := 0.0
+	if workers > 0 {
+		utilization = busy / workers
+	}
+	metric("brc_worker_utilization_ratio", "gauge", "Fraction of workers currently aggregating.", utilization)
+	metric("brc_run_duration_seconds", "gauge", "Time since the run started, or how long it took once it's done.", elapsed)
+	finished := 0.0
+	if p.FinishedNanos.Load() != 0 {
+		finished = 1
+	}
+	metric("brc_finished", "gauge", "1 once the run is done and the row/station counts are final.", finished)
+	metric("brc_rows", "gauge", "Rows aggregated, set when the run finishes.", float64(p.Rows.Load()))
+	metric("brc_stations", "gauge", "Distinct stations seen, set when the run finishes.", float64(p.Stations.Load()))
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otlpSpans collects the spans of a run (see brc.Spans) under a root span for the whole of it, and exports them to an
+// OTLP collector in one request at the end. like the prometheus metrics, it's written by hand: OTLP over http/json is
+// a single POST, and the otel sdk would be most of the binary. it's configured with the standard OTEL_* environment
+// variables and only turned on by setting OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT. a
+// TRACEPARENT in the environment makes the run part of the caller's trace.
+type otlpSpans struct {
+	endpoint string
+	headers  map[string]string
+	timeout  time.Duration
+	resource []otlpAttr
+
+	traceID string
+	root    otlpSpan
+
+	mu    sync.Mutex
+	spans []otlpSpan
+}
+
+type otlpSpan struct {
+	TraceID      string     `json:"traceId"`
+	SpanID       string     `json:"spanId"`
+	ParentSpanID string     `json:"parentSpanId,omitempty"`
+	Name         string     `json:"name"`
+	Kind         int        `json:"kind"`
+	Start        string     `json:"startTimeUnixNano"`
+	End          string     `json:"endTimeUnixNano"`
+	Attributes   []otlpAttr `json:"attributes,omitempty"`
+	Status       otlpStatus `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"` // 2 is an error
+	Message string `json:"message,omitempty"`
+}
+
+type otlpAttr struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+// otlpSpansFromEnv returns the exporter the environment asks for, or nil if it doesn't ask for one.
+func otlpSpansFromEnv() (*otlpSpans, error) {
+	if os.Getenv("OTEL_SDK_DISABLED") == "true" {
+		return nil, nil
+	}
+	switch exp := os.Getenv("OTEL_TRACES_EXPORTER"); exp {
+	case "", "otlp":
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("OTEL_TRACES_EXPORTER=%s isn't supported, only otlp", exp)
+	}
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+		if base == "" {
+			return nil, nil
+		}
+		endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if proto := otelEnv("PROTOCOL"); proto != "" && proto != "http/json" {
+		return nil, fmt.Errorf("OTLP protocol %s isn't supported, only http/json", proto)
+	}
+
+	s := &otlpSpans{endpoint: endpoint, headers: map[string]string{}, timeout: 10 * time.Second}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_HEADERS", "OTEL_EXPORTER_OTLP_TRACES_HEADERS"} {
+		if err := parseOTELPairs(os.Getenv(name), func(k, v string) { s.headers[k] = v }); err != nil {
+			return nil, fmt.Errorf("bad %s: %w", name, err)
+		}
+	}
+	if v := otelEnv("TIMEOUT"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms < 0 {
+			return nil, fmt.Errorf("bad OTLP timeout %q, want milliseconds", v)
+		}
+		s.timeout = time.Duration(ms) * time.Millisecond
+	}
+
+	service := "1brc"
+	if err := parseOTELPairs(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"), func(k, v string) {
+		if k == "service.name" {
+			service = v
+		} else {
+			s.resource = append(s.resource, newOTLPAttr(k, v))
+		}
+	}); err != nil {
+		return nil, fmt.Errorf("bad OTEL_RESOURCE_ATTRIBUTES: %w", err)
+	}
+	if v := os.Getenv("OTEL_SERVICE_NAME"); v != "" {
+		service = v
+	}
+	s.resource = append(s.resource, newOTLPAttr("service.name", service))
+
+	s.traceID = randomHex(16)
+	if tp := os.Getenv("TRACEPARENT"); tp != "" {
+		// version-traceid-parentid-flags
+		parts := strings.Split(tp, "-")
+		if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+			return nil, fmt.Errorf("bad TRACEPARENT %q", tp)
+		}
+		s.traceID, s.root.ParentSpanID = parts[1], parts[2]
+	}
+	s.root.TraceID, s.root.SpanID, s.root.Name = s.traceID, randomHex(8), "1brc"
+	s.root.Kind = 1 // internal
+	s.root.Start = otlpTime(time.Now())
+	s.root.Attributes = []otlpAttr{newOTLPAttr("input", *input)}
+	return s, nil
+}
+
+// otelEnv returns the traces specific OTEL_EXPORTER_OTLP_TRACES_<name>, or the general OTEL_EXPORTER_OTLP_<name>.
+func otelEnv(name string) string {
+	if v := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); v != "" {
+		return v
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// parseOTELPairs parses the k1=v1,k2=v2 lists the OTEL_* variables use, with percent encoded values.
+func parseOTELPairs(s string, f func(k, v string)) error {
+	for _, pair := range strings.Split(s, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("%q isn't key=value", pair)
+		}
+		v, err := url.PathUnescape(strings.TrimSpace(v))
+		if err != nil {
+			return fmt.Errorf("%q: %w", pair, err)
+		}
+		f(strings.TrimSpace(k), v)
+	}
+	return nil
+}
+
+// Start implements brc.Spans, adding the spans it starts as children of the root one. it's a no-op on a nil
+// otlpSpans, so the output span can be started without checking whether exporting is on.
+func (s *otlpSpans) Start(name string, attrs ...any) func(err error) {
+	if s == nil {
+		return func(error) {}
+	}
+	span := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       randomHex(8),
+		ParentSpanID: s.root.SpanID,
+		Name:         name,
+		Kind:         1,
+		Start:        otlpTime(time.Now()),
+	}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		span.Attributes = append(span.Attributes, newOTLPAttr(fmt.Sprint(attrs[i]), attrs[i+1]))
+	}
+	return func(err error) {
+		span.End = otlpTime(time.Now())
+		span.Status = spanStatus(err)
+		s.mu.Lock()
+		s.spans = append(s.spans, span)
+		s.mu.Unlock()
+	}
+}
+
+// export ends the root span with the run's error and sends all the spans to the collector. failing to is only worth
+// a warning, since the run itself went fine (or not) regardless.
+func (s *otlpSpans) export(log *slog.Logger, runErr error) {
+	if s == nil {
+		return
+	}
+	s.root.End = otlpTime(time.Now())
+	s.root.Status = spanStatus(runErr)
+	s.mu.Lock()
+	spans := append([]otlpSpan{s.root}, s.spans...)
+	s.mu.Unlock()
+
+	body, err := json.Marshal(map[string]any{
+		"resourceSpans": []any{map[string]any{
+			"resource": map[string]any{"attributes": s.resource},
+			"scopeSpans": []any{map[string]any{
+				"scope": map[string]any{"name": "go.coldcutz.net/1brc"},
+				"spans": spans,
+			}},
+		}},
+	})
+	if err != nil {
+		log.Warn("encoding spans failed", "err", err)
+		return
+	}
+	// the run's context may be what got cancelled, so this gets its own
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Warn("exporting spans failed", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Warn("exporting spans failed", "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		log.Warn("exporting spans failed", "endpoint", s.endpoint, "status", resp.Status, "body", string(msg))
+		return
+	}
+	log.Debug("exported spans", "endpoint", s.endpoint, "spans", len(spans), "trace", s.traceID)
+}
+
+func spanStatus(err error) otlpStatus {
+	if err == nil {
+		return otlpStatus{}
+	}
+	return otlpStatus{Code: 2, Message: err.Error()}
+}
+
+// newOTLPAttr encodes an attribute the way OTLP's json mapping wants it, with 64 bit ints as strings.
+func newOTLPAttr(key string, v any) otlpAttr {
+	var value map[string]any
+	switch v := v.(type) {
+	case string:
+		value = map[string]any{"stringValue": v}
+	case bool:
+		value = map[string]any{"boolValue": v}
+	case int:
+		value = map[string]any{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]any{"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]any{"doubleValue": v}
+	default:
+		value = map[string]any{"stringValue": fmt.Sprint(v)}
+	}
+	return otlpAttr{Key: key, Value: value}
+}
+
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
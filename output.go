@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var format = flag.String("format", "text", "output format: text (the 1brc format), tsv (with a header row), jsonl (a json object per station), table (aligned, for reading in a terminal, see -table-box), parquet or arrow (an ipc stream)")
+var outputPath = flag.String("output", "", "write the results to `file` instead of stdout")
+
+// checkOutputFlags checks -format, and that -include-missing comes with the text format, the only one that prints
+// stations without data.
+func checkOutputFlags() error {
+	switch *format {
+	case "text", "tsv", "jsonl", "table", "parquet", "arrow":
+	default:
+		return fmt.Errorf("unknown -format %q", *format)
+	}
+	if *includeMissing != "" && *format != "text" {
+		return fmt.Errorf("-include-missing only works with -format text")
+	}
+	return nil
+}
+
+// writeResults writes res to -output in -format.
+func writeResults(res *brc.Results, missing []string) (err error) {
+	// before -output is created, so a bad -format doesn't leave an empty file behind
+	if err := checkOutputFlags(); err != nil {
+		return err
+	}
+	var w io.Writer = os.Stdout
+	if *outputPath != "" {
+		f, err := os.Create(*outputPath)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}()
+		w = f
+	}
+	bw := bufio.NewWriter(w)
+	defer func() {
+		if ferr := bw.Flush(); err == nil {
+			err = ferr
+		}
+	}()
+
+	stations, err := applyCollation(res.Stations)
+	if err != nil {
+		return err
+	}
+	ranked := *top > 0 || *bottom > 0
+	if ranked {
+		if stations, err = rankStations(stations, *top, *bottom, *rankBy); err != nil {
+			return err
+		}
+	}
+	sorted := *sortBy != "name" || *desc
+	if sorted {
+		if stations, err = sortStations(stations, *sortBy, *desc); err != nil {
+			return err
+		}
+	}
+
+	switch *format {
+	case "text":
+		if ranked {
+			printStations(bw, stations, nil)
+		} else if sorted {
+			printStations(bw, stations, missing)
+		} else {
+			printRes(bw, stations, missing)
+		}
+		return nil
+	case "tsv":
+		return writeTSV(bw, stations)
+	case "jsonl":
+		return writeJSONLines(bw, stations)
+	case "table":
+		return writeTable(bw, stations)
+	case "parquet":
+		return writeParquet(bw, stations)
+	case "arrow":
+		return writeArrow(bw, stations)
+	default:
+		return fmt.Errorf("unknown -format %q", *format)
+	}
+}
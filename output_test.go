@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"slices"
+	"testing"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	"golang.org/x/exp/maps"
+)
+
+// benchStations returns n stations in name order, like a run over an input with n of them.
+func benchStations(n int) []brc.Station {
+	rng := rand.New(rand.NewSource(1))
+	stations := make([]brc.Station, n)
+	for i := range stations {
+		lo, hi := float64(rng.Intn(1000)-999)/10, float64(rng.Intn(1000))/10
+		stations[i] = brc.Station{Name: fmt.Sprintf("station%05d", i), Min: lo, Mean: (lo + hi) / 2, Max: hi, Count: 1 + int64(rng.Intn(1000))}
+	}
+	return stations
+}
+
+// printResFmt is how printRes used to write the text output, with fmt per station, to compare it against.
+func printResFmt(w io.Writer, stations []brc.Station) {
+	byName := make(map[string]*brc.Station, len(stations))
+	for i := range stations {
+		byName[stations[i].Name] = &stations[i]
+	}
+	names := maps.Keys(byName)
+	slices.Sort(names)
+	fmt.Fprintf(w, "{")
+	for _, name := range names {
+		s := byName[name]
+		fmt.Fprintf(w, "%s=%.1f/%.1f/%.1f,", s.Name, s.Min, s.Mean, s.Max)
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+func TestPrintResMatchesFmt(t *testing.T) {
+	stations := benchStations(1000)
+	var got, want bytes.Buffer
+	printRes(&got, stations, nil)
+	printResFmt(&want, stations)
+	if got.String() != want.String() {
+		t.Errorf("printRes and the fmt output differ:\n%.200s\n%.200s", got.String(), want.String())
+	}
+}
+
+func BenchmarkWriteResults(b *testing.B) {
+	res := &brc.Results{Stations: benchStations(10_000)}
+	b.Run("writeResults", func(b *testing.B) {
+		defer func(p string) { *outputPath = p }(*outputPath)
+		*outputPath = os.DevNull
+		b.ReportAllocs()
+		for range b.N {
+			if err := writeResults(res, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("printRes", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			printRes(io.Discard, res.Stations, nil)
+		}
+	})
+	b.Run("fmt", func(b *testing.B) {
+		b.ReportAllocs()
+		for range b.N {
+			printResFmt(io.Discard, res.Stations)
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// writeParquet writes stations as a parquet file: a single row group with one plain-encoded, uncompressed page per
+// column. that's about the simplest valid file there is, but it's typed and spark/duckdb/pandas read it directly, and
+// it doesn't need a dependency. the metadata is thrift's compact protocol, see thriftWriter.
+func writeParquet(w io.Writer, stations []brc.Station) error {
+	cols := parquetColumns(stations)
+
+	out := []byte("PAR1")
+	for i := range cols {
+		c := &cols[i]
+		c.offset = int64(len(out))
+
+		var h thriftWriter
+		h.i32(1, 0) // type: DATA_PAGE
+		h.i32(2, int32(len(c.data)))
+		h.i32(3, int32(len(c.data)))
+		h.structBegin(5) // data_page_header
+		h.i32(1, int32(len(stations)))
+		h.i32(2, 0) // encoding: PLAIN
+		h.i32(3, 3) // definition_level_encoding: RLE, unused since every column is required
+		h.i32(4, 3) // repetition_level_encoding: RLE
+		h.structEnd()
+		h.stop()
+
+		out = append(out, h.buf...)
+		out = append(out, c.data...)
+		c.size = int64(len(out)) - c.offset
+	}
+
+	var m thriftWriter
+	m.i32(1, 1) // version
+	m.listBegin(2, thriftStruct, len(cols)+1)
+	m.elemBegin() // the root of the schema
+	m.str(4, "schema")
+	m.i32(5, int32(len(cols)))
+	m.elemEnd()
+	for _, c := range cols {
+		m.elemBegin()
+		m.i32(1, c.typ)
+		m.i32(3, 0) // repetition_type: REQUIRED
+		m.str(4, c.name)
+		if c.converted >= 0 {
+			m.i32(6, c.converted)
+		}
+		if c.logical != nil {
+			m.structBegin(10)
+			c.logical(&m)
+			m.structEnd()
+		}
+		m.elemEnd()
+	}
+	m.i64(3, int64(len(stations)))
+	m.listBegin(4, thriftStruct, 1)
+	m.elemBegin() // the row group
+	m.listBegin(1, thriftStruct, len(cols))
+	var total int64
+	for _, c := range cols {
+		total += c.size
+		m.elemBegin()
+		m.i64(2, c.offset)
+		m.structBegin(3) // meta_data
+		m.i32(1, c.typ)
+		m.listBegin(2, thriftI32, 1)
+		m.listI32(0) // PLAIN
+		m.listBegin(3, thriftBinary, 1)
+		m.listStr(c.name)
+		m.i32(4, 0) // codec: UNCOMPRESSED
+		m.i64(5, int64(len(stations)))
+		m.i64(6, c.size)
+		m.i64(7, c.size)
+		m.i64(9, c.offset)
+		m.structEnd()
+		m.elemEnd()
+	}
+	m.i64(2, total)
+	m.i64(3, int64(len(stations)))
+	m.elemEnd()
+	m.str(6, "1brc")
+	m.stop()
+
+	out = append(out, m.buf...)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(m.buf)))
+	out = append(out, "PAR1"...)
+	_, err := w.Write(out)
+	return err
+}
+
+// parquet physical and converted types
+const (
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetUTF8            = 0
+	parquetTimestampMillis = 9
+)
+
+type parquetColumn struct {
+	name      string
+	typ       int32
+	converted int32                 // -1 for none
+	logical   func(m *thriftWriter) // writes the LogicalType union, if any
+	data      []byte                // plain encoded values
+
+	offset, size int64 // of the column chunk in the file
+}
+
+func parquetColumns(stations []brc.Station) []parquetColumn {
+	stringType := func(m *thriftWriter) {
+		m.structBegin(1) // STRING
+		m.structEnd()
+	}
+	double := func(name string, v func(s *brc.Station) float64) parquetColumn {
+		c := parquetColumn{name: name, typ: parquetDouble, converted: -1}
+		for i := range stations {
+			c.data = binary.LittleEndian.AppendUint64(c.data, math.Float64bits(v(&stations[i])))
+		}
+		return c
+	}
+	str := func(name string, v func(s *brc.Station) string) parquetColumn {
+		c := parquetColumn{name: name, typ: parquetByteArray, converted: parquetUTF8, logical: stringType}
+		for i := range stations {
+			s := v(&stations[i])
+			c.data = binary.LittleEndian.AppendUint32(c.data, uint32(len(s)))
+			c.data = append(c.data, s...)
+		}
+		return c
+	}
+
+	cols := []parquetColumn{str("station", func(s *brc.Station) string { return escapeName(s.Name) })}
+	if *window > 0 {
+		c := parquetColumn{name: "window", typ: parquetInt64, converted: parquetTimestampMillis, logical: func(m *thriftWriter) {
+			m.structBegin(8) // TIMESTAMP
+			m.bool(1, true)  // isAdjustedToUTC
+			m.structBegin(2) // unit
+			m.structBegin(1) // MILLIS
+			m.structEnd()
+			m.structEnd()
+			m.structEnd()
+		}}
+		for i := range stations {
+			c.data = binary.LittleEndian.AppendUint64(c.data, uint64(stations[i].Window.UnixMilli()))
+		}
+		cols = append(cols, c)
+	}
+	if *columns != "" {
+		cols = append(cols, str("metric", func(s *brc.Station) string { return s.Metric }))
+	}
+	cols = append(cols,
+		double("min", func(s *brc.Station) float64 { return s.Min }),
+		double("mean", func(s *brc.Station) float64 { return s.Mean }),
+		double("max", func(s *brc.Station) float64 { return s.Max }),
+		double("stddev", func(s *brc.Station) float64 { return s.Stddev }),
+	)
+	count := parquetColumn{name: "count", typ: parquetInt64, converted: -1}
+	for i := range stations {
+		count.data = binary.LittleEndian.AppendUint64(count.data, uint64(stations[i].Count))
+	}
+	return append(cols, count)
+}
+
+// thrift compact protocol types
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter writes thrift's compact protocol, just enough of it for parquet metadata. fields have to be written in
+// increasing id order within a struct, which keeps the field headers to a byte.
+type thriftWriter struct {
+	buf  []byte
+	last []int16 // the last field id of each open struct, innermost last
+	cur  int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if delta := id - t.cur; delta > 0 && delta <= 15 {
+		t.buf = append(t.buf, byte(delta)<<4|typ)
+	} else {
+		t.buf = append(t.buf, typ)
+		t.buf = binary.AppendVarint(t.buf, int64(id))
+	}
+	t.cur = id
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.buf = binary.AppendVarint(t.buf, v)
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	if v {
+		t.field(id, thriftTrue)
+	} else {
+		t.field(id, thriftFalse)
+	}
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// elemBegin and elemEnd wrap a struct that's a list element, which has no field header.
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, t.cur)
+	t.cur = 0
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.cur = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.buf = append(t.buf, 0)
+}
+
+// listBegin starts a list field of n elements, which the caller writes next.
+func (t *thriftWriter) listBegin(id int16, elemType byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf = append(t.buf, byte(n)<<4|elemType)
+	} else {
+		t.buf = append(t.buf, 0xf0|elemType)
+		t.buf = binary.AppendUvarint(t.buf, uint64(n))
+	}
+}
+
+func (t *thriftWriter) listI32(v int32) {
+	t.buf = binary.AppendVarint(t.buf, int64(v))
+}
+
+func (t *thriftWriter) listStr(s string) {
+	t.buf = binary.AppendUvarint(t.buf, uint64(len(s)))
+	t.buf = append(t.buf, s...)
+}
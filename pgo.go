@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+var pgoCollect = flag.Bool("pgo-collect", false, "profile the run for profile-guided optimization: write the cpu profile to default.pgo in the current directory, which should be the repo root, and print how to rebuild with it")
+
+// pgoProfile is where go build looks for a profile: default.pgo in the main package's directory.
+const pgoProfile = "default.pgo"
+
+// startPGO points -cpuprofile at a temporary file next to default.pgo, so a failed or interrupted run doesn't replace
+// a good profile.
+func startPGO() error {
+	if *cpuprofile != "" {
+		return errors.New("-pgo-collect writes its own cpu profile, drop -cpuprofile")
+	}
+	*cpuprofile = pgoProfile + ".tmp"
+	return nil
+}
+
+// finishPGO moves the profile into place after a successful run.
+func finishPGO(log *slog.Logger) error {
+	if err := os.Rename(*cpuprofile, pgoProfile); err != nil {
+		return fmt.Errorf("saving profile: %w", err)
+	}
+	if _, err := os.Stat("go.mod"); err != nil {
+		log.Warn("no go.mod here, so this probably isn't the repo root. go build only picks up default.pgo from the main package's directory")
+	}
+	fmt.Fprintf(os.Stderr, "wrote %s. rebuild to use it:\n\n\tgo build -o bin/1brc .\n\nand check it was picked up with `go version -m bin/1brc | grep pgo`. profile warm runs, like the benchmark: if the input wasn't in the page cache, run this again first\n", pgoProfile)
+	return nil
+}
@@ -0,0 +1,141 @@
+package brc
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// WithAdaptiveWorkers has a WithPipeline run fit its number of workers to the input as it goes. it starts with half of
+// them, and every interval looks at where the time went: while the readers mostly wait for a free worker, the run is
+// cpu bound and it spawns another one (and parks it again if that didn't make the run any faster), and while the
+// workers mostly wait for lines, the run is bound by reading and it parks one, leaving the cpu to the readers and the
+// rest. it never goes past the workers from WithWorkers. the decisions end up in Results.Scaling. zero (the default)
+// keeps all the workers going throughout.
+func WithAdaptiveWorkers(interval time.Duration) Option {
+	return func(o *options) { o.adaptInterval = interval }
+}
+
+// A ScalingStep is a change WithAdaptiveWorkers made to the number of workers, and what it was going by.
+type ScalingStep struct {
+	At      time.Duration // since the start of processing
+	Workers int           // active after the change
+	Starved float64       // fraction of the last interval the active workers spent waiting for lines
+	Blocked float64       // fraction of the last interval the readers spent waiting for a free worker
+	MBps    float64       // aggregated over the last interval
+	Reason  string
+}
+
+// the thresholds for a scaling step: a side that spends more than this fraction of its time waiting on the other is
+// the one there's too much of.
+const (
+	scaleUpBlocked   = 0.5
+	scaleDownStarved = 0.5
+	scaleUpGain      = 1.05 // how much faster another worker has to make the run to stay
+	scaleUpCooldown  = 10   // intervals to wait before trying another worker after one didn't help
+)
+
+// scaler is the state WithAdaptiveWorkers shares between a pipeline's workers, readers and the goroutine deciding on
+// the number of workers. workers at or past target park until they're woken.
+type scaler struct {
+	target  atomic.Int32
+	wake    []chan struct{}
+	waited  atomic.Int64 // ns the workers spent waiting for batches
+	blocked atomic.Int64 // ns the readers spent waiting to send batches
+	bytes   atomic.Int64 // aggregated by the workers
+	steps   []ScalingStep
+}
+
+func newScaler(workers int) *scaler {
+	s := &scaler{wake: make([]chan struct{}, workers)}
+	for i := range s.wake {
+		s.wake[i] = make(chan struct{}, 1)
+	}
+	s.target.Store(int32(max(1, workers/2)))
+	return s
+}
+
+// park blocks worker i for as long as it's past the target. it returns false if the worker should stop instead,
+// because ctx is done or there's nothing left to read.
+func (s *scaler) park(ctx context.Context, i int, done <-chan struct{}) bool {
+	for int32(i) >= s.target.Load() {
+		select {
+		case <-s.wake[i]:
+		case <-ctx.Done():
+			return false
+		case <-done:
+			return false
+		}
+	}
+	return true
+}
+
+// run adjusts the target every interval until ctx is done or done is closed. spawn starts the goroutine of a worker
+// that hasn't run yet.
+func (s *scaler) run(ctx context.Context, interval time.Duration, readers int, begin time.Time, done <-chan struct{}, spawn func(i int)) {
+	started := int(s.target.Load())
+	for i := range started {
+		spawn(i)
+	}
+	s.steps = append(s.steps, ScalingStep{Workers: started, Reason: "start with half the workers"})
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	last := time.Now()
+	var trialRate float64 // the rate before the last worker was added, while it's on trial
+	cooldown := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		dt := now.Sub(last).Seconds()
+		last = now
+		active := int(s.target.Load())
+		step := ScalingStep{
+			At:      now.Sub(begin),
+			Starved: float64(s.waited.Swap(0)) / 1e9 / dt / float64(active),
+			Blocked: float64(s.blocked.Swap(0)) / 1e9 / dt / float64(readers),
+			MBps:    float64(s.bytes.Swap(0)) / (1 << 20) / dt,
+		}
+		cooldown = max(0, cooldown-1)
+
+		switch {
+		case trialRate > 0:
+			if step.MBps < trialRate*scaleUpGain {
+				step.Workers = active - 1
+				step.Reason = fmt.Sprintf("no faster with %d workers than with %d", active, active-1)
+				cooldown = scaleUpCooldown
+			}
+			trialRate = 0
+		case step.Blocked > scaleUpBlocked && active < len(s.wake) && cooldown == 0:
+			step.Workers = active + 1
+			step.Reason = "readers waited for workers, cpu bound"
+			trialRate = step.MBps
+		case step.Starved > scaleDownStarved && active > 1:
+			step.Workers = active - 1
+			step.Reason = "workers waited for input, read bound"
+		}
+		if step.Workers == 0 {
+			continue
+		}
+		s.target.Store(int32(step.Workers))
+		if step.Workers > active {
+			if active < started {
+				select {
+				case s.wake[active] <- struct{}{}:
+				default:
+				}
+			} else {
+				spawn(active)
+				started++
+			}
+		}
+		s.steps = append(s.steps, step)
+	}
+}
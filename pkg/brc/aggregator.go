@@ -0,0 +1,46 @@
+package brc
+
+import "errors"
+
+// An Aggregator is a custom per-station aggregation, computed alongside the built-in ones by the same scanning and
+// hashing code. WithAggregator makes one per station per worker, and the ones for the same station are merged at the
+// end. e.g. to count the readings above 30°C:
+//
+//	type hotDays struct{ n int64 }
+//
+//	func (h *hotDays) Observe(_ uint64, temp float32) {
+//		if temp > 30 {
+//			h.n++
+//		}
+//	}
+//	func (h *hotDays) Merge(o brc.Aggregator) { h.n += o.(*hotDays).n }
+//	func (h *hotDays) Result() any             { return h.n }
+//
+//	res, err := brc.ProcessFile(path, brc.WithAggregator(func() brc.Aggregator { return new(hotDays) }))
+//	// res.Stations[i].Aggregate is an int64
+type Aggregator interface {
+	// Observe records a reading for the station. stationID identifies it the same way in every worker and run (it's
+	// the hash of its name), for aggregations that want to key something by station.
+	Observe(stationID uint64, temp float32)
+	// Merge folds in the aggregate of the same station's readings in another worker. other is always one that came
+	// from the same WithAggregator func.
+	Merge(other Aggregator)
+	// Result is what ends up in Station.Aggregate.
+	Result() any
+}
+
+// WithAggregator computes an Aggregator from newAggregator for each station, on top of the built-in aggregates. its
+// cost depends on the Aggregator, plus an interface call per reading. Aggregators can't be serialized, so it doesn't
+// go with WithCheckpoint or Partial.MarshalBinary.
+func WithAggregator(newAggregator func() Aggregator) Option {
+	return func(o *options) { o.aggregator = newAggregator }
+}
+
+var errAggregatorPartial = errors.New("partials with custom aggregators can't be serialized")
+
+func aggregateResult(a Aggregator) any {
+	if a == nil {
+		return nil
+	}
+	return a.Result()
+}
@@ -0,0 +1,557 @@
+// Package brc is the aggregator behind the 1brc binary: it computes min/mean/max temperatures per weather station
+// from "station;temperature" lines, as fast as we could make it.
+//
+// building with -tags purego leaves out everything platform specific: the arm64 assembly, mmap (the file is read into
+// memory instead), madvise and huge pages, pinning, and the other syscalls and unsafe bits, along with xxhash's
+// assembly. it's slower, but builds and runs wherever go does.
+package brc
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Results are the merged per-station aggregates.
+type Results struct {
+	Stations []Station // sorted by name, byte-wise, then by window and metric
+	Workers  []WorkerStats
+	Rejected int64         // malformed lines that were skipped, see WithOnError
+	Elapsed  time.Duration // wall time of the aggregation itself, without setting up the input or merging. 0 for Merge
+	Scaling  []ScalingStep // the changes to the number of workers, with WithAdaptiveWorkers
+}
+
+// Station holds the aggregates for one station.
+type Station struct {
+	Name           string
+	Min, Mean, Max float64
+	Sum            float64
+	Count          int64
+	Stddev         float64   // population standard deviation, with WithStddev. NaN otherwise, or if Partial.Add made it
+	Window         time.Time // start of the time window, with WithWindow. zero otherwise
+	Metric         string    // with WithColumns, which metric these are the aggregates of. empty otherwise
+	Aggregate      any       // the Result of the station's Aggregator, with WithAggregator. nil otherwise
+	Keys           []string  // with WithGroupBy, the key columns, which Name is joined by semicolons. nil otherwise
+
+	digest *tdigest
+	hist   *histogram
+}
+
+// Quantile estimates the q-th quantile (0 <= q <= 1) of the station's readings. it needs WithQuantiles, and isn't
+// available for stations an engine only provided aggregates for (see Partial.Add).
+func (s *Station) Quantile(q float64) (float64, bool) {
+	if s.digest == nil {
+		return 0, false
+	}
+	return s.digest.quantile(q), true
+}
+
+// Histogram returns the station's reading counts in 1°C bins, see HistogramStart. it needs WithHistograms, and isn't
+// available for stations an engine only provided aggregates for (see Partial.Add).
+func (s *Station) Histogram() ([]int64, bool) {
+	if s.hist == nil {
+		return nil, false
+	}
+	return s.hist[:], true
+}
+
+// Rows returns the total number of readings across all stations.
+func (r *Results) Rows() int64 {
+	var rows int64
+	for _, s := range r.Stations {
+		rows += s.Count
+	}
+	return rows
+}
+
+func newResults(partials []*Partial, workers []WorkerStats, o *options) *Results {
+	enterStage(o.ctx, stageMerge, -1)
+	defer pprof.SetGoroutineLabels(o.ctx)
+	region := trace.StartRegion(o.ctx, "merge")
+	endMerge := o.span("merge", "partials", len(partials))
+	merged := mergeResults(partials)
+	if o.foldCase {
+		merged = foldStations(merged)
+	}
+	if o.rollUp != nil {
+		merged = rollUp(merged, o.rollUp)
+	}
+	endMerge(nil)
+	region.End()
+	res := &Results{Stations: make([]Station, 0, merged.Len()), Workers: workers, Scaling: o.scaling}
+	if len(workers) > 0 {
+		// every worker's times add up to the whole of it, see finishWorkerStats
+		res.Elapsed = workers[0].Start + workers[0].Busy + workers[0].Idle
+	}
+	for _, p := range partials {
+		res.Rejected += p.rejected
+	}
+	merged.ForEach(func(_ uint64, s *stats) {
+		for ; s != nil; s = s.next {
+			res.Stations = append(res.Stations, Station{
+				Name:      s.station,
+				Min:       float64(s.min),
+				Mean:      float64(s.sum / s.count),
+				Max:       float64(s.max),
+				Sum:       float64(s.sum),
+				Count:     int64(s.count),
+				Stddev:    s.stddev(),
+				Window:    windowTime(s.window, o.window > 0),
+				Metric:    o.metricName(s.metric),
+				Aggregate: aggregateResult(s.agg),
+				Keys:      o.stationKeys(s.station),
+				digest:    s.digest,
+				hist:      s.hist,
+			})
+		}
+	})
+	slices.SortFunc(res.Stations, func(a, b Station) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		if c := a.Window.Compare(b.Window); c != 0 {
+			return c
+		}
+		return cmp.Compare(slices.Index(o.metrics, a.Metric), slices.Index(o.metrics, b.Metric))
+	})
+	return res
+}
+
+// ProcessFile aggregates the measurements in the file at path. the file is mmapped and split into one chunk of whole
+// lines per worker, or into chunks of WithChunkSize. if the context from WithContext is cancelled, it returns the
+// results so far along with an error wrapping the context's.
+//
+// invocation: $ ./make.sh && hyperfine -w1 -m5 ./bin/1brc
+// (or without hyperfine: $ ./bin/1brc bench. both run with the gc off, see -gc)
+//
+// (for 100m rows)
+// 12.338 s ± 0.026 s - start
+// 11.989 s ±  0.095 s - increase scanner buffer
+// 3.544 s ±  0.061 s - parallelize
+// - trace analysis: workers are starved. can we increase the read speed?
+// 3.665 s ±  0.133 s - mmap char by char (a bit slower)
+// 3.329 s ±  0.075 s - mmap with buffered reading
+// 2.490 s ±  0.087 s - same but with GOGC=off. now we're just cpu bound i think
+// 2.402 s ±  0.068 s - custom float parsing
+// 1.538 s ±  0.068 s - custom semicolon splitting
+// 1.239 s ±  0.048 s - prealllocating hash tables with 10k size
+// 1.116 s ±  0.056 s - interning station names
+// ** swapping to 1b rows **
+// 11.552 s ±  0.409 s - above
+// 11.542 s ±  0.083 s - switch to float32s
+// 11.276 s ±  0.385 s - optimized parsefloat more
+// 10.921 s ±  0.275 s - fixed unnecessary conversions in hash+interning
+// 10.334 s ±  0.364 s - pgo
+// 9.893 s ±  0.162 s  - manual mmap
+// 9.389 s ±  0.105 s  - manual line handling
+// 9.085 s ±  0.121 s  - cleanup + better run?
+// 7.818 s ±  0.250 s - switch from hash to just byte sum
+// 7.346 s ±  0.144 s - swiss map
+// 4.530 s ±  0.077 s - intmap plus remove interning indirection
+// 4.134 s ±  0.118 s - guess based split on semi
+// 4.418 s ±  0.129 s - use a real hash function to make it more legit. slower :(
+// ** on a single cpu, 28m rows, with `1brc bench -runs 10` **
+// 1.052 s ±  0.023 s - above, plus the changes since
+// 0.960 s ±  0.071 s - swar temperature parsing
+//
+// graveyard:
+// - iterating in reverse order in splitOnSemi
+// - using [swiss maps](https://github.com/dolthub/swiss) instead of builtin
+// - replacing *stats with stats in builtin maps (they live in the slots of a custom table now, see table)
+// - manual loop var stuff
+// - using bytes.IndexByte instead of a for loop to split on lines
+// - finding line ends 16 bytes at a time with the portable scanNewlines instead of the byte loop (~30% slower on amd64:
+// 360 vs 250 MB/s in `go test -bench 'Run$' ./pkg/brc`, bytes vs batched). arm64 does it with neon instead, which
+// BenchmarkRun compares the same way there
+func ProcessFile(path string, opts ...Option) (*Results, error) {
+	o := newOptions(opts)
+	log := o.log
+
+	newEngine, err := o.newEngine()
+	if err != nil {
+		return nil, err
+	}
+
+	g, ctx := newGroup(o.ctx)
+
+	if o.maxMemory > 0 && (o.prefault || o.hugePages == "copy") {
+		return nil, fmt.Errorf("a memory cap doesn't work with prefaulting or copied huge pages")
+	}
+	defer pprof.SetGoroutineLabels(o.ctx)
+	enterStage(o.ctx, stageMap, -1)
+	endMmap := o.span("mmap", "path", path, "prefault", o.prefault, "hugepages", o.hugePages)
+	mmappedFile, close, err := setupMmap(path, o.prefault)
+	if err != nil {
+		endMmap(err)
+		return nil, fmt.Errorf("setting up mmap: %w", err)
+	}
+	defer close()
+
+	mmappedFile, releaseHuge, err := setupHugePages(mmappedFile, o.hugePages)
+	endMmap(err)
+	if err != nil {
+		return nil, fmt.Errorf("setting up huge pages: %w", err)
+	}
+	defer releaseHuge()
+
+	fileLen := len(mmappedFile)
+
+	enterStage(o.ctx, stageScan, -1)
+	dataStart := headerLen(mmappedFile)
+	numChunks, numWorkers := o.chunkPlan(int64(fileLen - dataStart))
+
+	if o.perfectHash {
+		ph := newPerfectHash(sampleStations(mmappedFile[dataStart:]))
+		log.Debug("built perfect hash", "stations", len(ph.keys))
+		newEngine = func() Engine { return newPerfectEngine(ph) }
+	}
+
+	var index []int64
+	if o.writeIndex || o.useIndex {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if o.writeIndex {
+			index = buildIndex(mmappedFile, dataStart)
+			if err := writeIndexFile(indexPath(path), int64(fileLen), fi.ModTime(), index); err != nil {
+				return nil, fmt.Errorf("writing index: %w", err)
+			}
+		} else {
+			var ok bool
+			if index, ok, err = readIndexFile(indexPath(path), int64(fileLen), fi.ModTime()); err != nil {
+				log.Warn("ignoring unreadable index", "err", err)
+			} else if !ok {
+				log.Debug("no usable index, scanning for chunk boundaries")
+			} else if !indexMatches(mmappedFile, dataStart, index) {
+				log.Warn("ignoring stale index, its offsets aren't at line starts", "path", indexPath(path))
+				index = nil
+			}
+		}
+	}
+
+	chunks := planChunks(mmappedFile, dataStart, numChunks, index)
+	if o.checkpointDir != "" {
+		if err := os.MkdirAll(o.checkpointDir, 0o755); err != nil {
+			return nil, fmt.Errorf("creating checkpoint dir: %w", err)
+		}
+	}
+	rs, err := o.newRunState()
+	if err != nil {
+		return nil, err
+	}
+
+	partials := make([]*Partial, numWorkers)
+	workerStats := make([]WorkerStats, numWorkers)
+	begin := time.Now()
+	o.progress.start(numWorkers, int64(fileLen-dataStart))
+
+	// each worker starts on its own chunk, and with more chunks than workers, takes the next one left as it finishes
+	var next atomic.Int64
+	next.Store(int64(numWorkers))
+	for i := range numWorkers {
+		res := newPartial(o)
+		partials[i] = res
+		ws := &workerStats[i]
+
+		g.Go(func() (err error) {
+			ctx, task := workerTask(enterStage(ctx, stageParse, i), i)
+			defer task.End()
+			attrs := []any{"worker", i}
+			if numChunks == numWorkers {
+				attrs = append(attrs, "offset", chunks[i].start, "bytes", chunks[i].end-chunks[i].start)
+			}
+			end := o.span("worker", attrs...)
+			defer func() { end(err) }()
+			ws.Start = time.Since(begin)
+			o.progress.busy(1)
+			defer func() {
+				ws.Busy = time.Since(begin) - ws.Start
+				o.progress.busy(-1)
+			}()
+
+			o.pinWorker(i)
+
+			w := newEngine()
+			for c := i; c < len(chunks); c = int(next.Add(1) - 1) {
+				chunk := chunks[c]
+				ws.Bytes += int64(chunk.end - chunk.start)
+				if o.madvise {
+					if err := adviseChunk(mmappedFile, chunk.start, chunk.end); err != nil {
+						log.Warn("madvise failed", "err", err)
+					}
+				}
+
+				if o.checkpointDir != "" {
+					err = runCheckpointed(ctx, o, i, w, mmappedFile, chunk, res, rs)
+				} else if o.maxMemory > 0 {
+					err = runBounded(ctx, w, mmappedFile, chunk, memoryWindow(o.maxMemory, numWorkers), res, rs)
+				} else {
+					err = runChunk(ctx, w, mmappedFile[chunk.start:chunk.end], int64(chunk.start), res, rs)
+				}
+				if err != nil {
+					setLineNumber(err, func(offset int64) (int64, error) {
+						return int64(bytes.Count(mmappedFile[:offset], []byte{'\n'})), nil
+					})
+					return fmt.Errorf("worker %d: %w", i, err)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	finishWorkerStats(workerStats, partials, time.Since(begin))
+	if err != nil {
+		if o.ctx.Err() != nil {
+			return newResults(partials, workerStats, o), interrupted(o.ctx)
+		}
+		return nil, err
+	}
+
+	if o.checkpointDir != "" {
+		if err := removeCheckpoints(o.checkpointDir, numWorkers); err != nil {
+			log.Warn("couldn't remove checkpoints", "err", err)
+		}
+	}
+
+	// the mapping only covers the size the file had when we opened it. producers may still be appending to it, and the
+	// last line we saw may be incomplete, in which case the workers skipped it.
+	consumed := int64(bytes.LastIndexByte(mmappedFile, '\n') + 1)
+	if (o.followIdle > 0 || o.followUpdate != nil) && rs.budget == nil {
+		tail := newPartial(o)
+		var update func()
+		if o.followUpdate != nil {
+			update = func() { o.followUpdate(newResults(snapshots(append(slices.Clip(partials), tail)), nil, o)) }
+		}
+		tail, err := followFile(o.ctx, path, consumed, o.followIdle, o.followEvery, update, newEngine(), tail, rs)
+		if tail != nil {
+			partials = append(partials, tail)
+		}
+		if err != nil {
+			if o.ctx.Err() != nil {
+				return newResults(partials, workerStats, o), interrupted(o.ctx)
+			}
+			return nil, fmt.Errorf("following file: %w", err)
+		}
+	} else if fi, err := os.Stat(path); err == nil && fi.Size() > int64(fileLen) {
+		log.Warn("file grew while it was being processed, results only cover the initial data", "processed_bytes", consumed, "current_bytes", fi.Size())
+	} else if tail := mmappedFile[max(int(consumed), dataStart):]; len(tail) > 0 {
+		// the file doesn't end in a newline, and isn't being written to, so its last line is complete after all
+		p := newPartial(o)
+		if err := runChunk(o.ctx, newEngine(), append(slices.Clip(tail), '\n'), int64(fileLen-len(tail)), p, rs); err != nil {
+			if o.ctx.Err() != nil {
+				return newResults(partials, workerStats, o), interrupted(o.ctx)
+			}
+			setLineNumber(err, func(offset int64) (int64, error) {
+				return int64(bytes.Count(mmappedFile[:offset], []byte{'\n'})), nil
+			})
+			return nil, fmt.Errorf("last line: %w", err)
+		}
+		partials = append(partials, p)
+	}
+
+	if o.hugePages != "off" {
+		n, err := hugePageBytes(mmappedFile)
+		if err != nil {
+			log.Warn("couldn't check huge page usage", "err", err)
+		}
+		log.Info("huge pages", "mode", o.hugePages, "huge_bytes", n, "total_bytes", fileLen, "used", n > 0)
+	}
+
+	res := newResults(partials, workerStats, o)
+	o.progress.finish(res)
+	return res, nil
+}
+
+// defaultBlockSize is how much Process reads at a time before handing lines to a worker, see WithReadBlockSize.
+const defaultBlockSize = 16 << 20
+
+// Process aggregates measurements from r, for inputs that can't be mmapped (pipes, sockets, decompressors...). one
+// goroutine reads blocks of complete lines which the workers take turns aggregating. the file-only options (madvise,
+// huge pages, prefault, index, follow) don't apply. cancellation works like it does for ProcessFile.
+func Process(r io.Reader, opts ...Option) (*Results, error) {
+	o := newOptions(opts)
+	newEngine, err := o.newEngine()
+	if err != nil {
+		return nil, err
+	}
+	if o.pipelineReaders > 0 {
+		partials, workerStats, err := processPipeline(r, o, newEngine)
+		if o.ctx.Err() != nil && partials != nil {
+			return newResults(partials, workerStats, o), interrupted(o.ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := newResults(partials, workerStats, o)
+		o.progress.finish(res)
+		return res, nil
+	}
+
+	type block struct {
+		buf        []byte
+		start, end int
+		offset     int64 // of buf[start] in the input
+		line       int64 // lines before buf[start], only counted with WithOnError("fail")
+	}
+	// a fixed set of buffers cycles between the reader and the workers, which bounds memory use. each has a spare byte
+	// at the end for readBlocks
+	free := make(chan []byte, 2*o.workers)
+	for range cap(free) {
+		free <- make([]byte, o.blockSize+1)
+	}
+	blocks := make(chan block)
+
+	partials := make([]*Partial, o.workers)
+	workerStats := make([]WorkerStats, o.workers)
+	begin := time.Now()
+	o.progress.start(o.workers, 0)
+	rs, err := o.newRunState()
+	if err != nil {
+		return nil, err
+	}
+	g, ctx := newGroup(o.ctx)
+	for i := range o.workers {
+		res := newPartial(o)
+		partials[i] = res
+		ws := &workerStats[i]
+
+		g.Go(func() (err error) {
+			ctx, task := workerTask(enterStage(ctx, stageParse, i), i)
+			defer task.End()
+			end := o.span("worker", "worker", i)
+			defer func() { end(err) }()
+			ws.Start = time.Since(begin)
+			o.pinWorker(i)
+			w := newEngine()
+			for {
+				wait := trace.StartRegion(ctx, "wait for block")
+				select {
+				case <-ctx.Done():
+					wait.End()
+					return nil // whoever cancelled has the error
+				case b, ok := <-blocks:
+					wait.End()
+					if !ok {
+						return nil
+					}
+					runStart := time.Now()
+					o.progress.busy(1)
+					err := runChunk(ctx, w, b.buf[b.start:b.end], b.offset, res, rs)
+					o.progress.busy(-1)
+					if err != nil {
+						setLineNumber(err, func(offset int64) (int64, error) {
+							return b.line + int64(bytes.Count(b.buf[b.start:b.start+int(offset-b.offset)], []byte{'\n'})), nil
+						})
+						return fmt.Errorf("worker %d: %w", i, err)
+					}
+					ws.Busy += time.Since(runStart)
+					ws.Bytes += int64(b.end - b.start)
+					free <- b.buf
+				}
+			}
+		})
+	}
+
+	g.Go(func() error {
+		enterStage(ctx, stageScan, -1)
+		defer close(blocks)
+		var lines int64
+		return readBlocks(ctx, r, free, func(buf []byte, start, end int, offset int64) bool {
+			if rs.budget.exhausted() {
+				return false
+			}
+			b := block{buf, start, end, offset, 0}
+			if rs.fail {
+				b.line = lines + int64(bytes.Count(buf[:start], []byte{'\n'}))
+				lines = b.line + int64(bytes.Count(buf[start:end], []byte{'\n'}))
+			}
+			select {
+			case blocks <- b:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	})
+
+	err = g.Wait()
+	finishWorkerStats(workerStats, partials, time.Since(begin))
+	if o.ctx.Err() != nil {
+		return newResults(partials, workerStats, o), interrupted(o.ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := newResults(partials, workerStats, o)
+	o.progress.finish(res)
+	return res, nil
+}
+
+// interrupted is the error for runs cut short by the caller's context. unlike other errors, it comes with the results
+// aggregated up to that point.
+func interrupted(ctx context.Context) error {
+	return fmt.Errorf("interrupted, results are partial: %w", ctx.Err())
+}
+
+// readBlocks fills buffers from free and passes the complete lines in them to send, along with the input offset of
+// buf[start], carrying any partial line at the end of a buffer over to the next one. the last byte of each buffer is
+// kept free for terminating the last line. it stops when ctx is cancelled or
+// send returns false.
+func readBlocks(ctx context.Context, r io.Reader, free chan []byte, send func(buf []byte, start, end int, offset int64) bool) error {
+	var carry []byte
+	var pos int64 // input offset of buf[0]
+	first := true
+	for {
+		var buf []byte
+		select {
+		case buf = <-free:
+		case <-ctx.Done():
+			return nil
+		}
+		n := copy(buf, carry)
+		m, err := io.ReadFull(r, buf[n:len(buf)-1])
+		n += m
+		eof := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !eof {
+			free <- buf
+			return fmt.Errorf("reading: %w", err)
+		}
+
+		start := 0
+		if first {
+			start = headerLen(buf[:n])
+			first = false
+		}
+		end := n
+		if !eof {
+			end = bytes.LastIndexByte(buf[:n], '\n') + 1
+			if end <= start {
+				free <- buf
+				return fmt.Errorf("line longer than %d bytes", len(buf)-1)
+			}
+			carry = append(carry[:0], buf[end:n]...)
+		} else if n > start && buf[n-1] != '\n' {
+			// the input doesn't end in a newline. engines only take full lines, so add it in the spare byte
+			buf[n] = '\n'
+			end++
+		}
+		if !send(buf, start, end, pos+int64(start)) {
+			return nil
+		}
+		pos += int64(end)
+		if eof {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// naiveStation is what naiveAggregate gets for a station, to check the real thing against.
+type naiveStation struct {
+	min, max, sum float64
+	count         int64
+}
+
+// naiveAggregate aggregates lines of station;temperature the obvious way, taking the input's last line whether or not
+// it ends in a newline.
+func naiveAggregate(t *testing.T, data string) map[string]*naiveStation {
+	t.Helper()
+	stations := map[string]*naiveStation{}
+	for _, line := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		if line == "" {
+			continue
+		}
+		name, temp, ok := strings.Cut(line, ";")
+		if !ok {
+			t.Fatalf("bad test line %q", line)
+		}
+		v, err := strconv.ParseFloat(temp, 64)
+		if err != nil {
+			t.Fatalf("bad test line %q: %v", line, err)
+		}
+		s := stations[name]
+		if s == nil {
+			s = &naiveStation{min: v, max: v}
+			stations[name] = s
+		}
+		s.min, s.max, s.sum, s.count = min(s.min, v), max(s.max, v), s.sum+v, s.count+1
+	}
+	return stations
+}
+
+func checkResults(t *testing.T, res *Results, want map[string]*naiveStation) {
+	t.Helper()
+	if len(res.Stations) != len(want) {
+		t.Errorf("got %d stations, want %d", len(res.Stations), len(want))
+	}
+	for _, s := range res.Stations {
+		w := want[s.Name]
+		if w == nil {
+			t.Errorf("got unexpected station %q", s.Name)
+			continue
+		}
+		// the stats are float32s, and the mean's sum is one too
+		if float32(s.Min) != float32(w.min) || float32(s.Max) != float32(w.max) || s.Count != w.count || math.Abs(s.Mean-w.sum/float64(w.count)) > 0.005 {
+			t.Errorf("got %s=%.1f/%.1f/%.1f (%d), want %.1f/%.1f/%.1f (%d)", s.Name, s.Min, s.Mean, s.Max, s.Count, w.min, w.sum/float64(w.count), w.max, w.count)
+		}
+	}
+}
+
+// processors run data through each of the ways in, with opts.
+var processors = []struct {
+	name string
+	run  func(t *testing.T, data string, opts ...Option) (*Results, error)
+}{
+	{"Process", func(t *testing.T, data string, opts ...Option) (*Results, error) {
+		return Process(strings.NewReader(data), opts...)
+	}},
+	{"ProcessFile", func(t *testing.T, data string, opts ...Option) (*Results, error) {
+		path := filepath.Join(t.TempDir(), "measurements.txt")
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return ProcessFile(path, opts...)
+	}},
+	{"ProcessReaderAt", func(t *testing.T, data string, opts ...Option) (*Results, error) {
+		return ProcessReaderAt(strings.NewReader(data), int64(len(data)), opts...)
+	}},
+}
+
+func TestLastLine(t *testing.T) {
+	for _, tc := range []struct {
+		name, data string
+		err        error
+	}{
+		{"trailing newline", "A;1.0\nB;2.0\nA;3.0\n", nil},
+		{"no trailing newline", "A;1.0\nB;2.0\nA;3.0", nil},
+		{"single line without newline", "A;-1.5", nil},
+		{"crlf last line without newline", "A;1.0\r\nB;2.0", nil},
+		{"crlf last line without its newline", "A;1.0\r\nB;2.0\r", nil},
+		{"empty", "", nil},
+		// an empty line, like any other
+		{"only a newline", "\n", ErrMalformedLine},
+	} {
+		for _, p := range processors {
+			t.Run(tc.name+"/"+p.name, func(t *testing.T) {
+				res, err := p.run(t, tc.data)
+				if tc.err != nil {
+					if !errors.Is(err, tc.err) {
+						t.Fatalf("got %v, want %v", err, tc.err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				checkResults(t, res, naiveAggregate(t, tc.data))
+			})
+		}
+	}
+}
+
+// linesOfLength returns lines of station;temperature, all as long as each other, followed by a last line without a
+// newline whose station name is padded so that the whole thing is exactly n bytes.
+func linesOfLength(n int) string {
+	const line = len("s00;00.0\n")
+	var b []byte
+	for i := range (n - len("s;0.0")) / line {
+		b = fmt.Appendf(b, "s%02d;%.1f\n", i%13, float64(i%900+100)/10) // 10.0 to 99.9, all as long
+	}
+	return string(b) + "s" + strings.Repeat("x", n-len(b)-len("s;0.0")) + ";0.0"
+}
+
+// the missing newline at the end, wherever it falls relative to the blocks, chunks and windows the input is read in.
+func TestLastLineBoundaries(t *testing.T) {
+	for _, p := range processors {
+		if p.name == "ProcessFile" {
+			continue // it maps the whole file
+		}
+		// the input's end at every offset into a block, including exactly at its end
+		for blockSize := 16; blockSize <= 48; blockSize++ {
+			for _, data := range []string{linesOfLength(200), linesOfLength(201), linesOfLength(200) + "\n"} {
+				t.Run(fmt.Sprintf("%s/block %d/%d bytes", p.name, blockSize, len(data)), func(t *testing.T) {
+					res, err := p.run(t, data, WithReadBlockSize(blockSize), WithWorkers(3))
+					if err != nil {
+						t.Fatal(err)
+					}
+					checkResults(t, res, naiveAggregate(t, data))
+				})
+			}
+		}
+	}
+
+	// several chunks of at least minChunkSize, with the input ending at different offsets into the last one
+	for _, n := range []int{3 * minChunkSize, 3*minChunkSize + 1, 3*minChunkSize + 7, 4*minChunkSize - 1} {
+		data := linesOfLength(n)
+		for _, opts := range [][]Option{
+			{WithWorkers(3)},
+			{WithWorkers(2), WithChunkSize(minChunkSize)},
+		} {
+			for _, p := range processors {
+				t.Run(fmt.Sprintf("%s/%d bytes/%d options", p.name, len(data), len(opts)), func(t *testing.T) {
+					res, err := p.run(t, data, opts...)
+					if err != nil {
+						t.Fatal(err)
+					}
+					checkResults(t, res, naiveAggregate(t, data))
+				})
+			}
+		}
+	}
+
+	// ProcessFile's memory cap windows, with the input ending just before, at and just after a window's end
+	window := memoryWindow(2*minMemoryWindow, 1)
+	for _, n := range []int{window - 1, window, window + 1, window + 11} {
+		data := linesOfLength(n)
+		t.Run(fmt.Sprintf("ProcessFile/max memory/%d bytes", len(data)), func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "measurements.txt")
+			if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			res, err := ProcessFile(path, WithWorkers(1), WithMaxMemory(2*minMemoryWindow))
+			if err != nil {
+				t.Fatal(err)
+			}
+			checkResults(t, res, naiveAggregate(t, data))
+		})
+	}
+}
+
+func TestWindowWholeSeconds(t *testing.T) {
+	data := "A;1.0;100\nA;2.0;101\nA;3.0;102\n"
+	for _, w := range []time.Duration{300 * time.Millisecond, 1500 * time.Millisecond, -time.Second} {
+		if _, err := Process(strings.NewReader(data), WithWindow(w)); err == nil {
+			t.Errorf("WithWindow(%v) didn't fail", w)
+		}
+	}
+	res, err := Process(strings.NewReader(data), WithWindow(2*time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Stations) != 2 || res.Stations[0].Count != 2 || res.Stations[1].Count != 1 || res.Stations[1].Window.Unix() != 102 {
+		t.Errorf("got %+v, want windows at 100 and 102 with 2 and 1 rows", res.Stations)
+	}
+}
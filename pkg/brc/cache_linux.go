@@ -0,0 +1,71 @@
+package brc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// cacheSizes returns the size of cpu0's L2 cache and its share of the L3, the L3 divided between the cpus sharing it,
+// from sysfs. zero for caches it couldn't find.
+func cacheSizes() (l2, l3 int64) {
+	dirs, _ := filepath.Glob("/sys/devices/system/cpu/cpu0/cache/index*")
+	for _, dir := range dirs {
+		read := func(name string) string {
+			b, _ := os.ReadFile(filepath.Join(dir, name))
+			return strings.TrimSpace(string(b))
+		}
+		if read("type") == "Instruction" {
+			continue
+		}
+		size, err := parseCacheSize(read("size"))
+		if err != nil {
+			continue
+		}
+		switch read("level") {
+		case "2":
+			l2 = size
+		case "3":
+			l3 = size / int64(max(1, countCPUList(read("shared_cpu_list"))))
+		}
+	}
+	return l2, l3
+}
+
+// parseCacheSize parses sizes like 2048K.
+func parseCacheSize(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "K"):
+		s, mult = strings.TrimSuffix(s, "K"), 1<<10
+	case strings.HasSuffix(s, "M"):
+		s, mult = strings.TrimSuffix(s, "M"), 1<<20
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("bad cache size %q", s)
+	}
+	return n * mult, nil
+}
+
+// countCPUList counts the cpus in a list like 0-3,8-11.
+func countCPUList(s string) int {
+	n := 0
+	for _, r := range strings.Split(s, ",") {
+		lo, hi, ok := strings.Cut(r, "-")
+		a, err := strconv.Atoi(lo)
+		if err != nil {
+			continue
+		}
+		b := a
+		if ok {
+			if b, err = strconv.Atoi(hi); err != nil {
+				continue
+			}
+		}
+		n += max(0, b-a+1)
+	}
+	return n
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package brc
+
+// cache sizes only come from sysfs, elsewhere autoChunkSize makes do with its defaults.
+func cacheSizes() (l2, l3 int64) {
+	return 0, 0
+}
@@ -0,0 +1,96 @@
+package brc
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// cgroupCPUQuota returns how many cpus' worth of time the process's cgroups allow it, if any of them have a quota.
+// both cgroup v2 (cpu.max) and v1 (cpu.cfs_quota_us) count, as do the quotas of parent cgroups, and the smallest
+// wins. inside a container the path in /proc/self/cgroup usually isn't mounted, and the walk up to the root of the
+// mount ends up at the container's own cgroup.
+func cgroupCPUQuota() (float64, bool) {
+	b, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	quota, found := 0.0, false
+	take := func(q float64, ok bool) {
+		if ok && (!found || q < quota) {
+			quota, found = q, true
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		// hierarchy-id:controllers:path. v2 is hierarchy 0 with no controllers listed
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			for _, root := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+				take(walkCgroup(root, parts[2], readCPUMax))
+			}
+		} else if slices.Contains(strings.Split(parts[1], ","), "cpu") {
+			for _, root := range []string{"/sys/fs/cgroup/cpu", "/sys/fs/cgroup/cpu,cpuacct"} {
+				take(walkCgroup(root, parts[2], readCFSQuota))
+			}
+		}
+	}
+	return quota, found
+}
+
+// walkCgroup calls read on the cgroup at path under root and each of its parents up to root, returning the smallest
+// quota it finds.
+func walkCgroup(root, path string, read func(dir string) (float64, bool)) (float64, bool) {
+	quota, found := 0.0, false
+	for dir := filepath.Join(root, path); strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if q, ok := read(dir); ok && (!found || q < quota) {
+			quota, found = q, true
+		}
+		if dir == root {
+			break
+		}
+	}
+	return quota, found
+}
+
+// readCPUMax reads a v2 quota from cpu.max, which holds "$MAX $PERIOD", or "max $PERIOD" without one.
+func readCPUMax(dir string) (float64, bool) {
+	b, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+	if err != nil {
+		return 0, false
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) != 2 {
+		return 0, false
+	}
+	return quotaRatio(fields[0], fields[1])
+}
+
+// readCFSQuota reads a v1 quota from cpu.cfs_quota_us and cpu.cfs_period_us. the quota is -1 without one.
+func readCFSQuota(dir string) (float64, bool) {
+	q, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us"))
+	if err != nil {
+		return 0, false
+	}
+	p, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0, false
+	}
+	return quotaRatio(strings.TrimSpace(string(q)), strings.TrimSpace(string(p)))
+}
+
+func quotaRatio(quota, period string) (float64, bool) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil || q <= 0 {
+		return 0, false // "max" or -1, no quota
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil || p <= 0 {
+		return 0, false
+	}
+	return float64(q) / float64(p), true
+}
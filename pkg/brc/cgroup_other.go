@@ -0,0 +1,8 @@
+//go:build !linux
+
+package brc
+
+// cgroups are linux only.
+func cgroupCPUQuota() (float64, bool) {
+	return 0, false
+}
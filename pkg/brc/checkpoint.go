@@ -0,0 +1,136 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpointMagic starts every checkpoint file, and changes whenever the format does.
+const checkpointMagic = "1brc checkpoint v1\n"
+
+// a checkpoint records how far a worker got through its chunk of the file, and the partial it had aggregated up to
+// there. each worker keeps its own file in the checkpoint directory, and only touches it between pieces of its chunk,
+// so the partial always matches the offset.
+type checkpoint struct {
+	size       int64 // of the input
+	start, end int64 // the worker's chunk
+	offset     int64 // where the worker got to
+}
+
+func checkpointPath(dir string, worker int) string {
+	return filepath.Join(dir, fmt.Sprintf("worker-%d.ckpt", worker))
+}
+
+// writeCheckpoint writes to a temporary file first, so a crash mid-write leaves the previous checkpoint intact.
+func writeCheckpoint(path string, c checkpoint, p *Partial) error {
+	pb, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	b := []byte(checkpointMagic)
+	for _, v := range []int64{c.size, c.start, c.end, c.offset} {
+		b = binary.LittleEndian.AppendUint64(b, uint64(v))
+	}
+	b = append(b, pb...)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("writing checkpoint: %w", err)
+	}
+	return nil
+}
+
+// readCheckpoint reads a checkpoint written by writeCheckpoint. ok is false if there isn't one.
+func readCheckpoint(path string) (c checkpoint, p *Partial, ok bool, err error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil, false, nil
+	} else if err != nil {
+		return c, nil, false, fmt.Errorf("reading checkpoint: %w", err)
+	}
+	if !bytes.HasPrefix(b, []byte(checkpointMagic)) || len(b) < len(checkpointMagic)+32 {
+		return c, nil, false, fmt.Errorf("%s isn't a checkpoint", path)
+	}
+	b = b[len(checkpointMagic):]
+	for _, v := range []*int64{&c.size, &c.start, &c.end, &c.offset} {
+		*v = int64(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+	}
+	p = new(Partial)
+	if err := p.UnmarshalBinary(b); err != nil {
+		return c, nil, false, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return c, p, true, nil
+}
+
+// runCheckpointed is runChunk for ProcessFile with WithCheckpoint: it picks up from the worker's checkpoint if
+// resuming, and saves a new one every so often, as well as where it stopped if the run is cancelled.
+func runCheckpointed(ctx context.Context, o *options, worker int, w Engine, data []byte, c job, p *Partial, rs *runState) error {
+	path := checkpointPath(o.checkpointDir, worker)
+	cp := checkpoint{size: int64(len(data)), start: int64(c.start), end: int64(c.end), offset: int64(c.start)}
+	if o.resume {
+		saved, sp, ok, err := readCheckpoint(path)
+		if err != nil {
+			return err
+		}
+		if ok {
+			if saved.size != cp.size || saved.start != cp.start || saved.end != cp.end {
+				return fmt.Errorf("%s is for a different input or number of workers", path)
+			}
+			cp.offset = saved.offset
+			p.m, p.rejected = sp.m, sp.rejected
+			p.rekey()
+		}
+	}
+
+	last := time.Now()
+	for pos := int(cp.offset); pos < c.end; {
+		end := c.end
+		if end-pos > cancelCheckInterval {
+			if eol := bytes.IndexByte(data[pos+cancelCheckInterval:c.end], '\n'); eol >= 0 {
+				end = pos + cancelCheckInterval + eol + 1
+			}
+		}
+		if err := runChunk(ctx, w, data[pos:end], int64(pos), p, rs); err != nil {
+			if ctx.Err() != nil {
+				// runChunk checks before it starts on a piece, so everything up to pos is in p
+				cp.offset = int64(pos)
+				if werr := writeCheckpoint(path, cp, p); werr != nil {
+					return errors.Join(err, werr)
+				}
+			}
+			return err
+		}
+		if o.maxMemory > 0 {
+			releasePages(data, pos, end) // see runBounded. the pieces here are small enough to serve as its windows
+		}
+		pos = end
+		if time.Since(last) >= o.checkpointEvery {
+			cp.offset = int64(pos)
+			if err := writeCheckpoint(path, cp, p); err != nil {
+				return err
+			}
+			last = time.Now()
+		}
+	}
+	return nil
+}
+
+// removeCheckpoints cleans up after a run that completed.
+func removeCheckpoints(dir string, workers int) error {
+	for i := range workers {
+		if err := os.Remove(checkpointPath(dir, i)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package brc
+
+import (
+	"bytes"
+	"slices"
+)
+
+// utf8BOM is the byte order mark some windows tools put at the start of utf-8 files.
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// headerLen returns the length of what comes before the data at the start of the file: a byte order mark, if there
+// is one, then any # comment lines, like the ones the generate subcommand writes.
+func headerLen(data []byte) int {
+	n := 0
+	if bytes.HasPrefix(data, utf8BOM) {
+		n = len(utf8BOM)
+	}
+	for n < len(data) && data[n] == '#' {
+		eol := bytes.IndexByte(data[n:], '\n')
+		if eol < 0 {
+			return len(data)
+		}
+		n += eol + 1
+	}
+	return n
+}
+
+// minChunkSize is the least data worth giving a worker of its own. smaller inputs are split between fewer workers, so
+// an empty or tiny file doesn't get chunks too short to hold a line.
+const minChunkSize = 64 << 10
+
+// chunkWorkers returns how many of numWorkers workers to split n bytes of data between. always at least one.
+func chunkWorkers(n int64, numWorkers int) int {
+	return int(max(1, min(int64(numWorkers), n/minChunkSize)))
+}
+
+// chunkPlan returns how many chunks to split n bytes of data into, see WithChunkSize, and how many workers to split
+// them between. without a chunk size there's one chunk per worker.
+func (o *options) chunkPlan(n int64) (chunks, workers int) {
+	workers = chunkWorkers(n, o.workers)
+	size := o.chunkSize
+	switch {
+	case size == 0:
+		return workers, workers
+	case size == ChunkSizeAuto:
+		size = autoChunkSize(n, workers)
+	}
+	size = max(size, minChunkSize)
+	chunks = int(max(1, (n+size-1)/size))
+	return chunks, min(workers, chunks)
+}
+
+// autoChunkSize picks a chunk size for n bytes of data split between workers: a sixteenth of a worker's share, so the
+// last worker to finish is at most a small chunk behind the others, but at least 16 L2 caches, which keeps the cost of
+// starting on a chunk (finding its first line, the prefetchers ramping up) in the noise, and at most 4 of a core's
+// share of the L3, past which bigger chunks don't buy anything. never more than a share, so every worker gets one.
+func autoChunkSize(n int64, workers int) int64 {
+	l2, l3 := cacheSizes()
+	if l2 == 0 {
+		l2 = 1 << 20
+	}
+	if l3 == 0 {
+		l3 = 8 << 20
+	}
+	share := n / int64(workers)
+	lo := 16 * l2
+	hi := max(lo, 4*l3)
+	return max(min(max(share/16, lo), hi, share), 1)
+}
+
+type job struct {
+	start, end int // inclusive start, exclusive end
+}
+
+// planChunks divvies up data[start:] between the workers. each worker gets a slice of the file but we need to make
+// sure we don't split in the middle of a line, so chunk ends are moved back to the nearest EOL - or, if we have an
+// index of line starts, forward to the nearest indexed offset, which doesn't touch the data at all.
+func planChunks(data []byte, start, numWorkers int, index []int64) []job {
+	fileLen := len(data)
+	chunks := make([]job, numWorkers)
+	chunkSize := (fileLen - start) / numWorkers
+	nextStart := start
+	for ci := range chunks {
+		start := nextStart
+		chunks[ci].start = start
+		// if this is the last chunk, just take the rest of the file
+		if ci == numWorkers-1 {
+			chunks[ci].end = fileLen
+			break
+		}
+		theoreticalEnd := start + chunkSize
+		if index != nil {
+			i, _ := slices.BinarySearch(index, int64(theoreticalEnd))
+			chunks[ci].end = fileLen
+			if i < len(index) {
+				chunks[ci].end = int(index[i])
+			}
+		} else {
+			// find the last EOL before the end of the chunk. the EOL belongs to this chunk. if there isn't one, the
+			// chunk is left empty and the next one starts where it would have
+			chunks[ci].end = start
+			for i := theoreticalEnd; i > start; i-- {
+				if data[i] == '\n' {
+					chunks[ci].end = i + 1
+					break
+				}
+			}
+		}
+		nextStart = chunks[ci].end
+	}
+	return chunks
+}
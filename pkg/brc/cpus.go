@@ -0,0 +1,17 @@
+package brc
+
+import (
+	"math"
+	"runtime"
+)
+
+// AvailableCPUs is runtime.NumCPU(), capped by the cpu quota of the process's cgroup if it has one (linux only).
+// inside a container with a quota, NumCPU still counts every cpu the container may run on, but it only gets the
+// quota's worth of time on them, so more workers than that just take turns. it's the default number of workers.
+func AvailableCPUs() int {
+	n := runtime.NumCPU()
+	if quota, ok := cgroupCPUQuota(); ok {
+		n = min(n, max(1, int(math.Ceil(quota))))
+	}
+	return n
+}
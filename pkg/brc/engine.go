@@ -0,0 +1,53 @@
+package brc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+)
+
+// An Engine aggregates every line of a chunk into p. chunks are made of full lines only. a new engine is created for
+// every worker, so engines don't need to be safe for concurrent use.
+type Engine interface {
+	Run(chunk []byte, p *Partial) error
+}
+
+var (
+	enginesMu sync.Mutex
+	engines   = map[string]func() Engine{
+		"default": func() Engine { return newWorker() },
+	}
+)
+
+// RegisterEngine makes an engine available to WithEngine. experiments can live in their own package calling this from
+// an init func, and a main that imports them gets the rest of the harness for free.
+func RegisterEngine(name string, newEngine func() Engine) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	if _, ok := engines[name]; ok {
+		panic(fmt.Sprintf("engine %q registered twice", name))
+	}
+	engines[name] = newEngine
+}
+
+// Engines returns the names of all registered engines.
+func Engines() []string {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	names := maps.Keys(engines)
+	slices.Sort(names)
+	return names
+}
+
+func lookupEngine(name string) (func() Engine, error) {
+	enginesMu.Lock()
+	newEngine, ok := engines[name]
+	enginesMu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown engine %q (have %s)", name, strings.Join(Engines(), ", "))
+	}
+	return newEngine, nil
+}
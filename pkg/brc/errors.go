@@ -0,0 +1,17 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+)
+
+// errors a run can fail with, for errors.Is. they're wrapped with the details, like the line that didn't parse.
+var (
+	// ErrMalformedLine means a line isn't in the format the engine expects. see WithOnError for skipping those
+	// instead. a *LineError is one too.
+	ErrMalformedLine = errors.New("malformed line")
+	// ErrBadTemperature means a line has a temperature the engine can't parse. it's an ErrMalformedLine as well.
+	ErrBadTemperature = fmt.Errorf("%w: bad temperature", ErrMalformedLine)
+	// ErrMmap means ProcessFile couldn't map the input into memory.
+	ErrMmap = errors.New("mmap")
+)
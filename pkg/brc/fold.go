@@ -0,0 +1,56 @@
+package brc
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/kamstrup/intmap"
+)
+
+// WithFoldCase merges stations whose names only differ in case, like PARIS, Paris and paris, going by the simple case
+// folding strings.EqualFold uses. the merged station goes by the spelling with the most readings. the workers still
+// keep each spelling apart, and they're folded together with the merge, so it costs per station rather than per line.
+// WithStations matches names regardless of case with it.
+func WithFoldCase(on bool) Option {
+	return func(o *options) { o.foldCase = on }
+}
+
+// foldCase returns the key stations equal to name under case folding share.
+func foldCase(name string) string {
+	return strings.Map(foldRune, name)
+}
+
+// foldRune maps r to the smallest rune it's equal to under simple case folding.
+func foldRune(r rune) rune {
+	least := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		least = min(least, f)
+	}
+	return least
+}
+
+// foldStations merges the stats in m whose stations are equal under case folding, see WithFoldCase.
+func foldStations(m *intmap.Map[uint64, *stats]) *intmap.Map[uint64, *stats] {
+	// the readings per spelling, across windows and metrics, so a station's name is the same in all of them
+	counts := map[string]map[string]float32{}
+	m.ForEach(func(_ uint64, s *stats) {
+		for ; s != nil; s = s.next {
+			key := foldCase(s.station)
+			if counts[key] == nil {
+				counts[key] = map[string]float32{}
+			}
+			counts[key][s.station] += s.count
+		}
+	})
+	names := make(map[string]string, len(counts))
+	for key, spellings := range counts {
+		var best string
+		for name, n := range spellings {
+			if best == "" || n > spellings[best] || n == spellings[best] && name < best {
+				best = name
+			}
+		}
+		names[key] = best
+	}
+	return rollUp(m, func(station string) string { return names[foldCase(station)] })
+}
@@ -0,0 +1,115 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"time"
+)
+
+// followFile aggregates whatever gets appended to path past offset into res, polling until the file hasn't grown for
+// idle. if update isn't nil, it calls it right away and then every interval that brought new data, and keeps going
+// until ctx is cancelled instead. if ctx is cancelled, it returns what it has so far along with ctx's error. the new
+// lines go through runChunk with rs, like the data that was there at the start.
+func followFile(ctx context.Context, path string, offset int64, idle, every time.Duration, update func(), w Engine, res *Partial, rs *runState) (*Partial, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("seeking to %d: %w", offset, err)
+	}
+
+	buf := make([]byte, 4<<20)
+	filled := 0
+	lastGrowth := time.Now()
+	var lastUpdate time.Time
+	grown := true // since the last update. the first one is for the data that was there at the start
+	poll := 100 * time.Millisecond
+	if update == nil {
+		poll = min(poll, idle)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if update != nil && grown && time.Since(lastUpdate) >= every {
+			update()
+			lastUpdate, grown = time.Now(), false
+		}
+		n, err := f.Read(buf[filled:])
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("reading: %w", err)
+		}
+		if n > 0 {
+			filled += n
+			lastGrowth, grown = time.Now(), true
+			// only hand complete lines to the worker, carry the rest over to the next read
+			end := bytes.LastIndexByte(buf[:filled], '\n') + 1
+			rs.progress.grow(end)
+			if err := runChunk(ctx, w, buf[:end], offset, res, rs); err != nil {
+				if ctx.Err() != nil {
+					return res, ctx.Err()
+				}
+				setLineNumber(err, func(offset int64) (int64, error) { return countLinesAt(f, offset) })
+				return nil, err
+			}
+			offset += int64(end)
+			filled = copy(buf, buf[end:filled])
+			if filled == len(buf) {
+				return nil, fmt.Errorf("line longer than %d bytes", len(buf))
+			}
+			continue
+		}
+		if update == nil && time.Since(lastGrowth) >= idle {
+			return res, nil
+		}
+		select {
+		case <-time.After(poll):
+		case <-ctx.Done():
+			return res, ctx.Err()
+		}
+	}
+}
+
+// WithFollowUpdates makes following (see WithFollow) call f with the results so far once the data that was in the
+// file at the start is done, and then every interval that brought new data, for live results. following then only
+// ends when the context is cancelled, whatever WithFollow's idle time. f runs on the goroutine doing the following, so
+// it holds that up while it runs. it doesn't go with WithAggregator, since updates need copies of the aggregates.
+func WithFollowUpdates(every time.Duration, f func(*Results)) Option {
+	return func(o *options) { o.followEvery, o.followUpdate = every, f }
+}
+
+// snapshots returns copies of partials that merging them can't change the originals through, so following can go on
+// adding to the originals after an update.
+func snapshots(partials []*Partial) []*Partial {
+	out := make([]*Partial, len(partials))
+	for i, p := range partials {
+		c := *p
+		c.m = &table{}
+		*c.m = *p.m
+		c.m.slots = slices.Clone(p.m.slots)
+		for j := range c.m.slots {
+			if !c.m.slots[j].used {
+				continue
+			}
+			for s := &c.m.slots[j].s; s != nil; s = s.next {
+				s.digest = s.digest.clone()
+				if s.hist != nil {
+					h := *s.hist
+					s.hist = &h
+				}
+				if s.next != nil {
+					n := *s.next
+					s.next = &n
+				}
+			}
+		}
+		out[i] = &c
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package brc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// followAppending runs ProcessFile with WithFollow over a file holding initial, appending appended to it once the run
+// has started.
+func followAppending(t *testing.T, initial, appended string, opts ...Option) (*Results, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "measurements.txt")
+	if err := os.WriteFile(path, []byte(initial), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer f.Close()
+		if _, err := f.WriteString(appended); err != nil {
+			t.Error(err)
+		}
+	}()
+	return ProcessFile(path, append(opts, WithFollow(500*time.Millisecond))...)
+}
+
+// appended lines are checked like the ones that were there at the start.
+func TestFollowRejects(t *testing.T) {
+	const initial, appended = "A;1.0\nB;2.0\n", "A;3.0\nbad\nB;4.0\n"
+	res, err := followAppending(t, initial, appended, WithOnError("skip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rejected != 1 || res.Rows() != 4 {
+		t.Errorf("got %d rows and %d rejected, want 4 and 1", res.Rows(), res.Rejected)
+	}
+
+	_, err = followAppending(t, initial, appended, WithOnError("fail"))
+	var le *LineError
+	if !errors.As(err, &le) {
+		t.Fatalf("got %v, want a LineError", err)
+	}
+	if want := int64(len(initial) + len("A;3.0\n")); le.Offset != want || le.Line != 4 {
+		t.Errorf("got line %d at offset %d, want line 4 at offset %d", le.Line, le.Offset, want)
+	}
+}
+
+// appended lines are sampled like the rest, so following a file gets the sample a run over all of it would.
+func TestFollowSample(t *testing.T) {
+	initial, appended := linesOfLength(20000), "\n"+linesOfLength(20000)+"\n"
+	res, err := followAppending(t, initial, appended, WithSample(0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := Process(strings.NewReader(initial+appended), WithSample(0.3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rows() != want.Rows() {
+		t.Errorf("got %d rows, want the %d of the sample", res.Rows(), want.Rows())
+	}
+}
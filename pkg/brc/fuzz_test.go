@@ -0,0 +1,271 @@
+package brc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+// differential fuzzing of the byte level parts of the hot loop: each check takes arbitrary bytes, runs one of them on
+// it, and compares the result against a slow, obviously correct version built on strconv and the bytes package, so a
+// clever rewrite can't quietly change what we accept or what we compute. the corpus is in testdata/fuzz, run more with
+//
+//	go test -fuzz FuzzSplitLine ./pkg/brc
+
+var (
+	// officialTempRe is the official temperature format, which parseFloat accepts
+	officialTempRe = regexp.MustCompile(`^-?[0-9]{1,2}\.[0-9]$`)
+	// decimalRe is what parseDecimal accepts, for WithRelaxed
+	decimalRe = regexp.MustCompile(`^[+-]?([0-9]+\.?[0-9]*|\.[0-9]+)$`)
+)
+
+// fuzzSeeds are inputs worth starting a fuzz corpus with: official lines and temperatures, the edge cases around
+// them, and things that only look like them.
+var fuzzSeeds = []string{
+	"Hamburg;12.0\n", "Bulawayo;8.9\n", "St. John's;15.2\n", "X;-0.0\n", "Abcdefg;99.9\n", "Abcdefgh;-99.9\n",
+	"Ürümqi;7.4\r\n", ";1.0\n", "a;b;-3.5\n", "Cracow;+3\n", "Cracow;1013.25\n", "Cracow;.5\n", "Cracow;5.\n",
+	"Cracow;12.34\n", "Cracow;--1.0\n", "Cracow;1.0", "Cracow;1.0\r\r\n", "Cracow\n", "\n", "12.3", "-1.0", "100.0",
+	"1.x", "-.5",
+}
+
+// refTemp parses a temperature the slow way, accepting what parseFloat does.
+func refTemp(bs []byte) (float32, bool) {
+	if !officialTempRe.Match(bs) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(string(bs), 32)
+	return float32(v), err == nil
+}
+
+// refDecimal parses a value the slow way, accepting what parseDecimal does.
+func refDecimal(bs []byte) (float32, bool) {
+	if !decimalRe.Match(bs) {
+		return 0, false
+	}
+	v, err := strconv.ParseFloat(string(bs), 64)
+	if err != nil && !errors.Is(err, strconv.ErrRange) {
+		return 0, false
+	}
+	return float32(v), true
+}
+
+// closeEnough compares parsed values. official temperatures have to come out exactly, sign of zero included, but
+// parseDecimal accumulates digits in a float64, so very long inputs can round differently than strconv.
+func closeEnough(got, want float32, exact bool) bool {
+	if exact {
+		return math.Float32bits(got) == math.Float32bits(want)
+	}
+	if got == want {
+		return true
+	}
+	return math.Abs(float64(got)-float64(want)) <= 1e-6*math.Abs(float64(want))
+}
+
+// firstLine is data up to its first newline. whatever comes after stands in for the rest of the buffer a line is a
+// slice of, which the SWAR paths load along with it.
+func firstLine(data []byte) []byte {
+	if i := bytes.IndexByte(data, '\n'); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
+
+// checkParseFloat checks parseFloat and parseTemp against strconv.ParseFloat, and parseDecimal against it too.
+func checkParseFloat(data []byte) error {
+	field := firstLine(data)
+	want, wantOK := refTemp(field)
+	got, ok := parseFloat(bytes.Clone(field))
+	if ok != wantOK || ok && !closeEnough(got, want, true) {
+		return fmt.Errorf("parseFloat(%q) = %v, %v, strconv says %v, %v", field, got, ok, want, wantOK)
+	}
+	// parseTemp takes the SWAR path when the capacity allows, so try it with the bytes that follow and without
+	for _, bs := range [][]byte{field, bytes.Clone(field)} {
+		got, ok := parseTemp(bs)
+		if ok != wantOK || ok && !closeEnough(got, want, true) {
+			return fmt.Errorf("parseTemp(%q) with capacity %d = %v, %v, strconv says %v, %v", bs, cap(bs), got, ok, want, wantOK)
+		}
+	}
+	want, wantOK = refDecimal(field)
+	got, ok = parseDecimal(field)
+	if ok != wantOK || ok && !closeEnough(got, want, officialTempRe.Match(field)) {
+		return fmt.Errorf("parseDecimal(%q) = %v, %v, strconv says %v, %v", field, got, ok, want, wantOK)
+	}
+	return nil
+}
+
+// checkSplitLine checks how the default engine takes a line apart: the guess in splitOnSemi, the station hash, and the
+// whole of parseLineBytes and parseLine against splitting at the last semicolon with bytes.LastIndexByte and parsing
+// the rest with strconv.
+func checkSplitLine(data []byte) error {
+	line := firstLine(data)
+	var w worker
+
+	if station, temp, ok := w.splitOnSemi(line); ok {
+		if len(station)+1+len(temp) != len(line) || line[len(station)] != ';' {
+			return fmt.Errorf("splitOnSemi(%q) = %q, %q, which isn't the line split at a semicolon", line, station, temp)
+		}
+		if _, ok := refTemp(temp); ok && len(station) != bytes.LastIndexByte(line, ';') {
+			return fmt.Errorf("splitOnSemi(%q) split at %d, not the last semicolon", line, len(station))
+		}
+	}
+
+	trimmed := trimCR(line)
+	semi := bytes.LastIndexByte(trimmed, ';')
+	var want float32
+	wantOK := semi >= 0
+	if wantOK {
+		want, wantOK = refTemp(trimmed[semi+1:])
+	}
+	stationBs, h, temp, err := w.parseLineBytes(line)
+	if err := checkParsed("parseLineBytes", line, stationBs, temp, err, trimmed[:max(semi, 0)], want, wantOK); err != nil {
+		return err
+	}
+	if err == nil {
+		// the hash mustn't depend on what follows the name in the buffer
+		if want := stationHash(bytes.Clone(stationBs)); h != want {
+			return fmt.Errorf("stationHash(%q) = %#x in place, %#x on its own", stationBs, h, want)
+		}
+	}
+	stationBs, temp, err = w.parseLine(line)
+	return checkParsed("parseLine", line, stationBs, temp, err, trimmed[:max(semi, 0)], want, wantOK)
+}
+
+// checkParsed compares what a line parser named fn got for line with the reference parse.
+func checkParsed(fn string, line, station []byte, temp float32, err error, wantStation []byte, want float32, wantOK bool) error {
+	if (err == nil) != wantOK {
+		return fmt.Errorf("%s(%q) returned error %v, but the reference parse says ok=%v", fn, line, err, wantOK)
+	}
+	if err != nil {
+		if !errors.Is(err, ErrMalformedLine) {
+			return fmt.Errorf("%s(%q) returned %v, which isn't an ErrMalformedLine", fn, line, err)
+		}
+		return nil
+	}
+	if !bytes.Equal(station, wantStation) {
+		return fmt.Errorf("%s(%q) station = %q, want %q", fn, line, station, wantStation)
+	}
+	if !closeEnough(temp, want, true) {
+		return fmt.Errorf("%s(%q) temperature = %v, want %v", fn, line, temp, want)
+	}
+	return nil
+}
+
+// checkChunk runs e over chunk and compares the per-station min, max and count against a reference that finds the
+// lines with bytes.IndexByte and parses their temperatures with parse. chunks are made of full lines, so anything after
+// the last newline is dropped. the engine may reject the chunk only if the reference finds a malformed line in it.
+func checkChunk(name string, e Engine, parse func([]byte) (float32, bool), chunk []byte) error {
+	chunk = chunk[:bytes.LastIndexByte(chunk, '\n')+1]
+
+	type refStats struct {
+		min, max float32
+		count    int64
+	}
+	want := map[string]*refStats{}
+	var malformed []byte
+	for rest := chunk; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		line := trimCR(rest[:i])
+		rest = rest[i+1:]
+		semi := bytes.LastIndexByte(line, ';')
+		var temp float32
+		ok := semi >= 0
+		if ok {
+			temp, ok = parse(line[semi+1:])
+		}
+		if !ok {
+			malformed = line
+			break
+		}
+		s, seen := want[string(line[:semi])]
+		if !seen {
+			s = &refStats{min: temp, max: temp}
+			want[string(line[:semi])] = s
+		}
+		s.min, s.max = min(s.min, temp), max(s.max, temp)
+		s.count++
+	}
+
+	o := newOptions(nil)
+	p := newPartial(o)
+	if err := e.Run(chunk, p); err != nil {
+		if malformed == nil {
+			return fmt.Errorf("%s engine failed on a chunk without malformed lines: %w", name, err)
+		}
+		return nil
+	}
+	if malformed != nil {
+		return fmt.Errorf("%s engine accepted malformed line %q", name, malformed)
+	}
+
+	res := newResults([]*Partial{p}, nil, o)
+	if len(res.Stations) != len(want) {
+		return fmt.Errorf("%s engine found %d stations, want %d", name, len(res.Stations), len(want))
+	}
+	for _, s := range res.Stations {
+		w, ok := want[s.Name]
+		if !ok {
+			return fmt.Errorf("%s engine found station %q, which isn't in the chunk", name, s.Name)
+		}
+		if s.Count != w.count || !closeEnough(float32(s.Min), w.min, false) || !closeEnough(float32(s.Max), w.max, false) {
+			return fmt.Errorf("%s engine: %q min/max/count = %v/%v/%d, want %v/%v/%d", name, s.Name, s.Min, s.Max, s.Count, w.min, w.max, w.count)
+		}
+	}
+	return nil
+}
+
+func addSeeds(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+}
+
+func FuzzParseFloat(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkParseFloat(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func FuzzSplitLine(f *testing.F) {
+	addSeeds(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if err := checkSplitLine(data); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+// the default engine (byte loop, and batched like on arm64) and WithRelaxed's, each against the temperatures it takes.
+func FuzzChunk(f *testing.F) {
+	addSeeds(f)
+	f.Add([]byte("Hamburg;12.0\nBulawayo;8.9\nHamburg;-3.4\r\nSt. John's;15.2\n"))
+	f.Add([]byte("a;1.0\nb;2.0\na;-99.9\nb;99.9\nc;0.0\nlast line without a newline;1.0"))
+	f.Fuzz(func(t *testing.T, chunk []byte) {
+		for _, e := range []struct {
+			name  string
+			e     Engine
+			parse func([]byte) (float32, bool)
+		}{
+			{"default", newWorker(), refTemp},
+			{"batched", engineFunc(newWorker().runBatched), refTemp},
+			{"relaxed", relaxedEngine{}, refDecimal},
+		} {
+			if err := checkChunk(e.name, e.e, e.parse, chunk); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// engineFunc makes a Run method an Engine.
+type engineFunc func(chunk []byte, p *Partial) error
+
+func (f engineFunc) Run(chunk []byte, p *Partial) error {
+	return f(chunk, p)
+}
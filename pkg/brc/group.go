@@ -0,0 +1,112 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// group is a minimal errgroup: it runs goroutines, keeps the first error any of them returns and cancels the shared
+// context when that happens, so the others can bail out early.
+type group struct {
+	wg     sync.WaitGroup
+	cancel context.CancelFunc
+	once   sync.Once
+	err    error
+}
+
+func newGroup(ctx context.Context) (*group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &group{cancel: cancel}, ctx
+}
+
+func (g *group) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if err := f(); err != nil {
+			g.once.Do(func() {
+				g.err = err
+				g.cancel()
+			})
+		}
+	}()
+}
+
+// Wait waits for all goroutines and returns the first error.
+func (g *group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// cancelCheckInterval is roughly how many bytes of a chunk an engine gets to process between checks for cancellation.
+const cancelCheckInterval = 8 << 20
+
+// runState is what the workers of a run share.
+type runState struct {
+	progress *Progress
+	budget   *rowBudget
+	sample   float64                // 0 means no sampling
+	validate func(line []byte) bool // nil means lines aren't checked, see WithOnError
+	fail     bool                   // stop at the first line validate rejects
+	log      *slog.Logger           // for rejected lines, if they're logged
+}
+
+// runChunk hands chunk, which starts at offset in the input, to w in line-aligned pieces, checking ctx in between, so
+// a failure in another worker stops this one within a few milliseconds without every engine having to know about
+// contexts. it drops malformed lines, applies sampling and stops early once the row budget runs out.
+func runChunk(ctx context.Context, w Engine, chunk []byte, offset int64, p *Partial, rs *runState) error {
+	defer chunkRegion(ctx, offset, len(chunk)).End()
+	var valid, sampled []byte
+	var lineErr *LineError
+	reject := func(line []byte, offset int64) {
+		if rs.fail {
+			if lineErr == nil {
+				lineErr = newLineError(line, offset)
+			}
+			return
+		}
+		p.rejected++
+		if rs.log != nil {
+			rs.log.Warn("skipping malformed line", "offset", offset, "line", string(bytes.TrimRight(line, "\r\n")))
+		}
+	}
+	for len(chunk) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		end := len(chunk)
+		if end > cancelCheckInterval {
+			// move the piece end forward to the next line start. if there's no EOL left, take the rest of the chunk
+			if eol := bytes.IndexByte(chunk[cancelCheckInterval:], '\n'); eol >= 0 {
+				end = cancelCheckInterval + eol + 1
+			}
+		}
+		piece := chunk[:end]
+		if rs.validate != nil {
+			valid = rejectLines(valid[:0], piece, offset, rs.validate, reject)
+			if lineErr != nil {
+				return lineErr
+			}
+			piece = valid
+		}
+		if rs.sample > 0 {
+			sampled = sampleLines(sampled[:0], piece, offset, rs.sample)
+			piece = sampled
+		}
+		if rs.budget.exhausted() {
+			return nil
+		}
+		if piece = rs.budget.take(piece); len(piece) > 0 {
+			if err := w.Run(piece, p); err != nil {
+				return err
+			}
+		}
+		rs.progress.addBytes(end)
+		chunk = chunk[end:]
+		offset += int64(end)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package brc
+
+import (
+	"fmt"
+	"hash/maphash"
+	"slices"
+	"strings"
+
+	"golang.org/x/exp/maps"
+)
+
+// WithHash picks the hash the workers key stations by: xxhash (the default), fnv, bytesum or maphash. xxhash packs
+// names of up to 7 bytes into their keys instead of hashing them, and its collisions are rare enough to ignore. the
+// others hash every name, and since the weaker ones collide all the time, lookups check the name too, which makes
+// them slower but still correct. it's there to measure the speed/collision trade-off on a dataset, and doesn't go
+// with WithPerfectHash.
+func WithHash(name string) Option {
+	return func(o *options) { o.hash = name }
+}
+
+// hashes are the hashes WithHash accepts. xxhash is nil, since it means stationHash, see Partial.key.
+var hashes = map[string]func(name []byte) uint64{
+	"xxhash":  nil,
+	"fnv":     fnv1a,
+	"bytesum": byteSum,
+	"maphash": func(name []byte) uint64 { return maphash.Bytes(maphashSeed, name) },
+}
+
+// maphashSeed is random per process, which is fine since keys never leave one: serialized partials and merges use
+// stationHash keys.
+var maphashSeed = maphash.MakeSeed()
+
+// Hashes returns the names of the hashes WithHash accepts.
+func Hashes() []string {
+	names := maps.Keys(hashes)
+	slices.Sort(names)
+	return names
+}
+
+func lookupHash(name string) (func(name []byte) uint64, error) {
+	hash, ok := hashes[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash %q (have %s)", name, strings.Join(Hashes(), ", "))
+	}
+	return hash, nil
+}
+
+// fnv1a is 64 bit FNV-1a, written out rather than using hash/fnv, which would mean an allocation and an interface
+// call per line.
+func fnv1a(name []byte) uint64 {
+	h := uint64(14695981039346656037)
+	for _, c := range name {
+		h ^= uint64(c)
+		h *= 1099511628211
+	}
+	return h
+}
+
+// byteSum adds up the bytes of the name, as an early version of the aggregator did. it's as cheap as a hash gets, and
+// anagrams, for one, collide.
+func byteSum(name []byte) uint64 {
+	var h uint64
+	for _, c := range name {
+		h += uint64(c)
+	}
+	return h
+}
+
+// statsKey is the key for a station's stats in the time window starting at window and for the metric-th metric,
+// given h, the key for its name. window and metric are 0 if unused.
+func statsKey(h uint64, window int64, metric uint8) uint64 {
+	if window != 0 {
+		h ^= mix64(uint64(window))
+	}
+	if metric != 0 {
+		h ^= mix64(^uint64(metric))
+	}
+	return h
+}
+
+// key returns the key for station's name in p's table, see WithHash.
+func (p *Partial) key(station []byte) uint64 {
+	if p.hash == nil {
+		return stationHash(station)
+	}
+	return p.hash(station)
+}
+
+// canonicalKey returns the key s would have with the default hash, which is what serialized partials and merges
+// use, so they don't depend on the hash a worker used.
+func canonicalKey(s *stats) uint64 {
+	return statsKey(stationHash([]byte(s.station)), s.window, s.metric)
+}
+
+// rekey moves the stats in p's table from their canonical keys, like those of an unserialized partial, to the keys of
+// p's hash.
+func (p *Partial) rekey() {
+	if p.hash == nil {
+		return
+	}
+	old := p.m
+	p.m = newTable(old.len)
+	old.forEach(func(_ uint64, s *stats) {
+		c := *s
+		c.next = nil
+		h := statsKey(p.hash([]byte(c.station)), c.window, c.metric)
+		if t, ok := p.m.get(h); ok {
+			last, _ := t.lookup([]byte(c.station), c.window, c.metric)
+			last.next = &c
+		} else {
+			p.m.put(h, c)
+		}
+	})
+}
+
+// is reports whether s are the stats of station in window and for metric.
+func (s *stats) is(station []byte, window int64, metric uint8) bool {
+	return s.window == window && s.metric == metric && s.name == newNameKey(station) &&
+		(len(station) <= 16 || s.station == string(station))
+}
+
+// lookup finds the stats of station in window and for metric among s and the stats chained to it, which share a key
+// in a table of a Partial with a hash other than the default. if they aren't there, it returns the last in the chain
+// and false.
+func (s *stats) lookup(station []byte, window int64, metric uint8) (*stats, bool) {
+	for !s.is(station, window, metric) {
+		if s.next == nil {
+			return s, false
+		}
+		s = s.next
+	}
+	return s, true
+}
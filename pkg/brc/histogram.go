@@ -0,0 +1,23 @@
+package brc
+
+import "math"
+
+// the histograms from WithHistograms have 1°C bins covering the valid temperature range, -99.9 to 99.9. bin i counts
+// readings in [HistogramStart+i, HistogramStart+i+1).
+const (
+	HistogramStart = -100
+	HistogramBins  = 200
+)
+
+type histogram [HistogramBins]int64
+
+func (h *histogram) add(temp float32) {
+	bin := int(math.Floor(float64(temp))) - HistogramStart
+	h[min(max(bin, 0), HistogramBins-1)]++
+}
+
+func (h *histogram) merge(o *histogram) {
+	for i, n := range o {
+		h[i] += n
+	}
+}
@@ -0,0 +1,110 @@
+package brc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// indexStride is roughly how far apart indexed offsets are.
+const indexStride = 16 << 20
+
+const (
+	indexHeader     = "# 1brc index v2 "
+	indexHeaderBase = "# 1brc index "
+)
+
+// the index sidecar is a small text file: a header recording the size and modification time of the data file it was
+// built for, then one line-start offset per line, in increasing order.
+func indexPath(dataPath string) string {
+	return dataPath + ".idx"
+}
+
+// buildIndex returns the offsets of line starts roughly every indexStride bytes, beginning with start.
+func buildIndex(data []byte, start int) []int64 {
+	offsets := []int64{int64(start)}
+	prev := start
+	for target := start + indexStride; target < len(data); target += indexStride {
+		eol := bytes.LastIndexByte(data[prev:target], '\n')
+		if eol < 0 {
+			continue // a 16MB line, or no line ending yet. try the next stride
+		}
+		prev += eol + 1
+		offsets = append(offsets, int64(prev))
+	}
+	return offsets
+}
+
+func writeIndexFile(path string, size int64, mtime time.Time, offsets []int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", path, err)
+	}
+	defer f.Close()
+
+	bw := bufio.NewWriter(f)
+	fmt.Fprintf(bw, "%ssize=%d mtime=%d\n", indexHeader, size, mtime.UnixNano())
+	for _, off := range offsets {
+		fmt.Fprintf(bw, "%d\n", off)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", path, err)
+	}
+	return f.Close()
+}
+
+// readIndexFile reads an index written by writeIndexFile. ok is false if there's no index, it was built for a
+// different version of the data file, or by an older version of us.
+func readIndexFile(path string, size int64, mtime time.Time) (offsets []int64, ok bool, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, false, fmt.Errorf("%s is empty", path)
+	}
+	header, found := strings.CutPrefix(scanner.Text(), indexHeader)
+	if !found {
+		if strings.HasPrefix(scanner.Text(), indexHeaderBase) {
+			return nil, false, nil
+		}
+		return nil, false, fmt.Errorf("%s has no index header", path)
+	}
+	if header != fmt.Sprintf("size=%d mtime=%d", size, mtime.UnixNano()) {
+		return nil, false, nil
+	}
+	for scanner.Scan() {
+		off, err := strconv.ParseInt(scanner.Text(), 10, 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("parsing %s: %w", path, err)
+		}
+		offsets = append(offsets, off)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("reading %s: %w", path, err)
+	}
+	return offsets, true, nil
+}
+
+// indexMatches reports whether every offset in index starts a line of data, for an index that matches its file's size
+// and mtime but may still be stale: the file can be rewritten within the mtime's granularity, or have its mtime set
+// back. with the offsets off, chunks would start mid line and the run would fail or, skipping rejects, be wrong.
+func indexMatches(data []byte, dataStart int, index []int64) bool {
+	prev := int64(-1)
+	for _, off := range index {
+		if off <= prev || off < int64(dataStart) || off > int64(len(data)) || off > int64(dataStart) && data[off-1] != '\n' {
+			return false
+		}
+		prev = off
+	}
+	return true
+}
@@ -0,0 +1,40 @@
+package brc
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestIndexStale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "m.txt.idx")
+	mtime := time.Unix(1700000000, 123)
+	if err := writeIndexFile(path, 100, mtime, []int64{0, 50}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := readIndexFile(path, 100, mtime); !ok || err != nil {
+		t.Fatalf("got %v, %v for the file the index was written for", ok, err)
+	}
+	if _, ok, err := readIndexFile(path, 100, mtime.Add(time.Nanosecond)); ok || err != nil {
+		t.Errorf("got %v, %v for a file modified since, want it ignored", ok, err)
+	}
+
+	// the same size and mtime, with the lines moved
+	data := []byte("# header\nA;1.0\nB;2.0\nCC;3.0\n")
+	for _, tc := range []struct {
+		index []int64
+		ok    bool
+	}{
+		{[]int64{9, 15, 21}, true},
+		{[]int64{9, 21, int64(len(data))}, true},
+		{[]int64{9, 16}, false},
+		{[]int64{9, 0}, false},
+		{[]int64{0, 9}, false}, // before the header ends
+		{[]int64{9, 15, 15}, false},
+		{[]int64{9, int64(len(data)) + 1}, false},
+	} {
+		if ok := indexMatches(data, 9, tc.index); ok != tc.ok {
+			t.Errorf("indexMatches(%v) = %v, want %v", tc.index, ok, tc.ok)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package brc
+
+import (
+	"context"
+	"runtime/pprof"
+	"strconv"
+)
+
+// the stages of a run, which label the goroutines doing them in CPU profiles (slice with `go tool pprof -tagfocus
+// stage=parse` or `-tags`). map is setting up the mapping (prefaulting, copying into huge pages), scan is finding
+// chunk boundaries and reading blocks ahead of the workers, parse is a worker's loop over its lines, which also
+// updates the map of stations since the two happen line by line, and merge is combining the workers' partials.
+const (
+	stageMap   = "map"
+	stageScan  = "scan"
+	stageParse = "parse"
+	stageMerge = "merge"
+)
+
+// enterStage labels the calling goroutine with stage, and worker unless it's negative, on top of the labels in ctx,
+// and returns ctx with them added, for passing on to goroutines it starts. goroutines started after it inherit the
+// labels too. put the caller's labels back with pprof.SetGoroutineLabels(ctx).
+func enterStage(ctx context.Context, stage string, worker int) context.Context {
+	labels := pprof.Labels("stage", stage)
+	if worker >= 0 {
+		labels = pprof.Labels("stage", stage, "worker", strconv.Itoa(worker))
+	}
+	ctx = pprof.WithLabels(ctx, labels)
+	pprof.SetGoroutineLabels(ctx)
+	return ctx
+}
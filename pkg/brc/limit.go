@@ -0,0 +1,50 @@
+package brc
+
+import (
+	"bytes"
+	"sync/atomic"
+)
+
+// rowBudget is the number of rows left to process under WithLimit, shared by all workers. each one takes what it
+// needs for the piece it's about to process, so the total comes out exact, but which rows get processed depends on
+// how fast each worker goes: it's roughly the first rows of every chunk rather than of the file.
+type rowBudget struct {
+	left atomic.Int64
+}
+
+func newRowBudget(limit int64) *rowBudget {
+	if limit <= 0 {
+		return nil
+	}
+	b := &rowBudget{}
+	b.left.Store(limit)
+	return b
+}
+
+// take returns the prefix of lines the budget allows, which is all of them for a nil budget.
+func (b *rowBudget) take(lines []byte) []byte {
+	if b == nil {
+		return lines
+	}
+	if b.left.Load() <= 0 {
+		return nil
+	}
+	n := int64(bytes.Count(lines, []byte{'\n'}))
+	left := b.left.Add(-n)
+	if left >= 0 {
+		return lines
+	}
+	allowed := n + left // we only got part of what we asked for
+	if allowed <= 0 {
+		return nil
+	}
+	end := 0
+	for range allowed {
+		end += bytes.IndexByte(lines[end:], '\n') + 1
+	}
+	return lines[:end]
+}
+
+func (b *rowBudget) exhausted() bool {
+	return b != nil && b.left.Load() <= 0
+}
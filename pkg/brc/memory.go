@@ -0,0 +1,35 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"os"
+)
+
+// minMemoryWindow is the smallest window a worker goes through before releasing it, however low the memory cap.
+const minMemoryWindow = 1 << 20
+
+// memoryWindow returns how many bytes of the mapping each of workers gets to have resident at a time under a memory
+// cap of limit bytes. it's half its share of the cap, leaving the rest for the heap.
+func memoryWindow(limit int64, workers int) int {
+	return max(int(limit/int64(2*workers)), minMemoryWindow) &^ (os.Getpagesize() - 1)
+}
+
+// runBounded is runChunk over data[c.start:c.end], a window at a time, dropping each window's pages from the mapping
+// once it's done with them so the chunk never has more than window bytes resident.
+func runBounded(ctx context.Context, w Engine, data []byte, c job, window int, p *Partial, rs *runState) error {
+	for pos := c.start; pos < c.end; {
+		end := c.end
+		if end-pos > window {
+			if eol := bytes.IndexByte(data[pos+window:c.end], '\n'); eol >= 0 {
+				end = pos + window + eol + 1
+			}
+		}
+		if err := runChunk(ctx, w, data[pos:end], int64(pos), p, rs); err != nil {
+			return err
+		}
+		releasePages(data, pos, end)
+		pos = end
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+)
+
+// metricsEngine aggregates the multi-metric format, station;value;value;..., with the value columns named by
+// WithColumns. each selected column is aggregated separately, see WithMetrics.
+type metricsEngine struct {
+	// cols[i] is the value column of the i-th selected metric
+	cols   []int
+	values [][]byte // scratch, one per column
+}
+
+func (e *metricsEngine) Run(chunk []byte, p *Partial) error {
+	for len(chunk) > 0 {
+		n := bytes.IndexByte(chunk, '\n')
+		if n < 0 {
+			n = len(chunk)
+		}
+		line := trimCR(chunk[:n])
+		chunk = chunk[min(n+1, len(chunk)):]
+
+		semi := bytes.IndexByte(line, ';')
+		if semi < 0 {
+			return fmt.Errorf("parsing line %q: %w: no semicolon", line, ErrMalformedLine)
+		}
+		station, rest := line[:semi], line[semi+1:]
+		e.values = e.values[:0]
+		for len(e.values) < cap(e.values) {
+			v, tail, _ := bytes.Cut(rest, []byte{';'})
+			e.values = append(e.values, v)
+			rest = tail
+		}
+		for m, col := range e.cols {
+			v, ok := parseDecimal(e.values[col])
+			if !ok {
+				return fmt.Errorf("parsing line %q: %w: bad value in column %d", line, ErrMalformedLine, col+1)
+			}
+			p.observeMetric(station, uint8(m), v)
+		}
+	}
+	return nil
+}
+
+// parseDecimal parses a plain decimal number like -12.5, +3 or 1013. unlike parseFloat it doesn't assume the
+// temperature format.
+func parseDecimal(bs []byte) (float32, bool) {
+	neg := len(bs) > 0 && bs[0] == '-'
+	if len(bs) > 0 && (bs[0] == '-' || bs[0] == '+') {
+		bs = bs[1:]
+	}
+	var v, scale float64 = 0, 1
+	seenDot, seenDigit := false, false
+	for _, c := range bs {
+		switch {
+		case c == '.' && !seenDot:
+			seenDot = true
+		case c >= '0' && c <= '9':
+			v = v*10 + float64(c-'0')
+			seenDigit = true
+			if seenDot {
+				scale *= 10
+			}
+		default:
+			return 0, false
+		}
+	}
+	if !seenDigit {
+		return 0, false
+	}
+	v /= scale
+	if neg {
+		v = -v
+	}
+	return float32(v), true
+}
+
+// metricColumns resolves the selected metrics to value column indexes.
+func metricColumns(columns, metrics []string) ([]int, error) {
+	cols := make([]int, len(metrics))
+	for i, m := range metrics {
+		if cols[i] = slices.Index(columns, m); cols[i] < 0 {
+			return nil, fmt.Errorf("unknown metric %q (have %v)", m, columns)
+		}
+	}
+	return cols, nil
+}
@@ -0,0 +1,35 @@
+//go:build unix && !purego
+
+package brc
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func setupMmap(path string, prefault bool) ([]byte, func(), error) {
+	// custom mmap since exp/mmap's ReaderAt does copies
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("opening file: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("statting file: %w", err)
+	}
+
+	size := fi.Size()
+	if size == 0 {
+		// mmap refuses empty mappings, and there's nothing to map anyway
+		return nil, func() {}, nil
+	}
+
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED|populateFlag(prefault))
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("%w: %w", ErrMmap, err)
+	}
+
+	return data, func() { _ = syscall.Munmap(data) }, nil
+}
@@ -0,0 +1,124 @@
+//go:build linux && !purego
+
+package brc
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// populateFlag returns MAP_POPULATE if prefault is set, which has mmap fault in every page of the file up front.
+func populateFlag(prefault bool) int {
+	if prefault {
+		return syscall.MAP_POPULATE
+	}
+	return 0
+}
+
+// adviseChunk tells the kernel we're about to read data[start:end] front to back. madvise wants a page-aligned
+// address so the range is widened down to the enclosing page.
+func adviseChunk(data []byte, start, end int) error {
+	if start >= end {
+		return nil
+	}
+	start &^= os.Getpagesize() - 1
+	if err := syscall.Madvise(data[start:end], syscall.MADV_SEQUENTIAL); err != nil {
+		return fmt.Errorf("MADV_SEQUENTIAL: %w", err)
+	}
+	if err := syscall.Madvise(data[start:end], syscall.MADV_WILLNEED); err != nil {
+		return fmt.Errorf("MADV_WILLNEED: %w", err)
+	}
+	return nil
+}
+
+// releasePages drops the pages that lie entirely within data[start:end] from the mapping, so they stop counting
+// towards our resident memory. it's a file mapping, so they're read back in if they're touched again.
+func releasePages(data []byte, start, end int) {
+	page := os.Getpagesize()
+	start = (start + page - 1) &^ (page - 1)
+	end &^= page - 1
+	if start < end {
+		_ = syscall.Madvise(data[start:end], syscall.MADV_DONTNEED)
+	}
+}
+
+// setupHugePages asks for the mapping to be backed by transparent huge pages. "advise" just madvises the file mapping,
+// which only helps if the kernel supports THP for the page cache (CONFIG_READ_ONLY_THP_FOR_FS). "copy" copies the file
+// into an anonymous THP-backed mapping, which always works but costs a full pass over the data up front.
+func setupHugePages(data []byte, mode string) ([]byte, func(), error) {
+	if len(data) == 0 {
+		return data, func() {}, nil
+	}
+	switch mode {
+	case "off":
+		return data, func() {}, nil
+	case "advise":
+		if err := syscall.Madvise(data, syscall.MADV_HUGEPAGE); err != nil {
+			return nil, func() {}, fmt.Errorf("MADV_HUGEPAGE: %w", err)
+		}
+		return data, func() {}, nil
+	case "copy":
+		anon, err := syscall.Mmap(-1, 0, len(data), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
+		if err != nil {
+			return nil, func() {}, fmt.Errorf("%w anonymous: %w", ErrMmap, err)
+		}
+		if err := syscall.Madvise(anon, syscall.MADV_HUGEPAGE); err != nil {
+			_ = syscall.Munmap(anon)
+			return nil, func() {}, fmt.Errorf("MADV_HUGEPAGE: %w", err)
+		}
+		copy(anon, data)
+		return anon, func() { _ = syscall.Munmap(anon) }, nil
+	default:
+		return nil, func() {}, fmt.Errorf("unknown huge page mode %q", mode)
+	}
+}
+
+// hugePageBytes reports how much of the mapping starting at data[0] is actually backed by huge pages, according to
+// /proc/self/smaps.
+func hugePageBytes(data []byte) (int64, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	f, err := os.Open("/proc/self/smaps")
+	if err != nil {
+		return 0, fmt.Errorf("opening smaps: %w", err)
+	}
+	defer f.Close()
+
+	start := strconv.FormatUint(uint64(uintptr(unsafe.Pointer(&data[0]))), 16) + "-"
+	inMapping := false
+	var total int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		// mapping header lines look like "7f0000000000-7f0000400000 r--s 00000000 fd:01 1234 /path"
+		if strings.Contains(fields[0], "-") && !strings.HasSuffix(fields[0], ":") {
+			inMapping = strings.HasPrefix(fields[0], start)
+			continue
+		}
+		if !inMapping || len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "AnonHugePages:", "FilePmdMapped:", "ShmemPmdMapped:":
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("parsing %s: %w", line, err)
+			}
+			total += kb * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("scanning smaps: %w", err)
+	}
+	return total, nil
+}
@@ -0,0 +1,31 @@
+//go:build !linux || purego
+
+package brc
+
+import "fmt"
+
+// these are the linux specific bits of the mapping, for everywhere else and -tags purego.
+
+// MAP_POPULATE is linux only, so pages get faulted in as they're read here.
+func populateFlag(prefault bool) int {
+	return 0
+}
+
+// madvise isn't exposed by package syscall outside linux, so it's a no-op here.
+func adviseChunk(data []byte, start, end int) error {
+	return nil
+}
+
+// the pages of the mapping stay resident here until the kernel needs them back.
+func releasePages(data []byte, start, end int) {}
+
+func setupHugePages(data []byte, mode string) ([]byte, func(), error) {
+	if mode != "off" {
+		return nil, func() {}, fmt.Errorf("huge pages are only supported on linux")
+	}
+	return data, func() {}, nil
+}
+
+func hugePageBytes(data []byte) (int64, error) {
+	return 0, nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix || purego
+
+package brc
+
+import (
+	"fmt"
+	"os"
+)
+
+// without mmap, on platforms that don't have it or with -tags purego, the file is read into memory instead. it takes
+// as much memory as the file and the workers only start once all of it is in, but they get the same []byte.
+func setupMmap(path string, prefault bool) ([]byte, func(), error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, func() {}, fmt.Errorf("reading file: %w", err)
+	}
+	return data, func() {}, nil
+}
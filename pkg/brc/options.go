@@ -0,0 +1,388 @@
+package brc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// An Option configures ProcessFile, Process and ProcessReaderAt.
+type Option func(*options)
+
+type options struct {
+	ctx             context.Context
+	workers         int
+	workersSet      bool
+	chunkSize       int64
+	engine          string
+	madvise         bool
+	hugePages       string
+	prefault        bool
+	maxMemory       int64
+	pin             bool
+	smt             bool
+	writeIndex      bool
+	useIndex        bool
+	followIdle      time.Duration
+	followEvery     time.Duration
+	followUpdate    func(*Results)
+	log             *slog.Logger
+	progress        *Progress
+	spans           Spans
+	quantiles       bool
+	histograms      bool
+	stddev          bool
+	aggregator      func() Aggregator
+	stations        map[string]bool
+	stationRe       *regexp.Regexp
+	limit           int64
+	sample          float64
+	window          time.Duration
+	columns         []string
+	metrics         []string
+	groupBy         []string
+	rollUp          func(station string) string
+	foldCase        bool
+	blockSize       int
+	readAhead       bool
+	pipelineReaders int
+	batchSize       int
+	adaptInterval   time.Duration
+	scaling         []ScalingStep // what WithAdaptiveWorkers did, filled in by the run for Results
+	onError         string
+	relaxed         bool
+	logRejects      bool
+	perfectHash     bool
+	hash            string
+
+	checkpointDir   string
+	checkpointEvery time.Duration
+	resume          bool
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		ctx:       context.Background(),
+		workers:   AvailableCPUs(),
+		engine:    "default",
+		hash:      "xxhash",
+		hugePages: "off",
+		useIndex:  true,
+		log:       slog.Default(),
+		blockSize: defaultBlockSize,
+		smt:       true,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if !o.smt && !o.workersSet {
+		o.workers = PhysicalCores()
+	}
+	if len(o.metrics) == 0 {
+		o.metrics = o.columns
+	}
+	return o
+}
+
+// WithReadBlockSize sets how many bytes Process and ProcessReaderAt read at a time. lines can't be longer than this.
+// it defaults to 16MiB.
+func WithReadBlockSize(n int) Option {
+	return func(o *options) { o.blockSize = max(1, n) }
+}
+
+// WithReadAhead has ProcessReaderAt's workers read their next block in the background while they aggregate the
+// current one, so reading and parsing overlap for inputs that don't fit in the page cache. it takes a second block
+// sized buffer per worker.
+func WithReadAhead(on bool) Option {
+	return func(o *options) { o.readAhead = on }
+}
+
+// WithWorkers sets the number of workers. it defaults to AvailableCPUs(), or PhysicalCores() with WithSMT(false).
+func WithWorkers(n int) Option {
+	return func(o *options) { o.workers, o.workersSet = max(1, n), true }
+}
+
+// ChunkSizeAuto has WithChunkSize pick the chunk size from the input's size, the number of workers and the cpu's
+// cache sizes.
+const ChunkSizeAuto = -1
+
+// WithChunkSize splits ProcessFile's and ProcessReaderAt's input into chunks of about this many bytes, which the
+// workers take from a shared queue as they finish the previous ones, rather than into one chunk per worker. more,
+// smaller chunks even out workers that run at different speeds (noisy neighbours, smt siblings, skewed data), so the
+// last one finishes sooner. zero (the default) keeps one chunk per worker. it doesn't work with checkpoints, which are
+// saved per worker chunk.
+func WithChunkSize(bytes int64) Option {
+	return func(o *options) { o.chunkSize = bytes }
+}
+
+// WithEngine selects a registered Engine by name, see RegisterEngine.
+func WithEngine(name string) Option {
+	return func(o *options) { o.engine = name }
+}
+
+// WithMadvise madvises each worker's chunk with MADV_SEQUENTIAL|MADV_WILLNEED, so readahead keeps up on cold-cache
+// runs (linux only).
+func WithMadvise(on bool) Option {
+	return func(o *options) { o.madvise = on }
+}
+
+// WithHugePages backs the mapping with transparent huge pages: "off", "advise" (madvise the file mapping) or "copy"
+// (copy into an anonymous THP mapping). linux only.
+func WithHugePages(mode string) Option {
+	return func(o *options) { o.hugePages = mode }
+}
+
+// WithPrefault has ProcessFile fault in the whole mapping before the workers start (MAP_POPULATE, linux only), so
+// warm-cache benchmarks don't measure page faults.
+func WithPrefault(on bool) Option {
+	return func(o *options) { o.prefault = on }
+}
+
+// WithMaxMemory caps how much of the mapping ProcessFile keeps resident at bytes, by having the workers go through
+// their chunks a window at a time and drop each window's pages once they're done with it (linux only). it doesn't
+// work with WithPrefault or copied huge pages, which have everything resident up front. the other ways of reading
+// input only ever hold a few blocks per worker anyway.
+func WithMaxMemory(bytes int64) Option {
+	return func(o *options) { o.maxMemory = bytes }
+}
+
+// WithPinning pins each worker to its own cpu (linux only).
+func WithPinning(on bool) Option {
+	return func(o *options) { o.pin = on }
+}
+
+// WithWriteIndex writes a sidecar index of line-aligned offsets next to the input, so later runs can plan chunks
+// without scanning.
+func WithWriteIndex(on bool) Option {
+	return func(o *options) { o.writeIndex = on }
+}
+
+// WithUseIndex controls whether chunks are planned from an up to date sidecar index, if there is one. it's on by
+// default.
+func WithUseIndex(on bool) Option {
+	return func(o *options) { o.useIndex = on }
+}
+
+// WithFollow keeps consuming data appended to the file while it's being processed, until it has stopped growing for
+// idle. zero (the default) disables it.
+func WithFollow(idle time.Duration) Option {
+	return func(o *options) { o.followIdle = idle }
+}
+
+// WithCheckpoint has ProcessFile's workers save their progress to files in dir every so often, and when the run is
+// cancelled, so an interrupted run can be picked up again with WithResume. the files are removed once a run completes.
+func WithCheckpoint(dir string, every time.Duration) Option {
+	return func(o *options) { o.checkpointDir, o.checkpointEvery = dir, every }
+}
+
+// WithResume has ProcessFile's workers start from the checkpoints in the directory from WithCheckpoint, if there are
+// any. the run has to have the same input and number of workers as the one that saved them.
+func WithResume(on bool) Option {
+	return func(o *options) { o.resume = on }
+}
+
+// WithContext lets the caller stop processing early by cancelling ctx.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) { o.ctx = ctx }
+}
+
+// WithLogger sets the logger used for diagnostics. it defaults to slog.Default().
+func WithLogger(log *slog.Logger) Option {
+	return func(o *options) { o.log = log }
+}
+
+// WithProgress makes processing update p as it goes.
+func WithProgress(p *Progress) Option {
+	return func(o *options) { o.progress = p }
+}
+
+// WithQuantiles keeps a t-digest per station, so Station.Quantile works. it costs a fair bit of speed and memory.
+func WithQuantiles(on bool) Option {
+	return func(o *options) { o.quantiles = on }
+}
+
+// WithStddev keeps the sums each station's standard deviation is computed from. without it, Station.Stddev is NaN.
+func WithStddev(on bool) Option {
+	return func(o *options) { o.stddev = on }
+}
+
+// WithHistograms keeps a histogram of readings per station, so Station.Histogram works.
+func WithHistograms(on bool) Option {
+	return func(o *options) { o.histograms = on }
+}
+
+// WithStations only aggregates the named stations, dropping the readings for all others.
+func WithStations(names ...string) Option {
+	return func(o *options) {
+		o.stations = make(map[string]bool, len(names))
+		for _, n := range names {
+			o.stations[n] = true
+		}
+	}
+}
+
+// WithStationRegexp only aggregates stations whose names match re. combined with WithStations, stations have to pass
+// both.
+func WithStationRegexp(re *regexp.Regexp) Option {
+	return func(o *options) { o.stationRe = re }
+}
+
+// stationFilter returns the combined station filter, or nil if there isn't one.
+func (o *options) stationFilter() func(station []byte) bool {
+	if o.stations == nil && o.stationRe == nil {
+		return nil
+	}
+	stations := o.stations
+	if o.foldCase && stations != nil {
+		stations = make(map[string]bool, len(o.stations))
+		for name := range o.stations {
+			stations[foldCase(name)] = true
+		}
+	}
+	return func(station []byte) bool {
+		if stations != nil {
+			name := string(station)
+			if o.foldCase {
+				name = foldCase(name)
+			}
+			if !stations[name] {
+				return false
+			}
+		}
+		return o.stationRe == nil || o.stationRe.Match(station)
+	}
+}
+
+// WithLimit stops after n rows, for quick iterations on big inputs. the count is exact, but with several workers the
+// rows come from the start of each worker's share of the input rather than the start of the file. follow mode is off
+// with a limit. zero (the default) means no limit.
+func WithLimit(n int64) Option {
+	return func(o *options) { o.limit = n }
+}
+
+// WithSample only aggregates a deterministic fraction of the lines, picked by hashing their offsets, for quick
+// estimates on huge inputs, including data appended under WithFollow. zero (the default) or one or more aggregates
+// everything.
+func WithSample(rate float64) Option {
+	return func(o *options) {
+		o.sample = rate
+		if rate >= 1 {
+			o.sample = 0
+		}
+	}
+}
+
+func (o *options) newRunState() (*runState, error) {
+	validate, err := o.lineValidator()
+	if err != nil {
+		return nil, err
+	}
+	rs := &runState{progress: o.progress, budget: newRowBudget(o.limit), sample: o.sample, validate: validate, fail: o.onError == "fail"}
+	if o.logRejects {
+		rs.log = o.log
+	}
+	return rs, nil
+}
+
+// WithWindow switches to the timestamped input format, station;temperature;unix_seconds, and aggregates per station
+// per window of the given length, a whole number of seconds, so Results become a time series (see Station.Window). it
+// only works with the default engine.
+func WithWindow(d time.Duration) Option {
+	return func(o *options) { o.window = d }
+}
+
+// WithColumns switches to the multi-metric input format, station;value;value;..., naming the value columns. each
+// column selected with WithMetrics (all of them by default) is aggregated separately, see Station.Metric.
+func WithColumns(names ...string) Option {
+	return func(o *options) { o.columns = names }
+}
+
+// WithMetrics selects which of the WithColumns columns get aggregated.
+func WithMetrics(names ...string) Option {
+	return func(o *options) { o.metrics = names }
+}
+
+// WithGroupBy switches to an input format with key columns before the temperature, e.g. country;station;temperature
+// for WithGroupBy("country", "station"), and aggregates by all of them together, see Station.Keys. it's the default
+// format with the station being everything up to the last semicolon, so it costs nothing extra to parse. lines with a
+// different number of key columns aren't caught while aggregating, so check the length of Station.Keys.
+func WithGroupBy(columns ...string) Option {
+	return func(o *options) { o.groupBy = columns }
+}
+
+// stationKeys splits a station's name into its key columns, with WithGroupBy.
+func (o *options) stationKeys(name string) []string {
+	if len(o.groupBy) == 0 {
+		return nil
+	}
+	return strings.Split(name, ";")
+}
+
+func (o *options) metricName(i uint8) string {
+	if len(o.columns) == 0 {
+		return ""
+	}
+	return o.metrics[i]
+}
+
+func (o *options) newEngine() (func() Engine, error) {
+	if _, err := lookupHash(o.hash); err != nil {
+		return nil, err
+	}
+	if o.aggregator != nil && o.checkpointDir != "" {
+		return nil, errAggregatorPartial
+	}
+	if o.adaptInterval > 0 && o.pipelineReaders == 0 {
+		return nil, fmt.Errorf("adaptive workers only work with the pipeline")
+	}
+	if o.chunkSize != 0 && o.checkpointDir != "" {
+		return nil, fmt.Errorf("checkpoints don't work with a chunk size, they're saved per worker chunk")
+	}
+	if o.aggregator != nil && o.followUpdate != nil {
+		return nil, fmt.Errorf("follow updates don't work with custom aggregators")
+	}
+	if o.window < 0 || o.window%time.Second != 0 {
+		return nil, fmt.Errorf("the window has to be a whole number of seconds, not %v", o.window)
+	}
+	if len(o.groupBy) > 0 && (len(o.columns) > 0 || o.window > 0) {
+		return nil, fmt.Errorf("group by keys only work with the default format, not the multi-metric or timestamped ones")
+	}
+	if o.perfectHash && o.hash != "xxhash" {
+		return nil, fmt.Errorf("perfect hashing only works with the default hash")
+	}
+	if o.perfectHash && (o.engine != "default" || len(o.columns) > 0 || o.window > 0 || o.relaxed) {
+		return nil, fmt.Errorf("perfect hashing only works with the default engine and format")
+	}
+	if len(o.columns) > 0 {
+		if o.engine != "default" || o.window > 0 {
+			return nil, fmt.Errorf("the multi-metric format only works with the default engine and without time windows")
+		}
+		if len(o.metrics) > 256 {
+			return nil, fmt.Errorf("too many metrics")
+		}
+		cols, err := metricColumns(o.columns, o.metrics)
+		if err != nil {
+			return nil, err
+		}
+		return func() Engine {
+			return &metricsEngine{cols: cols, values: make([][]byte, 0, len(o.columns))}
+		}, nil
+	}
+	if o.window > 0 {
+		if o.engine != "default" {
+			return nil, fmt.Errorf("engine %q doesn't support time windows", o.engine)
+		}
+		secs := int64(o.window / time.Second)
+		return func() Engine { return &windowEngine{window: secs, relaxed: o.relaxed} }, nil
+	}
+	if o.relaxed {
+		if o.engine != "default" {
+			return nil, fmt.Errorf("engine %q doesn't support relaxed parsing", o.engine)
+		}
+		return func() Engine { return relaxedEngine{} }, nil
+	}
+	return lookupEngine(o.engine)
+}
@@ -0,0 +1,267 @@
+package brc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// partialMagic starts every serialized Partial, and changes whenever the format does, or the way station keys are
+// computed.
+const partialMagic = "1brc partial v3\n"
+
+const (
+	partialDigest = 1 << iota
+	partialHist
+)
+
+// MarshalBinary serializes p, so partials can be computed in one process and merged in another, see Merge. stations
+// dropped by a filter are left out.
+func (p *Partial) MarshalBinary() ([]byte, error) {
+	if p.aggregator != nil {
+		return nil, errAggregatorPartial
+	}
+	b := []byte(partialMagic)
+	n := 0
+	p.m.forEach(func(_ uint64, s *stats) {
+		if !s.skip {
+			n++
+		}
+	})
+	b = binary.AppendUvarint(b, uint64(n))
+	p.m.forEach(func(k uint64, s *stats) {
+		if s.skip {
+			return
+		}
+		if p.hash != nil {
+			k = canonicalKey(s)
+		}
+		b = binary.LittleEndian.AppendUint64(b, k)
+		b = binary.AppendUvarint(b, uint64(len(s.station)))
+		b = append(b, s.station...)
+		b = binary.AppendVarint(b, s.window)
+		b = append(b, s.metric)
+		for _, f := range []float32{s.min, s.max, s.sum, s.count, s.shift} {
+			b = binary.LittleEndian.AppendUint32(b, math.Float32bits(f))
+		}
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.sumD))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.sumSq))
+
+		var flags byte
+		if s.digest != nil {
+			flags |= partialDigest
+		}
+		if s.hist != nil {
+			flags |= partialHist
+		}
+		b = append(b, flags)
+		if s.digest != nil {
+			s.digest.compress()
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.digest.min))
+			b = binary.LittleEndian.AppendUint64(b, math.Float64bits(s.digest.max))
+			b = binary.AppendUvarint(b, uint64(len(s.digest.centroids)))
+			for _, c := range s.digest.centroids {
+				b = binary.LittleEndian.AppendUint64(b, math.Float64bits(c.mean))
+				b = binary.LittleEndian.AppendUint64(b, math.Float64bits(c.weight))
+			}
+		}
+		if s.hist != nil {
+			for _, c := range s.hist {
+				b = binary.AppendUvarint(b, uint64(c))
+			}
+		}
+	})
+	return binary.AppendUvarint(b, uint64(p.rejected)), nil
+}
+
+// UnmarshalBinary replaces p's contents with a partial serialized by MarshalBinary.
+func (p *Partial) UnmarshalBinary(b []byte) error {
+	if len(b) < len(partialMagic) || string(b[:len(partialMagic)]) != partialMagic {
+		return errors.New("not a serialized partial")
+	}
+	d := &decoder{b: b[len(partialMagic):]}
+	n := d.uvarint()
+	m := newTable(int(min(n, 1<<20)))
+	for range n {
+		if d.err != nil {
+			break
+		}
+		k := d.uint64()
+		s := &stats{station: string(d.bytes(int(d.uvarint())))}
+		s.name = newNameKey([]byte(s.station))
+		s.window = d.varint()
+		s.metric = d.byte()
+		for _, f := range []*float32{&s.min, &s.max, &s.sum, &s.count, &s.shift} {
+			*f = math.Float32frombits(d.uint32())
+		}
+		s.sumD = d.float64()
+		s.sumSq = d.float64()
+
+		flags := d.byte()
+		if flags&partialDigest != 0 {
+			s.digest = newTDigest()
+			s.digest.min, s.digest.max = d.float64(), d.float64()
+			nc := d.uvarint()
+			for range nc {
+				if d.err != nil {
+					break
+				}
+				s.digest.centroids = append(s.digest.centroids, centroid{d.float64(), d.float64()})
+			}
+		}
+		if flags&partialHist != 0 {
+			s.hist = new(histogram)
+			for i := range s.hist {
+				s.hist[i] = int64(d.uvarint())
+			}
+		}
+		m.put(k, *s)
+	}
+	var rejected int64
+	if len(d.b) > 0 { // partials from before rejected lines were counted end here
+		rejected = int64(d.uvarint())
+	}
+	if d.err != nil {
+		return fmt.Errorf("decoding partial: %w", d.err)
+	}
+	if len(d.b) > 0 {
+		return fmt.Errorf("decoding partial: %d trailing bytes", len(d.b))
+	}
+	*p = Partial{m: m, rejected: rejected}
+	return nil
+}
+
+// partialsMagic starts files written by WritePartials.
+const partialsMagic = "1brc partials v1\n"
+
+// WritePartials writes partials to w as a single file for ReadPartials, e.g. to merge shards of an input processed
+// separately.
+func WritePartials(w io.Writer, partials []*Partial) error {
+	if _, err := io.WriteString(w, partialsMagic); err != nil {
+		return err
+	}
+	for _, p := range partials {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(b)))); err != nil {
+			return err
+		}
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadPartials reads the partials in a file written by WritePartials.
+func ReadPartials(r io.Reader) ([]*Partial, error) {
+	br := bufio.NewReader(r)
+	magic := make([]byte, len(partialsMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || string(magic) != partialsMagic {
+		return nil, errors.New("not a partials file")
+	}
+	var partials []*Partial
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return partials, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("reading partial %d: %w", len(partials), err)
+		}
+		b := make([]byte, n)
+		if _, err := io.ReadFull(br, b); err != nil {
+			return nil, fmt.Errorf("reading partial %d: %w", len(partials), err)
+		}
+		p := new(Partial)
+		if err := p.UnmarshalBinary(b); err != nil {
+			return nil, fmt.Errorf("reading partial %d: %w", len(partials), err)
+		}
+		partials = append(partials, p)
+	}
+}
+
+// Merge combines partials from ProcessRange (or UnmarshalBinary, or ReadPartials) into results, the same way the
+// partials of a single run's workers are combined. the options have to agree with the ones the partials were computed
+// with as far as windows and metrics go, since those are only stored as numbers. the partials can't be used afterwards.
+func Merge(partials []*Partial, opts ...Option) *Results {
+	if len(partials) == 0 {
+		return &Results{}
+	}
+	return newResults(partials, nil, newOptions(opts))
+}
+
+// decoder reads the fixed and variable size fields of a serialized partial, remembering the first error so callers
+// only check once at the end.
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.b) {
+		d.err = errors.New("truncated")
+		return nil
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) byte() byte {
+	if v := d.bytes(1); v != nil {
+		return v[0]
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if v := d.bytes(4); v != nil {
+		return binary.LittleEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if v := d.bytes(8); v != nil {
+		return binary.LittleEndian.Uint64(v)
+	}
+	return 0
+}
+
+func (d *decoder) float64() float64 {
+	return math.Float64frombits(d.uint64())
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = errors.New("bad varint")
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
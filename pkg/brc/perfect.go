@@ -0,0 +1,198 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"math/bits"
+	"slices"
+
+	"golang.org/x/exp/maps"
+)
+
+// WithPerfectHash samples the file for its station names before the main pass, builds a minimal perfect hash over
+// them, and aggregates known stations by indexing straight into an array instead of probing the partial's table.
+// stations the sample missed still work, they just take the usual path. it only applies to ProcessFile, with the
+// default engine and format.
+func WithPerfectHash(on bool) Option {
+	return func(o *options) { o.perfectHash = on }
+}
+
+const (
+	// perfectSamples is how many evenly spaced pieces of the file are scanned for station names.
+	perfectSamples = 16
+	// perfectSampleSize is how long each piece is. with a 10k station file, 16 pieces of 1MB see every one of them
+	// hundreds of times.
+	perfectSampleSize = 1 << 20
+)
+
+// sampleStations returns the hashes of the stations in evenly spaced pieces of data.
+func sampleStations(data []byte) []uint64 {
+	seen := map[uint64]bool{}
+	add := func(piece []byte) {
+		for len(piece) > 0 {
+			n := bytes.IndexByte(piece, '\n')
+			if n < 0 {
+				return // a partial line, the next piece starts after it
+			}
+			line := trimCR(piece[:n])
+			piece = piece[n+1:]
+			if semi := bytes.LastIndexByte(line, ';'); semi > 0 {
+				seen[stationHash(line[:semi])] = true
+			}
+		}
+	}
+	if len(data) <= perfectSamples*perfectSampleSize {
+		add(data)
+		return maps.Keys(seen)
+	}
+	step := len(data) / perfectSamples
+	for i := range perfectSamples {
+		piece := data[i*step : i*step+perfectSampleSize]
+		if i > 0 {
+			// skip the partial line the piece starts in
+			piece = piece[bytes.IndexByte(piece, '\n')+1:]
+		}
+		add(piece)
+	}
+	return maps.Keys(seen)
+}
+
+// perfectHash maps each of a fixed set of station hashes to its own index in [0, n), using hash and displace: keys
+// are spread over buckets, and each bucket gets a seed that sends its keys to free indexes. other hashes map to some
+// index too, so lookups have to check keys.
+type perfectHash struct {
+	seeds []uint64 // per bucket
+	keys  []uint64 // by index
+}
+
+func (ph *perfectHash) index(h uint64) uint64 {
+	b, _ := bits.Mul64(h*keyMul, uint64(len(ph.seeds)))
+	i, _ := bits.Mul64((h^ph.seeds[b])*keyMul, uint64(len(ph.keys)))
+	return i
+}
+
+func newPerfectHash(keys []uint64) *perfectHash {
+	n := max(len(keys), 1)
+	ph := &perfectHash{seeds: make([]uint64, n/4+1), keys: make([]uint64, n)}
+	buckets := make([][]uint64, len(ph.seeds))
+	for _, k := range keys {
+		b, _ := bits.Mul64(k*keyMul, uint64(len(buckets)))
+		buckets[b] = append(buckets[b], k)
+	}
+	// place the biggest buckets first, while there's plenty of room
+	order := make([]int, len(buckets))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return len(buckets[b]) - len(buckets[a]) })
+
+	taken := make([]bool, n)
+	idx := make([]uint64, 0, 16)
+	for _, b := range order {
+		if len(buckets[b]) == 0 {
+			break
+		}
+		for seed := uint64(1); ; seed++ {
+			ph.seeds[b] = seed
+			idx = idx[:0]
+			for _, k := range buckets[b] {
+				i := ph.index(k)
+				if taken[i] || slices.Contains(idx, i) {
+					break
+				}
+				idx = append(idx, i)
+			}
+			if len(idx) == len(buckets[b]) {
+				break
+			}
+		}
+		for j, i := range idx {
+			taken[i] = true
+			ph.keys[i] = buckets[b][j]
+		}
+	}
+	return ph
+}
+
+// perfectEngine is the default engine with WithPerfectHash. it keeps a pointer to each known station's stats at its
+// perfect hash index, and only goes to the partial's table for stations it hasn't seen yet.
+type perfectEngine struct {
+	w    worker
+	ph   *perfectHash
+	p    *Partial
+	ptrs []*stats // by index. only valid for p
+}
+
+func newPerfectEngine(ph *perfectHash) *perfectEngine {
+	return &perfectEngine{ph: ph, ptrs: make([]*stats, len(ph.keys))}
+}
+
+func (e *perfectEngine) Run(chunk []byte, p *Partial) error {
+	if p != e.p {
+		e.p = p
+		clear(e.ptrs)
+	}
+	deviations := p.deviations
+	lineStart := 0
+	for i := 0; i < len(chunk); i++ {
+		if chunk[i] != '\n' {
+			continue
+		}
+		stationBs, h, temp, err := e.w.parseLineBytes(chunk[lineStart:i])
+		if err != nil {
+			return fmt.Errorf("parsing line %w", err)
+		}
+		lineStart = i + 1
+
+		idx := e.ph.index(h)
+		s := e.ptrs[idx]
+		if s == nil || e.ph.keys[idx] != h {
+			s = e.lookup(idx, h, stationBs, temp)
+		}
+		if s.skip {
+			continue
+		}
+		// same as the default engine, inlined by hand since s.add is too big for the compiler to
+		s.min = min(s.min, temp)
+		s.max = max(s.max, temp)
+		s.sum += temp
+		if deviations {
+			d := float64(temp) - float64(s.shift)
+			s.sumD += d
+			s.sumSq += d * d
+		}
+		s.count++
+		if s.digest != nil {
+			s.digest.add(temp)
+		}
+		if s.hist != nil {
+			s.hist.add(temp)
+		}
+		if s.agg != nil {
+			s.agg.Observe(s.id, temp)
+		}
+	}
+	return nil
+}
+
+// lookup finds or creates the stats for a station in the partial's table, remembering where they are if the station
+// is a known one.
+func (e *perfectEngine) lookup(idx, h uint64, station []byte, temp float32) *stats {
+	s, ok := e.p.m.get(h)
+	if !ok {
+		size := len(e.p.m.slots)
+		s = e.p.newStats(h, station, temp)
+		if len(e.p.m.slots) != size {
+			// the table grew, which moved the stats we have pointers to
+			for i, k := range e.ph.keys {
+				if e.ptrs[i] != nil {
+					e.ptrs[i], _ = e.p.m.get(k)
+				}
+			}
+		}
+	}
+	if e.ph.keys[idx] == h {
+		e.ptrs[idx] = s
+	}
+	return s
+}
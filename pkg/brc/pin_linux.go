@@ -0,0 +1,23 @@
+//go:build linux && !purego
+
+package brc
+
+import (
+	"fmt"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinToCPU locks the calling goroutine to its OS thread and that thread to a single cpu, so the scheduler can't
+// migrate a worker mid-chunk. the goroutine stays locked to the thread for the rest of its life.
+func pinToCPU(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Zero()
+	set.Set(cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("sched_setaffinity cpu %d: %w", cpu, err)
+	}
+	return nil
+}
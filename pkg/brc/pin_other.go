@@ -0,0 +1,9 @@
+//go:build !linux || purego
+
+package brc
+
+// there's no affinity api on mac (or most other places), nor with -tags purego, which leaves out x/sys, so pinning is
+// a no-op.
+func pinToCPU(cpu int) error {
+	return nil
+}
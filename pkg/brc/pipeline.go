@@ -0,0 +1,257 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/trace"
+	"sync"
+	"time"
+)
+
+// WithPipeline switches ProcessReaderAt, ProcessRange and Process from their shared-nothing design, where each worker
+// reads and aggregates its own share of the input, to a pipeline: readers goroutines read the input a block at a time
+// (see WithReadBlockSize) and send batches of about batchSize bytes of whole lines over a channel to whichever worker
+// is free. every byte gets copied once more and the workers contend on the channel, so it's mostly a point of
+// comparison, but it balances uneven inputs by itself and works the same for inputs that can only be read front to
+// back, where there's only ever the one reader. zero readers (the default) disables it.
+func WithPipeline(readers, batchSize int) Option {
+	return func(o *options) { o.pipelineReaders, o.batchSize = readers, max(1, batchSize) }
+}
+
+// errStopReading stops a pipeline's reader without it being an error, once the row budget runs out.
+var errStopReading = errors.New("stop reading")
+
+// a batch is a run of whole lines copied out of the input for a pipeline's workers.
+type batch struct {
+	buf    []byte
+	offset int64 // of buf[0] in the input
+	line   int64 // lines before buf[0], only counted by Process's reader with WithOnError("fail")
+}
+
+// pipeline is what the readers and workers of a WithPipeline run share. a fixed set of batch buffers cycles between
+// them, which bounds memory use like Process's blocks do.
+type pipeline struct {
+	size    int
+	free    chan []byte
+	batches chan batch
+	done    chan struct{} // closed once the readers are done
+	sc      *scaler       // nil without WithAdaptiveWorkers
+}
+
+func newPipeline(o *options) *pipeline {
+	p := &pipeline{size: o.batchSize, free: make(chan []byte, 2*o.workers), batches: make(chan batch, o.workers), done: make(chan struct{})}
+	if o.adaptInterval > 0 {
+		p.sc = newScaler(o.workers)
+	}
+	for range cap(p.free) {
+		p.free <- make([]byte, 0, o.batchSize)
+	}
+	return p
+}
+
+// send copies the lines in chunk, which starts at offset in the input, into batches of up to p.size bytes and hands
+// them to the workers. batches end at the last newline that fits, or after the first line if even that doesn't fit.
+// with lines >= 0, the number of lines before chunk, it counts the lines before each batch too. it returns false if
+// ctx is done first.
+func (p *pipeline) send(ctx context.Context, chunk []byte, offset, lines int64) bool {
+	for len(chunk) > 0 {
+		n := len(chunk)
+		if n > p.size {
+			if nl := bytes.LastIndexByte(chunk[:p.size], '\n'); nl >= 0 {
+				n = nl + 1
+			} else if nl := bytes.IndexByte(chunk[p.size:], '\n'); nl >= 0 {
+				n = p.size + nl + 1
+			}
+		}
+		var buf []byte
+		select {
+		case buf = <-p.free:
+		case <-ctx.Done():
+			return false
+		}
+		b := batch{buf: append(buf[:0], chunk[:n]...), offset: offset, line: lines}
+		select {
+		case p.batches <- b:
+		default:
+			// all the workers are busy
+			waitStart := time.Now()
+			select {
+			case p.batches <- b:
+			case <-ctx.Done():
+				return false
+			}
+			if p.sc != nil {
+				p.sc.blocked.Add(int64(time.Since(waitStart)))
+			}
+		}
+		if lines >= 0 {
+			lines += int64(bytes.Count(chunk[:n], []byte{'\n'}))
+		}
+		chunk, offset = chunk[n:], offset+int64(n)
+	}
+	return true
+}
+
+// runPipeline runs the workers of a WithPipeline run along with readers goroutines, which call read with their index
+// and a send function for their lines. lineAt turns the offset of a line in a batch into its line number, for errors
+// with WithOnError("fail").
+func runPipeline(o *options, newEngine func() Engine, total int64, readers int, read func(ctx context.Context, i int, send func(chunk []byte, offset, lines int64) bool) error, lineAt func(b batch, offset int64) (int64, error)) ([]*Partial, []WorkerStats, error) {
+	p := newPipeline(o)
+	partials := make([]*Partial, o.workers)
+	workerStats := make([]WorkerStats, o.workers)
+	begin := time.Now()
+	o.progress.start(o.workers, total)
+	rs, err := o.newRunState()
+	if err != nil {
+		return nil, nil, err
+	}
+	g, ctx := newGroup(o.ctx)
+	worker := func(i int) func() error {
+		res := partials[i]
+		ws := &workerStats[i]
+		return func() (err error) {
+			ctx, task := workerTask(enterStage(ctx, stageParse, i), i)
+			defer task.End()
+			end := o.span("worker", "worker", i)
+			defer func() { end(err) }()
+			ws.Start = time.Since(begin)
+			o.pinWorker(i)
+			w := newEngine()
+			for {
+				if p.sc != nil && !p.sc.park(ctx, i, p.done) {
+					return nil
+				}
+				wait := trace.StartRegion(ctx, "wait for batch")
+				var b batch
+				var ok bool
+				select {
+				case b, ok = <-p.batches:
+				default:
+					waitStart := time.Now()
+					select {
+					case <-ctx.Done():
+						wait.End()
+						return nil // whoever cancelled has the error
+					case b, ok = <-p.batches:
+					}
+					if p.sc != nil {
+						p.sc.waited.Add(int64(time.Since(waitStart)))
+					}
+				}
+				wait.End()
+				if !ok {
+					return nil
+				}
+				runStart := time.Now()
+				o.progress.busy(1)
+				err := runChunk(ctx, w, b.buf, b.offset, res, rs)
+				o.progress.busy(-1)
+				if err != nil {
+					setLineNumber(err, func(offset int64) (int64, error) { return lineAt(b, offset) })
+					return fmt.Errorf("worker %d: %w", i, err)
+				}
+				ws.Busy += time.Since(runStart)
+				ws.Bytes += int64(len(b.buf))
+				if p.sc != nil {
+					p.sc.bytes.Add(int64(len(b.buf)))
+				}
+				p.free <- b.buf
+			}
+		}
+	}
+	for i := range o.workers {
+		partials[i] = newPartial(o)
+	}
+	if p.sc != nil {
+		// the scaler starts the workers as it needs them
+		g.Go(func() error {
+			p.sc.run(ctx, o.adaptInterval, readers, begin, p.done, func(i int) { g.Go(worker(i)) })
+			return nil
+		})
+	} else {
+		for i := range o.workers {
+			g.Go(worker(i))
+		}
+	}
+
+	var reading sync.WaitGroup
+	for i := range readers {
+		reading.Add(1)
+		g.Go(func() (err error) {
+			defer reading.Done()
+			ctx := enterStage(ctx, stageScan, -1)
+			end := o.span("reader", "reader", i)
+			defer func() { end(err) }()
+			err = read(ctx, i, func(chunk []byte, offset, lines int64) bool {
+				return !rs.budget.exhausted() && p.send(ctx, chunk, offset, lines)
+			})
+			if errors.Is(err, errStopReading) {
+				return nil
+			}
+			return err
+		})
+	}
+	go func() {
+		reading.Wait()
+		close(p.done)
+		close(p.batches)
+	}()
+
+	err = g.Wait()
+	finishWorkerStats(workerStats, partials, time.Since(begin))
+	if p.sc != nil {
+		o.scaling = p.sc.steps
+	}
+	return partials, workerStats, err
+}
+
+// processRangePipeline is processRange with WithPipeline: the readers split [start, end) between them like
+// processRange's workers do.
+func processRangePipeline(r io.ReaderAt, size, start, end int64, o *options, newEngine func() Engine) ([]*Partial, []WorkerStats, error) {
+	readers := chunkWorkers(end-start, o.pipelineReaders)
+	return runPipeline(o, newEngine, end-start, readers, func(ctx context.Context, i int, send func(chunk []byte, offset, lines int64) bool) error {
+		from, to := start+(end-start)*int64(i)/int64(readers), start+(end-start)*int64(i+1)/int64(readers)
+		_, err := readRange(ctx, r, size, from, to, make([]byte, o.blockSize+1), nil, func(chunk []byte, offset int64) error {
+			if !send(chunk, offset, -1) {
+				return errStopReading
+			}
+			return nil
+		})
+		return err
+	}, func(_ batch, offset int64) (int64, error) {
+		return countLinesAt(r, offset)
+	})
+}
+
+// processPipeline is Process with WithPipeline, with a single reader.
+func processPipeline(r io.Reader, o *options, newEngine func() Engine) ([]*Partial, []WorkerStats, error) {
+	return runPipeline(o, newEngine, 0, 1, func(ctx context.Context, _ int, send func(chunk []byte, offset, lines int64) bool) error {
+		// the reader hangs on to its two blocks: one being filled while the other's lines are copied out
+		free := make(chan []byte, 2)
+		for range cap(free) {
+			free <- make([]byte, o.blockSize+1)
+		}
+		lines := int64(-1)
+		if o.onError == "fail" {
+			lines = 0
+		}
+		return readBlocks(ctx, r, free, func(buf []byte, start, end int, offset int64) bool {
+			defer func() { free <- buf }()
+			if lines >= 0 {
+				lines += int64(bytes.Count(buf[:start], []byte{'\n'}))
+			}
+			if !send(buf[start:end], offset, lines) {
+				return false
+			}
+			if lines >= 0 {
+				lines += int64(bytes.Count(buf[start:end], []byte{'\n'}))
+			}
+			return true
+		})
+	}, func(b batch, offset int64) (int64, error) {
+		return b.line + int64(bytes.Count(b.buf[:offset-b.offset], []byte{'\n'})), nil
+	})
+}
@@ -0,0 +1,71 @@
+package brc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Progress exposes live counters for a run in flight, e.g. for metrics. pass one in with WithProgress; it's safe to
+// read while processing is going on.
+type Progress struct {
+	Started       atomic.Int64 // unix nanos
+	BytesTotal    atomic.Int64 // 0 if unknown, as for Process
+	BytesDone     atomic.Int64
+	Workers       atomic.Int64
+	BusyWorkers   atomic.Int64
+	Rows          atomic.Int64 // only set once processing is done
+	Stations      atomic.Int64 // only set once processing is done
+	FinishedNanos atomic.Int64 // unix nanos, 0 while running
+}
+
+// Elapsed returns how long the run has been going, or how long it took if it's done.
+func (p *Progress) Elapsed() time.Duration {
+	start := p.Started.Load()
+	if start == 0 {
+		return 0
+	}
+	end := p.FinishedNanos.Load()
+	if end == 0 {
+		end = time.Now().UnixNano()
+	}
+	return time.Duration(end - start)
+}
+
+// the methods below are what the processing code calls. they're all no-ops on a nil Progress.
+
+func (p *Progress) start(workers int, total int64) {
+	if p == nil {
+		return
+	}
+	p.Started.Store(time.Now().UnixNano())
+	p.Workers.Store(int64(workers))
+	p.BytesTotal.Store(total)
+}
+
+// grow adds n bytes appended to the input since start, see WithFollow.
+func (p *Progress) grow(n int) {
+	if p != nil {
+		p.BytesTotal.Add(int64(n))
+	}
+}
+
+func (p *Progress) addBytes(n int) {
+	if p != nil {
+		p.BytesDone.Add(int64(n))
+	}
+}
+
+func (p *Progress) busy(delta int64) {
+	if p != nil {
+		p.BusyWorkers.Add(delta)
+	}
+}
+
+func (p *Progress) finish(res *Results) {
+	if p == nil {
+		return
+	}
+	p.Rows.Store(res.Rows())
+	p.Stations.Store(int64(len(res.Stations)))
+	p.FinishedNanos.Store(time.Now().UnixNano())
+}
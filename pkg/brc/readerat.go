@@ -0,0 +1,244 @@
+package brc
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// readerAtContext is implemented by readers whose reads can be cancelled, like objstore.Object.
+type readerAtContext interface {
+	ReadAtContext(ctx context.Context, p []byte, off int64) (int, error)
+}
+
+// ProcessReaderAt aggregates the size bytes of measurements in r, for inputs that support random access but can't be
+// mmapped, like objects behind ranged http requests. like ProcessFile, it splits the input into one chunk per worker
+// (or WithChunkSize chunks), and each worker reads its own chunk a block at a time (see WithReadBlockSize), so there
+// are as many reads in flight as there are workers. chunk boundaries are moved forward to line starts as the workers
+// go, so nothing has to be scanned upfront. if r has a ReadAtContext(ctx, p, off) method, it's used so cancellation
+// interrupts reads in flight. the file-only options (madvise, huge pages, prefault, index, follow) don't apply.
+func ProcessReaderAt(r io.ReaderAt, size int64, opts ...Option) (*Results, error) {
+	o := newOptions(opts)
+	partials, workerStats, err := processRange(r, size, 0, size, o)
+	if o.ctx.Err() != nil && partials != nil {
+		return newResults(partials, workerStats, o), interrupted(o.ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res := newResults(partials, workerStats, o)
+	o.progress.finish(res)
+	return res, nil
+}
+
+// ProcessRange aggregates the lines of r that start in [start, end) of its size bytes, for splitting an input between
+// processes or machines: ranges that tile the input cover every line exactly once, wherever their boundaries fall. it
+// works like ProcessReaderAt, but returns the workers' partials, which can be serialized with MarshalBinary and
+// combined with those of the other ranges by Merge. if the context from WithContext is cancelled, it returns the
+// partials so far along with an error wrapping the context's.
+func ProcessRange(r io.ReaderAt, size, start, end int64, opts ...Option) ([]*Partial, error) {
+	o := newOptions(opts)
+	partials, _, err := processRange(r, size, start, end, o)
+	if o.ctx.Err() != nil && partials != nil {
+		return partials, interrupted(o.ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return partials, nil
+}
+
+func processRange(r io.ReaderAt, size, start, end int64, o *options) ([]*Partial, []WorkerStats, error) {
+	newEngine, err := o.newEngine()
+	if err != nil {
+		return nil, nil, err
+	}
+	if o.pipelineReaders > 0 {
+		return processRangePipeline(r, size, start, end, o, newEngine)
+	}
+
+	chunks, workers := o.chunkPlan(end - start)
+	bounds := func(c int) (int64, int64) {
+		return start + (end-start)*int64(c)/int64(chunks), start + (end-start)*int64(c+1)/int64(chunks)
+	}
+	partials := make([]*Partial, workers)
+	workerStats := make([]WorkerStats, workers)
+	begin := time.Now()
+	o.progress.start(workers, end-start)
+	rs, err := o.newRunState()
+	if err != nil {
+		return nil, nil, err
+	}
+	g, ctx := newGroup(o.ctx)
+	// like ProcessFile's workers, each one starts on its own chunk and then takes the next one left
+	var next atomic.Int64
+	next.Store(int64(workers))
+	for i := range workers {
+		res := newPartial(o)
+		partials[i] = res
+		ws := &workerStats[i]
+
+		g.Go(func() (err error) {
+			ctx, task := workerTask(enterStage(ctx, stageParse, i), i)
+			defer task.End()
+			attrs := []any{"worker", i}
+			if chunks == workers {
+				from, to := bounds(i)
+				attrs = append(attrs, "offset", from, "bytes", to-from)
+			}
+			end := o.span("worker", attrs...)
+			defer func() { end(err) }()
+			ws.Start = time.Since(begin)
+			o.progress.busy(1)
+			defer func() {
+				ws.Busy = time.Since(begin) - ws.Start
+				o.progress.busy(-1)
+			}()
+			o.pinWorker(i)
+			w := newEngine()
+			var spare []byte
+			if o.readAhead {
+				spare = make([]byte, o.blockSize+1)
+			}
+			buf := make([]byte, o.blockSize+1)
+			for c := i; c < chunks; c = int(next.Add(1) - 1) {
+				from, to := bounds(c)
+				n, err := readRange(ctx, r, size, from, to, buf, spare, func(chunk []byte, offset int64) error {
+					return runChunk(ctx, w, chunk, offset, res, rs)
+				})
+				ws.Bytes += n
+				if err != nil {
+					setLineNumber(err, func(offset int64) (int64, error) { return countLinesAt(r, offset) })
+					return fmt.Errorf("worker %d: %w", i, err)
+				}
+			}
+			return nil
+		})
+	}
+
+	err = g.Wait()
+	finishWorkerStats(workerStats, partials, time.Since(begin))
+	return partials, workerStats, err
+}
+
+// readRange reads the lines of r that start in [start, end) into buf a block at a time (keeping its last byte free for
+// terminating the input's last line), passing the complete lines to f along with their offset, and returns how many
+// bytes of lines it passed on. a chunk that doesn't start at 0 owns the lines after the first newline at or after
+// start-1, and the last line it owns is the one that runs past end-1, so adjacent chunks split the lines between them
+// without either having to know where the other one ends up. if spare is non-nil (and as big as buf), the next block is
+// read into it in the background while f handles the current one, and the two take turns.
+func readRange(ctx context.Context, r io.ReaderAt, size, start, end int64, buf, spare []byte, f func(chunk []byte, offset int64) error) (int64, error) {
+	readAt := r.ReadAt
+	if rc, ok := r.(readerAtContext); ok {
+		readAt = func(p []byte, off int64) (int, error) { return rc.ReadAtContext(ctx, p, off) }
+	}
+	type result struct {
+		n   int
+		err error
+	}
+	var pending chan result // the background read, if there is one
+	defer func() {
+		if pending != nil {
+			<-pending // don't leave it writing into a buffer we no longer own
+		}
+	}()
+
+	base := max(start-1, 0) // input offset of buf[0]
+	pos := base             // where the next read goes
+	n := 0                  // bytes in buf
+	first := true
+	var passed int64
+	for {
+		if err := ctx.Err(); err != nil {
+			return passed, err
+		}
+		if n == len(buf)-1 {
+			return passed, fmt.Errorf("line longer than %d bytes", len(buf)-1)
+		}
+		var m int
+		var err error
+		if pending != nil {
+			res := <-pending
+			pending = nil
+			m, err = res.n, res.err
+		} else {
+			m, err = readAt(buf[n:min(int64(len(buf)-1), int64(n)+size-pos)], pos)
+		}
+		if err != nil && err != io.EOF {
+			return passed, fmt.Errorf("reading at %d: %w", pos, err)
+		}
+		n += m
+		pos += int64(m)
+		eof := pos >= size
+		data := buf[:n]
+
+		lo := 0
+		if first {
+			if start == 0 {
+				lo = headerLen(data)
+			} else if nl := bytes.IndexByte(data, '\n'); nl >= 0 {
+				lo = nl + 1
+			} else if eof {
+				return passed, nil // the rest of the input is one line, which isn't ours
+			} else {
+				// keep looking for the end of the previous chunk's last line
+				n, base = 0, pos
+				continue
+			}
+			first = false
+		}
+		if base+int64(lo) >= end {
+			return passed, nil // the chunk falls entirely within a line that started before it
+		}
+
+		hi, done := n, eof
+		if k := int(end - 1 - base); k < n {
+			if nl := bytes.IndexByte(data[max(k, lo):], '\n'); nl >= 0 {
+				hi, done = max(k, lo)+nl+1, true
+			}
+		}
+		if !done {
+			hi = bytes.LastIndexByte(data, '\n') + 1
+			if hi <= lo {
+				hi = lo // no complete line yet, read more
+			}
+		}
+		if done && hi == n && eof && n > lo && data[n-1] != '\n' {
+			// the input doesn't end in a newline. engines only take full lines, so add it in the spare byte
+			buf[n] = '\n'
+			hi++
+			data = buf[:hi]
+		}
+		var rest int // with a read in the background, the bytes of data[hi:] that were moved to spare ahead of it
+		if spare != nil && !done && hi > lo {
+			// start on the next block while f is busy with this one
+			buf, spare = spare, buf
+			rest = copy(buf, data[hi:])
+			ch := make(chan result, 1)
+			go func(p []byte, off int64) {
+				enterStage(ctx, stageScan, -1)
+				m, err := readAt(p, off)
+				ch <- result{m, err}
+			}(buf[rest:min(int64(len(buf)-1), int64(rest)+size-pos)], pos)
+			pending = ch
+		}
+		if hi > lo {
+			if err := f(data[lo:hi], base+int64(lo)); err != nil {
+				return passed, err
+			}
+			passed += int64(hi - lo)
+		}
+		if done {
+			return passed, nil
+		}
+		if pending != nil {
+			n = rest
+		} else {
+			n = copy(buf, data[hi:])
+		}
+		base += int64(hi)
+	}
+}
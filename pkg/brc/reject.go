@@ -0,0 +1,170 @@
+package brc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WithOnError sets what happens to malformed lines. by default lines aren't checked ahead of time, which is fastest:
+// the default engine stops the run at the first line it can't parse, and other engines may assume well-formed input
+// and misparse a malformed line or crash. "skip" checks every line before it's aggregated and drops the ones that
+// don't parse, counting them in Results.Rejected (and logging them, see WithLogRejects). "fail" checks them too, and
+// stops the run at the first malformed line with a *LineError.
+func WithOnError(mode string) Option {
+	return func(o *options) { o.onError = mode }
+}
+
+// WithLogRejects logs every line WithOnError("skip") drops, with its byte offset.
+func WithLogRejects(on bool) Option {
+	return func(o *options) { o.logRejects = on }
+}
+
+// lineValidator returns a func that reports whether the engine can parse a line, or nil if lines aren't checked.
+func (o *options) lineValidator() (func(line []byte) bool, error) {
+	switch o.onError {
+	case "":
+		return nil, nil
+	case "skip", "fail":
+	default:
+		return nil, fmt.Errorf("unknown on-error mode %q (want skip or fail)", o.onError)
+	}
+
+	if len(o.columns) > 0 {
+		cols, err := metricColumns(o.columns, o.metrics)
+		if err != nil {
+			return nil, err
+		}
+		return func(line []byte) bool {
+			station, rest, ok := bytes.Cut(trimCR(line), []byte{';'})
+			if !ok || len(station) == 0 {
+				return false
+			}
+			values := bytes.Split(rest, []byte{';'})
+			for _, col := range cols {
+				if col >= len(values) {
+					return false
+				}
+				if _, ok := parseDecimal(values[col]); !ok {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+	validTemp := validTemp
+	if o.relaxed {
+		validTemp = func(bs []byte) bool {
+			_, ok := parseDecimal(bs)
+			return ok
+		}
+	}
+	if o.window > 0 {
+		return func(line []byte) bool {
+			line = trimCR(line)
+			tsSemi := bytes.LastIndexByte(line, ';')
+			tempSemi := bytes.LastIndexByte(line[:max(tsSemi, 0)], ';')
+			if tempSemi <= 0 {
+				return false
+			}
+			_, ok := parseUnix(line[tsSemi+1:])
+			return ok && validTemp(line[tempSemi+1:tsSemi])
+		}, nil
+	}
+	return func(line []byte) bool {
+		line = trimCR(line)
+		semi := bytes.LastIndexByte(line, ';')
+		return semi > 0 && validTemp(line[semi+1:])
+	}, nil
+}
+
+// validTemp reports whether bs is a temperature parseFloat can parse: an optional minus sign, one or two digits, a dot
+// and one more digit.
+func validTemp(bs []byte) bool {
+	_, ok := parseFloat(bs)
+	return ok
+}
+
+// A LineError is the malformed line that stopped a run with WithOnError("fail").
+type LineError struct {
+	Line   int64  // 1-based, counting any header lines
+	Offset int64  // of the start of the line, in bytes
+	Text   []byte // the line, without its line ending, and cut short if it's very long
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("malformed line %d (byte offset %d): %q", e.Line, e.Offset, e.Text)
+}
+
+// Unwrap makes a LineError an ErrMalformedLine.
+func (e *LineError) Unwrap() error {
+	return ErrMalformedLine
+}
+
+// maxLineErrorText is how much of a malformed line a LineError keeps.
+const maxLineErrorText = 256
+
+func newLineError(line []byte, offset int64) *LineError {
+	line = bytes.TrimRight(line, "\r\n")
+	return &LineError{Offset: offset, Text: bytes.Clone(line[:min(len(line), maxLineErrorText)])}
+}
+
+// setLineNumber fills in the line number of a LineError in err's chain, if there is one, with lines, which counts the
+// newlines before an offset. only the offset is known when the error happens, since workers don't know which line
+// their chunks start at, but a failed run can afford to go back and count. it has to be called before err is wrapped,
+// since wrapping formats the message.
+func setLineNumber(err error, lines func(offset int64) (int64, error)) {
+	var le *LineError
+	if !errors.As(err, &le) {
+		return
+	}
+	if n, lerr := lines(le.Offset); lerr == nil {
+		le.Line = n + 1
+	}
+}
+
+// countLinesAt counts the newlines in the first end bytes of r.
+func countLinesAt(r io.ReaderAt, end int64) (int64, error) {
+	buf := make([]byte, min(end, defaultBlockSize))
+	var n int64
+	for off := int64(0); off < end; {
+		m, err := r.ReadAt(buf[:min(int64(len(buf)), end-off)], off)
+		n += int64(bytes.Count(buf[:m], []byte{'\n'}))
+		off += int64(m)
+		if err != nil && !(err == io.EOF && off >= end) {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// rejectLines drops the lines of chunk (which starts at offset in the input) that valid rejects, passing each to
+// reject with its offset. chunk is returned as is if every line is valid, which is the usual case, otherwise the valid
+// lines are copied to dst.
+func rejectLines(dst, chunk []byte, offset int64, valid func([]byte) bool, reject func(line []byte, offset int64)) []byte {
+	copied := false
+	for pos := 0; pos < len(chunk); {
+		n := bytes.IndexByte(chunk[pos:], '\n') + 1
+		if n == 0 {
+			n = len(chunk) - pos
+		}
+		line := chunk[pos : pos+n]
+		if valid(bytes.TrimSuffix(line, []byte{'\n'})) {
+			if copied {
+				dst = append(dst, line...)
+			}
+		} else {
+			if !copied {
+				dst = append(dst, chunk[:pos]...)
+				copied = true
+			}
+			reject(line, offset+int64(pos))
+		}
+		pos += n
+	}
+	if !copied {
+		return chunk
+	}
+	return dst
+}
@@ -0,0 +1,39 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WithRelaxed accepts temperatures in any plain decimal format, like 12, +3.25 or -0.125, rather than just the
+// official one with one fractional digit, for data that didn't come from the generator. parsing them is slower, and
+// the only engine that can is the default one (including with WithWindow).
+func WithRelaxed(on bool) Option {
+	return func(o *options) { o.relaxed = on }
+}
+
+// relaxedEngine is the default engine with WithRelaxed. it finds the semicolon by scanning instead of guessing where
+// it is from the temperature's length.
+type relaxedEngine struct{}
+
+func (relaxedEngine) Run(chunk []byte, p *Partial) error {
+	for len(chunk) > 0 {
+		n := bytes.IndexByte(chunk, '\n')
+		if n < 0 {
+			n = len(chunk)
+		}
+		line := trimCR(chunk[:n])
+		chunk = chunk[min(n+1, len(chunk)):]
+
+		semi := bytes.LastIndexByte(line, ';')
+		if semi < 0 {
+			return fmt.Errorf("parsing line %q: %w: no semicolon", line, ErrMalformedLine)
+		}
+		temp, ok := parseDecimal(line[semi+1:])
+		if !ok {
+			return fmt.Errorf("parsing line %q: %w", line, ErrBadTemperature)
+		}
+		p.Observe(line[:semi], temp)
+	}
+	return nil
+}
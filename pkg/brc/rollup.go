@@ -0,0 +1,26 @@
+package brc
+
+import "github.com/kamstrup/intmap"
+
+// WithRollUp aggregates stations under key(name) rather than their own names, e.g. to roll them up into regions. it
+// works on the merged aggregates once the workers are done, so the hot loop doesn't pay for it, and WithStations and
+// WithStationRegexp still go by the stations' own names.
+func WithRollUp(key func(station string) string) Option {
+	return func(o *options) { o.rollUp = key }
+}
+
+// rollUp merges the stats in m whose stations have the same key. the stats are reused, renamed to their key.
+func rollUp(m *intmap.Map[uint64, *stats], key func(station string) string) *intmap.Map[uint64, *stats] {
+	res := intmap.New[uint64, *stats](m.Len())
+	m.ForEach(func(_ uint64, s *stats) {
+		for s != nil {
+			next := s.next
+			s.next = nil
+			s.station = key(s.station)
+			s.name = newNameKey([]byte(s.station))
+			mergeInto(res, canonicalKey(s), s)
+			s = next
+		}
+	})
+	return res
+}
@@ -0,0 +1,35 @@
+package brc
+
+import (
+	"bytes"
+	"math"
+)
+
+// sampleLines appends the lines of chunk that are in the sample to dst. whether a line is in it depends only on its
+// byte offset in the input (chunk starts at offset), so the sample is the same from run to run, whatever the number of
+// workers.
+func sampleLines(dst, chunk []byte, offset int64, rate float64) []byte {
+	threshold := uint64(rate * math.MaxUint64)
+	for len(chunk) > 0 {
+		n := bytes.IndexByte(chunk, '\n') + 1
+		if n == 0 {
+			n = len(chunk)
+		}
+		if mix64(uint64(offset)) < threshold {
+			dst = append(dst, chunk[:n]...)
+		}
+		offset += int64(n)
+		chunk = chunk[n:]
+	}
+	return dst
+}
+
+// mix64 is the splitmix64 finalizer, which is plenty to make offsets look random.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
@@ -0,0 +1,14 @@
+//go:build arm64 && !purego
+
+package brc
+
+// batchedScan has the default engine find line ends with scanNewlines rather than a byte at a time. neon is part of
+// the arm64 baseline, so there's nothing to detect. BenchmarkRun has it against the byte loop.
+const batchedScan = true
+
+// scanNewlines writes the offsets of the newlines in b to ends and returns how many it wrote and how many bytes of b
+// it got through. it goes 16 bytes at a time with neon, for as long as there are 16 bytes of b left and room in ends
+// for 16 more offsets, so the caller finishes off the last few bytes itself. b must be shorter than 2GiB.
+//
+//go:noescape
+func scanNewlines(b []byte, ends []int32) (n, scanned int)
@@ -0,0 +1,66 @@
+//go:build arm64 && !purego
+
+#include "textflag.h"
+
+// func scanNewlines(b []byte, ends []int32) (n, scanned int)
+TEXT ·scanNewlines(SB), NOSPLIT, $0-64
+	MOVD	b_base+0(FP), R0
+	MOVD	b_len+8(FP), R1
+	MOVD	ends_base+24(FP), R2
+	MOVD	ends_len+32(FP), R3
+	MOVD	$0, R4 // n
+	MOVD	$0, R5 // scanned
+	MOVD	$'\n', R6
+	VDUP	R6, V0.B16
+	MOVD	$0x8080808080808080, R7
+
+loop:
+	// stop once there's less than a block of b, or of room in ends, left
+	SUB	R5, R1, R8
+	CMP	$16, R8
+	BLT	done
+	SUB	R4, R3, R8
+	CMP	$16, R8
+	BLT	done
+
+	// 0xff in the bytes that are newlines, which leaves the top bit of each of them in R9 (bytes 0-7) and R10 (8-15)
+	VLD1.P	16(R0), [V1.B16]
+	VCMEQ	V0.B16, V1.B16, V2.B16
+	VMOV	V2.D[0], R9
+	VMOV	V2.D[1], R10
+	AND	R7, R9, R9
+	AND	R7, R10, R10
+
+	// the offset of each set bit's byte is its trailing zeros over 8, plus where the block starts
+	CBZ	R9, high
+low:
+	RBIT	R9, R11
+	CLZ	R11, R11
+	ADD	R11>>3, R5, R12
+	MOVW.P	R12, 4(R2)
+	ADD	$1, R4
+	SUB	$1, R9, R11
+	AND	R11, R9, R9
+	CBNZ	R9, low
+
+high:
+	CBZ	R10, next
+	ADD	$8, R5, R13
+highloop:
+	RBIT	R10, R11
+	CLZ	R11, R11
+	ADD	R11>>3, R13, R12
+	MOVW.P	R12, 4(R2)
+	ADD	$1, R4
+	SUB	$1, R10, R11
+	AND	R11, R10, R10
+	CBNZ	R10, highloop
+
+next:
+	ADD	$16, R5
+	B	loop
+
+done:
+	MOVD	R4, n+48(FP)
+	MOVD	R5, scanned+56(FP)
+	RET
@@ -0,0 +1,21 @@
+//go:build !arm64 || purego
+
+package brc
+
+// elsewhere the byte loop in the default engine is as quick as it gets, see the graveyard in ProcessFile.
+const batchedScan = false
+
+// scanNewlines is the portable version of the neon one in scan_arm64.s, with the same 16 byte blocks, so runBatched
+// behaves the same everywhere.
+func scanNewlines(b []byte, ends []int32) (n, scanned int) {
+	for len(b)-scanned >= 16 && len(ends)-n >= 16 {
+		for i, c := range b[scanned : scanned+16] {
+			if c == '\n' {
+				ends[n] = int32(scanned + i)
+				n++
+			}
+		}
+		scanned += 16
+	}
+	return n, scanned
+}
@@ -0,0 +1,28 @@
+package brc
+
+// WithSMT set to false runs one worker per physical core instead of one per logical cpu: on cpus with simultaneous
+// multithreading (hyperthreading), sibling threads share a core's caches and memory bandwidth, which this workload
+// leans on, so the second thread of each core can cost more than it adds. the workers default to PhysicalCores()
+// rather than AvailableCPUs(), and each is pinned to a thread of a core of its own (linux only), so the scheduler
+// can't put two of them on siblings. it's on by default.
+func WithSMT(on bool) Option {
+	return func(o *options) { o.smt = on }
+}
+
+// PhysicalCores is the number of physical cores among the cpus the process may run on, capped by the cpu quota of its
+// cgroup like AvailableCPUs. where the topology isn't known (outside linux), it's AvailableCPUs().
+func PhysicalCores() int {
+	return min(AvailableCPUs(), len(pinnableCPUs(false)))
+}
+
+// pinWorker pins the calling worker goroutine to the i-th of the cpus to pin to, with WithPinning or WithSMT(false).
+// failing to is only worth a warning.
+func (o *options) pinWorker(i int) {
+	if !o.pin && o.smt {
+		return
+	}
+	cpus := pinnableCPUs(o.smt)
+	if err := pinToCPU(cpus[i%len(cpus)]); err != nil {
+		o.log.Warn("pinning worker failed", "worker", i, "err", err)
+	}
+}
@@ -0,0 +1,23 @@
+package brc
+
+// Spans gets told when the stages of a run start and end, e.g. to export them as tracing spans: setting up the
+// mapping ("mmap"), each worker's share of the input ("worker"), and merging the workers' partials ("merge"). pass
+// one in with WithSpans. the workers call Start from their own goroutines, so it has to be safe for concurrent use.
+type Spans interface {
+	// Start starts a span called name, with attrs as alternating keys and values like slog's, and returns the func
+	// that ends it with the error the stage failed with, if any.
+	Start(name string, attrs ...any) (end func(err error))
+}
+
+// WithSpans reports the stages of the run to s.
+func WithSpans(s Spans) Option {
+	return func(o *options) { o.spans = s }
+}
+
+// span starts a span with o's Spans, if it has any.
+func (o *options) span(name string, attrs ...any) func(err error) {
+	if o.spans == nil {
+		return func(error) {}
+	}
+	return o.spans.Start(name, attrs...)
+}
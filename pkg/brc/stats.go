@@ -0,0 +1,260 @@
+package brc
+
+import (
+	"encoding/binary"
+	"math"
+
+	"github.com/kamstrup/intmap"
+)
+
+type stats struct {
+	station              string
+	name                 nameKey
+	min, max, sum, count float32
+	// while a worker aggregates, sumD and sumSq are the sum and sum of squares of the readings minus shift, the first
+	// reading. shifting keeps the sums small, so they don't cancel out for metrics far from zero (pressure...).
+	// mergeResults turns them into mean and m2, the sum of squared deviations from the mean, which merge cleanly.
+	shift       float32
+	sumD, sumSq float64
+	mean, m2    float64
+	digest      *tdigest   // only with WithQuantiles
+	hist        *histogram // only with WithHistograms
+	agg         Aggregator // only with WithAggregator
+	id          uint64     // the stationID agg observes readings with
+	skip        bool       // filtered out, see WithStations. readings for it are dropped
+	window      int64      // start of the time window in unix seconds, see WithWindow
+	metric      uint8      // index of the metric, see WithMetrics
+	next        *stats     // other stations whose names collided on the same hash, see mergeResults
+}
+
+// nameKey is a cheap stand-in for a station name: its length plus its first and last 8 bytes. names of up to 16
+// bytes are fully covered by it, so most equality checks never have to look at the name itself.
+type nameKey struct {
+	len        int
+	head, tail uint64
+}
+
+func newNameKey(name []byte) nameKey {
+	var buf [8]byte
+	copy(buf[:], name)
+	k := nameKey{len: len(name), head: binary.LittleEndian.Uint64(buf[:])}
+	k.tail = k.head
+	if len(name) > 8 {
+		k.tail = binary.LittleEndian.Uint64(name[len(name)-8:])
+	}
+	return k
+}
+
+func (s *stats) merge(o *stats) {
+	s.min = min(s.min, o.min)
+	s.max = max(s.max, o.max)
+	s.sum += o.sum
+	s.count += o.count
+	s.sumD += o.sumD
+	s.sumSq += o.sumSq
+	if s.digest != nil && o.digest != nil {
+		s.digest.merge(o.digest)
+	} else {
+		s.digest = nil // some of the readings came without one
+	}
+	if s.hist != nil && o.hist != nil {
+		s.hist.merge(o.hist)
+	} else {
+		s.hist = nil
+	}
+	if s.agg != nil && o.agg != nil {
+		s.agg.Merge(o.agg)
+	} else {
+		s.agg = nil
+	}
+}
+
+// mergeDeviations merges o into s along with m2, using the parallel variance formula (chan et al.).
+func (s *stats) mergeDeviations(o *stats) {
+	na, nb := float64(s.count), float64(o.count)
+	delta := o.mean - s.mean
+	s.m2 += o.m2 + delta*delta*na*nb/(na+nb)
+	s.mean += delta * nb / (na + nb)
+	s.merge(o)
+}
+
+// stddev is the population standard deviation, once m2 is set.
+func (s *stats) stddev() float64 {
+	return math.Sqrt(max(s.m2, 0) / float64(s.count))
+}
+
+func (s *stats) sameStation(o *stats) bool {
+	if s.name != o.name || s.window != o.window || s.metric != o.metric {
+		return false
+	}
+	return s.name.len <= 16 || s.station == o.station
+}
+
+// Partial holds the per-station aggregates of one worker, keyed by station hash. engines fill one in per chunk.
+type Partial struct {
+	m          *table
+	deviations bool // see WithStddev
+	digests    bool
+	histograms bool
+	aggregator func() Aggregator         // nil without WithAggregator
+	keep       func(station []byte) bool // nil keeps everything
+	rejected   int64                     // malformed lines dropped, see WithOnError
+	hash       func(name []byte) uint64  // nil for stationHash, see WithHash
+}
+
+func newPartial(o *options) *Partial {
+	return &Partial{m: newTable(0), deviations: o.stddev, digests: o.quantiles, histograms: o.histograms, aggregator: o.aggregator, keep: o.stationFilter(), hash: hashes[o.hash]}
+}
+
+// newStats starts the aggregates for a station we haven't seen yet, at its first reading, and stores them under h.
+// stations the filter rejects get a stats with skip set, so the filter only runs once per station and the hot loop
+// gets away with checking a bool.
+func (p *Partial) newStats(h uint64, station []byte, temp float32) *stats {
+	return p.m.put(h, p.makeStats(station, temp))
+}
+
+func (p *Partial) makeStats(station []byte, temp float32) stats {
+	s := stats{min: temp, max: temp, shift: temp, station: string(station), name: newNameKey(station)}
+	if !p.deviations {
+		s.sumSq = math.NaN() // so the standard deviation comes out as NaN, however it's merged
+	}
+	if p.keep != nil && !p.keep(station) {
+		s.skip = true
+	} else {
+		if p.digests {
+			s.digest = newTDigest()
+		}
+		if p.histograms {
+			s.hist = new(histogram)
+		}
+		if p.aggregator != nil {
+			s.agg, s.id = p.aggregator(), stationHash(station)
+		}
+	}
+	return s
+}
+
+// Observe records a single reading for station.
+func (p *Partial) Observe(station []byte, temp float32) {
+	p.observe(p.key(station), station, 0, 0, temp)
+}
+
+// observeWindow records a reading for station in the time window starting at window.
+func (p *Partial) observeWindow(station []byte, window int64, temp float32) {
+	p.observe(statsKey(p.key(station), window, 0), station, window, 0, temp)
+}
+
+// observeMetric records a value of the metric-th metric for station.
+func (p *Partial) observeMetric(station []byte, metric uint8, v float32) {
+	p.observe(statsKey(p.key(station), 0, metric), station, 0, metric, v)
+}
+
+func (p *Partial) observe(h uint64, station []byte, window int64, metric uint8, temp float32) {
+	s, ok := p.m.get(h)
+	if ok && p.hash != nil {
+		// a weak hash, so the stats under h may be another station's
+		var last *stats
+		if last, ok = s.lookup(station, window, metric); ok {
+			s = last
+		} else {
+			n := p.makeStats(station, temp)
+			n.window, n.metric = window, metric
+			last.next = &n
+			s = &n
+		}
+	} else if !ok {
+		s = p.newStats(h, station, temp)
+		s.window = window
+		s.metric = metric
+	}
+	if !s.skip {
+		s.add(temp, p.deviations)
+	}
+}
+
+// add records a reading, and its deviation from the shift if deviations is set.
+func (s *stats) add(temp float32, deviations bool) {
+	s.min = min(s.min, temp)
+	s.max = max(s.max, temp)
+	s.sum += temp
+	if deviations {
+		d := float64(temp) - float64(s.shift)
+		s.sumD += d
+		s.sumSq += d * d
+	}
+	s.count++
+	if s.digest != nil {
+		s.digest.add(temp)
+	}
+	if s.hist != nil {
+		s.hist.add(temp)
+	}
+	if s.agg != nil {
+		s.agg.Observe(s.id, temp)
+	}
+}
+
+// Add merges already aggregated readings for station into p. there's no sum of squares, digest, histogram or
+// Aggregator to go with them, so the station's standard deviation comes out as NaN and it has no quantiles, histogram
+// or Aggregate.
+func (p *Partial) Add(station []byte, min, max, sum float32, count int64) {
+	if p.keep != nil && !p.keep(station) {
+		return
+	}
+	o := stats{station: string(station), name: newNameKey(station), min: min, max: max, sum: sum, count: float32(count), sumSq: math.NaN()}
+	h := p.key(station)
+	s, ok := p.m.get(h)
+	switch {
+	case !ok:
+		p.m.put(h, o)
+	case p.hash == nil:
+		s.merge(&o)
+	default:
+		if last, found := s.lookup(station, 0, 0); found {
+			last.merge(&o)
+		} else {
+			last.next = &o
+		}
+	}
+}
+
+// mergeResults merges the per-worker maps by station name rather than trusting the hash alone: stations that collide
+// on a hash are chained off the first one via stats.next. the results point into the partials' tables.
+func mergeResults(partials []*Partial) *intmap.Map[uint64, *stats] {
+	res := intmap.New[uint64, *stats](partials[0].m.len)
+	for _, p := range partials {
+		p.m.forEach(func(k uint64, v *stats) {
+			if v.skip {
+				return
+			}
+			if p.hash != nil {
+				// keys from weak hashes aren't unique, or comparable between workers
+				k = canonicalKey(v)
+				v.next = nil
+			}
+			n := float64(v.count)
+			v.mean = float64(v.shift) + v.sumD/n
+			v.m2 = v.sumSq - v.sumD*v.sumD/n
+			mergeInto(res, k, v)
+		})
+	}
+	return res
+}
+
+// mergeInto merges v into the stats for the same station under k in res, or adds it, chaining it off the stats of
+// any other stations there.
+func mergeInto(res *intmap.Map[uint64, *stats], k uint64, v *stats) {
+	s, ok := res.Get(k)
+	if !ok {
+		res.Put(k, v)
+		return
+	}
+	for !s.sameStation(v) {
+		if s.next == nil {
+			s.next = v
+			return
+		}
+		s = s.next
+	}
+	s.mergeDeviations(v)
+}
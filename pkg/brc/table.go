@@ -0,0 +1,108 @@
+package brc
+
+import "math/bits"
+
+// table is a partial's map from key (station hash, see Partial.observe) to stats. the stats live in the slots
+// themselves, so a lookup lands right on the aggregates it's about to update instead of following a pointer to them.
+// it's open addressing with linear probing, kept at most half full so probes stay short. pointers to stats are only
+// good until the next put, which may grow the table.
+type table struct {
+	slots []slot
+	mask  uint64
+	shift int
+	len   int
+}
+
+type slot struct {
+	key  uint64
+	used bool
+	s    stats
+}
+
+// minTableSize is how many slots a table starts out with. enough for the official station list without growing.
+const minTableSize = 1024
+
+// keyMul spreads keys over their high bits, which is where slots are picked from. station keys of short names are the
+// names themselves rather than hashes (see stationHash), so their low bits are anything but evenly distributed.
+const keyMul = 0x9e3779b97f4a7c15
+
+func newTable(n int) *table {
+	size := minTableSize
+	for size < 2*n {
+		size *= 2
+	}
+	t := &table{}
+	t.alloc(size)
+	return t
+}
+
+func (t *table) alloc(size int) {
+	t.slots = make([]slot, size)
+	t.mask = uint64(size - 1)
+	t.shift = 64 - bits.TrailingZeros(uint(size))
+}
+
+// find returns the slot for key: the one holding it, or the free one it would go in.
+func (t *table) find(key uint64) *slot {
+	for i := key * keyMul >> t.shift; ; i = (i + 1) & t.mask {
+		if sl := &t.slots[i]; !sl.used || sl.key == key {
+			return sl
+		}
+	}
+}
+
+func (t *table) get(key uint64) (*stats, bool) {
+	sl := t.find(key)
+	return &sl.s, sl.used
+}
+
+// put stores s under key, which mustn't be in the table yet, and returns a pointer to the stored copy.
+func (t *table) put(key uint64, s stats) *stats {
+	if 2*(t.len+1) > len(t.slots) {
+		t.grow()
+	}
+	sl := t.find(key)
+	*sl = slot{key: key, used: true, s: s}
+	t.len++
+	return &sl.s
+}
+
+func (t *table) grow() {
+	old := t.slots
+	t.alloc(2 * len(old))
+	for i := range old {
+		if old[i].used {
+			*t.find(old[i].key) = old[i]
+		}
+	}
+}
+
+// forEach calls f with every key and its stats, in no particular order, including the stats chained to those in the
+// table (see stats.lookup). f may change s.next.
+func (t *table) forEach(f func(k uint64, s *stats)) {
+	for i := range t.slots {
+		if sl := &t.slots[i]; sl.used {
+			for s := &sl.s; s != nil; {
+				next := s.next
+				f(sl.key, s)
+				s = next
+			}
+		}
+	}
+}
+
+// probes calls f with every stats in the table and how many slots a lookup for it looks at. stats chained to the one
+// in a slot (see stats.lookup) take one more look each.
+func (t *table) probes(f func(s *stats, probes int)) {
+	for i := range t.slots {
+		sl := &t.slots[i]
+		if !sl.used {
+			continue
+		}
+		n := int((uint64(i)-sl.key*keyMul>>t.shift)&t.mask) + 1
+		for s := &sl.s; s != nil; s = s.next {
+			f(s, n)
+			n++
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package brc
+
+import (
+	"math"
+	"slices"
+)
+
+// digestBuffer is how many readings a tdigest buffers before compressing.
+const digestBuffer = 5 * digestCompression
+
+// digestCompression bounds the number of centroids in a tdigest to a small multiple of it. 100 is the usual default,
+// it's good to a fraction of a degree on the tails for our data.
+const digestCompression = 100
+
+// tdigest is a merging t-digest (dunning & ertl), for approximate percentiles in a small, mergeable amount of memory.
+// readings are buffered and folded into the centroids in batches, which keeps add cheap.
+type tdigest struct {
+	centroids []centroid // sorted by mean
+	buf       []centroid // unmerged
+	min, max  float64
+}
+
+type centroid struct {
+	mean, weight float64
+}
+
+// clone returns a copy of t that doesn't share anything with it, or nil if t is.
+func (t *tdigest) clone() *tdigest {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	c.centroids = slices.Clone(t.centroids)
+	c.buf = slices.Clone(t.buf)
+	return &c
+}
+
+func newTDigest() *tdigest {
+	return &tdigest{
+		// with room for the centroids, which compress appends
+		buf: make([]centroid, 0, digestBuffer+2*digestCompression),
+		min: math.Inf(1),
+		max: math.Inf(-1),
+	}
+}
+
+func (d *tdigest) add(x float32) {
+	v := float64(x)
+	d.min = math.Min(d.min, v)
+	d.max = math.Max(d.max, v)
+	d.buf = append(d.buf, centroid{v, 1})
+	if len(d.buf) >= digestBuffer {
+		d.compress()
+	}
+}
+
+func (d *tdigest) merge(o *tdigest) {
+	o.compress()
+	d.min = math.Min(d.min, o.min)
+	d.max = math.Max(d.max, o.max)
+	d.buf = append(d.buf, o.centroids...)
+	d.compress()
+}
+
+// compress folds the buffer into the centroids. neighbouring centroids are combined as long as the result stays under
+// the size limit for its quantile, which is smallest at the tails, so that's where the digest is most accurate.
+func (d *tdigest) compress() {
+	if len(d.buf) == 0 {
+		return
+	}
+	all := append(d.buf, d.centroids...)
+	slices.SortFunc(all, func(a, b centroid) int {
+		switch {
+		case a.mean < b.mean:
+			return -1
+		case a.mean > b.mean:
+			return 1
+		}
+		return 0
+	})
+
+	var total float64
+	for _, c := range all {
+		total += c.weight
+	}
+
+	out := d.centroids[:0]
+	if cap(out) < digestCompression {
+		out = make([]centroid, 0, 2*digestCompression)
+	}
+	out = append(out, all[0])
+	var before float64 // weight of the centroids before the last one in out
+	for _, c := range all[1:] {
+		last := &out[len(out)-1]
+		w := last.weight + c.weight
+		q0, q1 := before/total, (before+w)/total
+		if w <= 4*total*math.Min(q0*(1-q0), q1*(1-q1))/digestCompression {
+			last.mean += (c.mean - last.mean) * c.weight / w
+			last.weight = w
+			continue
+		}
+		before += last.weight
+		out = append(out, c)
+	}
+	d.centroids = out
+	d.buf = all[:0] // in case the append above had to grow it
+}
+
+// quantile estimates the q-th quantile (0 <= q <= 1) by interpolating between centroid means.
+func (d *tdigest) quantile(q float64) float64 {
+	d.compress()
+	if len(d.centroids) == 0 {
+		return math.NaN()
+	}
+	if len(d.centroids) == 1 {
+		return d.centroids[0].mean
+	}
+	if q <= 0 {
+		return d.min
+	}
+	if q >= 1 {
+		return d.max
+	}
+
+	var total float64
+	for _, c := range d.centroids {
+		total += c.weight
+	}
+	target := q * total
+
+	// each centroid's mean sits at the middle of its weight
+	var cum float64
+	prevMean, prevPos := d.min, 0.0
+	for _, c := range d.centroids {
+		pos := cum + c.weight/2
+		if target < pos {
+			return prevMean + (c.mean-prevMean)*(target-prevPos)/(pos-prevPos)
+		}
+		prevMean, prevPos = c.mean, pos
+		cum += c.weight
+	}
+	return prevMean + (d.max-prevMean)*(target-prevPos)/(total-prevPos)
+}
@@ -0,0 +1,56 @@
+//go:build linux && !purego
+
+package brc
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// pinnableCPUs returns the cpus the process may run on, from its affinity mask. without smt, it only keeps the first
+// thread of each physical core, going by the topology in sysfs. cpus whose topology can't be read count as cores of
+// their own.
+func pinnableCPUs(smt bool) []int {
+	var set unix.CPUSet
+	var cpus []int
+	if err := unix.SchedGetaffinity(0, &set); err == nil {
+		for cpu := range len(set) * 64 {
+			if set.IsSet(cpu) {
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	if len(cpus) == 0 {
+		for cpu := range runtime.NumCPU() {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if smt {
+		return cpus
+	}
+	cores := make(map[string]bool)
+	var first []int
+	for _, cpu := range cpus {
+		read := func(name string) string {
+			b, err := os.ReadFile(fmt.Sprintf("/sys/devices/system/cpu/cpu%d/topology/%s", cpu, name))
+			if err != nil {
+				return ""
+			}
+			return strings.TrimSpace(string(b))
+		}
+		pkg, core := read("physical_package_id"), read("core_id")
+		if pkg == "" || core == "" {
+			first = append(first, cpu)
+			continue
+		}
+		if key := pkg + "/" + core; !cores[key] {
+			cores[key] = true
+			first = append(first, cpu)
+		}
+	}
+	return first
+}
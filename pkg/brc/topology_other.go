@@ -0,0 +1,13 @@
+//go:build !linux || purego
+
+package brc
+
+// there's no topology to go by outside linux (or with -tags purego, which leaves out x/sys), so every cpu counts as a
+// core of its own. pinning is a no-op there anyway.
+func pinnableCPUs(smt bool) []int {
+	cpus := make([]int, AvailableCPUs())
+	for i := range cpus {
+		cpus[i] = i
+	}
+	return cpus
+}
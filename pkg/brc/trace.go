@@ -0,0 +1,25 @@
+package brc
+
+import (
+	"context"
+	"runtime/trace"
+)
+
+// every worker runs as an execution trace task (see -trace), with a chunk region per runChunk call, so `go tool trace`
+// shows when each chunk starts and ends and where a worker sat idle, rather than anonymous goroutines. the merge gets a
+// region too. none of it costs anything much when tracing is off.
+
+// workerTask starts the trace task for worker i, which the caller ends.
+func workerTask(ctx context.Context, i int) (context.Context, *trace.Task) {
+	ctx, task := trace.NewTask(ctx, "worker")
+	trace.Logf(ctx, "worker", "%d", i)
+	return ctx, task
+}
+
+// chunkRegion starts the trace region for a chunk of n bytes at offset, which the caller ends.
+func chunkRegion(ctx context.Context, offset int64, n int) *trace.Region {
+	if trace.IsEnabled() {
+		trace.Logf(ctx, "chunk", "offset %d, %d bytes", offset, n)
+	}
+	return trace.StartRegion(ctx, "chunk")
+}
@@ -0,0 +1,80 @@
+package brc
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// windowEngine aggregates the timestamped format, station;temperature;unix_seconds, per station per time window (see
+// WithWindow).
+type windowEngine struct {
+	window  int64 // seconds
+	relaxed bool  // see WithRelaxed
+}
+
+func (w *windowEngine) Run(chunk []byte, p *Partial) error {
+	parse := parseFloat
+	if w.relaxed {
+		parse = parseDecimal
+	}
+	for len(chunk) > 0 {
+		n := bytes.IndexByte(chunk, '\n')
+		if n < 0 {
+			n = len(chunk)
+		}
+		line := trimCR(chunk[:n])
+		chunk = chunk[min(n+1, len(chunk)):]
+
+		tsSemi := bytes.LastIndexByte(line, ';')
+		tempSemi := bytes.LastIndexByte(line[:max(tsSemi, 0)], ';')
+		if tempSemi < 0 {
+			return fmt.Errorf("parsing line %q: %w: want station;temperature;timestamp", line, ErrMalformedLine)
+		}
+		ts, ok := parseUnix(line[tsSemi+1:])
+		if !ok || (!w.relaxed && tsSemi-tempSemi < 4) {
+			return fmt.Errorf("parsing line %q: %w: want station;temperature;timestamp", line, ErrMalformedLine)
+		}
+		start := ts - ts%w.window
+		if ts < 0 && ts%w.window != 0 {
+			start -= w.window // round down before the epoch too
+		}
+		temp := line[tempSemi+1 : tsSemi]
+		v, ok := parse(temp)
+		if !ok {
+			return fmt.Errorf("parsing line %q: %w", line, ErrBadTemperature)
+		}
+		p.observeWindow(line[:tempSemi], start, v)
+	}
+	return nil
+}
+
+// parseUnix parses a decimal unix timestamp in seconds.
+func parseUnix(bs []byte) (int64, bool) {
+	neg := len(bs) > 0 && bs[0] == '-'
+	if neg {
+		bs = bs[1:]
+	}
+	if len(bs) == 0 || len(bs) > 18 {
+		return 0, false
+	}
+	var v int64
+	for _, c := range bs {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		v = v*10 + int64(c-'0')
+	}
+	if neg {
+		v = -v
+	}
+	return v, true
+}
+
+// windowTime converts a window start to what goes in Station.Window.
+func windowTime(start int64, windowed bool) time.Time {
+	if !windowed {
+		return time.Time{}
+	}
+	return time.Unix(start, 0).UTC()
+}
@@ -0,0 +1,314 @@
+package brc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+type worker struct{}
+
+func newWorker() *worker {
+	return &worker{}
+}
+
+// Run is the default engine.
+func (w *worker) Run(chunk []byte, p *Partial) error {
+	if p.hash != nil {
+		return w.runHashed(chunk, p)
+	}
+	if batchedScan {
+		return w.runBatched(chunk, p)
+	}
+	return w.runBytes(chunk, p)
+}
+
+// runBytes is Run with the line ends found a byte at a time, everywhere but arm64.
+func (w *worker) runBytes(chunk []byte, p *Partial) error {
+	res := p.m
+	deviations := p.deviations
+	// our chunk is guaranteed to be made of full lines only
+	lineStart := 0
+	for i := 0; i < len(chunk); i++ {
+		if chunk[i] == '\n' {
+			// handle line
+			stationBs, stationHash, temp, err := w.parseLineBytes(chunk[lineStart:i])
+			if err != nil {
+				return fmt.Errorf("parsing line %w", err)
+			}
+			s, ok := res.get(stationHash)
+			if !ok {
+				s = p.newStats(stationHash, stationBs, temp)
+			}
+			if s.skip {
+				lineStart = i + 1
+				continue
+			}
+			s.min = min(s.min, temp)
+			s.max = max(s.max, temp)
+			s.sum += temp
+			if deviations {
+				d := float64(temp) - float64(s.shift)
+				s.sumD += d
+				s.sumSq += d * d
+			}
+			s.count++
+			if s.digest != nil {
+				s.digest.add(temp)
+			}
+			if s.hist != nil {
+				s.hist.add(temp)
+			}
+			if s.agg != nil {
+				s.agg.Observe(s.id, temp)
+			}
+
+			lineStart = i + 1
+		}
+	}
+	return nil
+}
+
+// maxScan is how much of a chunk runBatched hands scanNewlines at once, so the offsets fit in an int32.
+const maxScan = 1 << 30
+
+// runBatched is Run with the line ends found a batch at a time by scanNewlines, on arm64.
+func (w *worker) runBatched(chunk []byte, p *Partial) error {
+	res := p.m
+	deviations := p.deviations
+	var ends [128]int32
+	lineStart := 0
+	for pos := 0; pos < len(chunk); {
+		n, scanned := scanNewlines(chunk[pos:min(len(chunk), pos+maxScan)], ends[:])
+		if scanned == 0 {
+			// the last few bytes, less than a block
+			for i, c := range chunk[pos:] {
+				if c == '\n' {
+					ends[n] = int32(i)
+					n++
+				}
+			}
+			scanned = len(chunk) - pos
+		}
+		for _, e := range ends[:n] {
+			i := pos + int(e)
+			stationBs, stationHash, temp, err := w.parseLineBytes(chunk[lineStart:i])
+			if err != nil {
+				return fmt.Errorf("parsing line %w", err)
+			}
+			lineStart = i + 1
+			s, ok := res.get(stationHash)
+			if !ok {
+				s = p.newStats(stationHash, stationBs, temp)
+			}
+			if s.skip {
+				continue
+			}
+			s.min = min(s.min, temp)
+			s.max = max(s.max, temp)
+			s.sum += temp
+			if deviations {
+				d := float64(temp) - float64(s.shift)
+				s.sumD += d
+				s.sumSq += d * d
+			}
+			s.count++
+			if s.digest != nil {
+				s.digest.add(temp)
+			}
+			if s.hist != nil {
+				s.hist.add(temp)
+			}
+			if s.agg != nil {
+				s.agg.Observe(s.id, temp)
+			}
+		}
+		pos += scanned
+	}
+	return nil
+}
+
+// runHashed is Run with a hash other than the default, see WithHash. the lookups go through the partial, which checks
+// names, as weak hashes collide.
+func (w *worker) runHashed(chunk []byte, p *Partial) error {
+	lineStart := 0
+	for i := 0; i < len(chunk); i++ {
+		if chunk[i] == '\n' {
+			stationBs, temp, err := w.parseLine(chunk[lineStart:i])
+			if err != nil {
+				return fmt.Errorf("parsing line %w", err)
+			}
+			p.observe(p.hash(stationBs), stationBs, 0, 0, temp)
+			lineStart = i + 1
+		}
+	}
+	return nil
+}
+
+// parseLineBytes is parseLine plus the station's hash. it handles the official format itself, so the hot loop doesn't
+// pay for the extra call.
+func (w *worker) parseLineBytes(line []byte) ([]byte, uint64, float32, error) {
+	line = trimCR(line)
+	if stationBs, tempStr, ok := w.splitOnSemi(line); ok {
+		if temp, ok := parseTemp(tempStr); ok {
+			return stationBs, stationHash(stationBs), temp, nil
+		}
+	}
+	stationBs, temp, err := w.parseTrimmed(line)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return stationBs, stationHash(stationBs), temp, nil
+}
+
+func (w *worker) parseLine(line []byte) ([]byte, float32, error) {
+	return w.parseTrimmed(trimCR(line))
+}
+
+// parseTrimmed is parseLine for a line that's had its \r trimmed already. trimming again would take a \r\r ending
+// for a windows one.
+func (w *worker) parseTrimmed(line []byte) ([]byte, float32, error) {
+	if stationBs, tempStr, ok := w.splitOnSemi(line); ok {
+		if temp, ok := parseTemp(tempStr); ok {
+			return stationBs, temp, nil
+		}
+	}
+
+	// the guess didn't work out, because the line is too short for it or isn't in the official format. scan for the
+	// semicolon instead. the temperature still has to be official, other formats are for WithRelaxed
+	semi := bytes.LastIndexByte(line, ';')
+	if semi < 0 {
+		return nil, 0, fmt.Errorf("%q: %w: no semicolon", line, ErrMalformedLine)
+	}
+	temp, ok := parseFloat(line[semi+1:])
+	if !ok {
+		return nil, 0, fmt.Errorf("%q: %w", line, ErrBadTemperature)
+	}
+	return line[:semi], temp, nil
+}
+
+// splitOnSemi splits an official format line at the semicolon, or returns false if it can't find it where it should
+// be.
+func (w *worker) splitOnSemi(bs []byte) ([]byte, []byte, bool) {
+	// the format is like ABC;-1.0. the semicolon can only be in a few places from the end: -5 (2 digit pos temp or 1 dig neg), -6 (neg), -4 (1 digit pos temp)
+	// the most common variant is 4 digits, then 3, then 5. so check in that order
+	if len(bs) < 6 || bs[len(bs)-2] != '.' {
+		return nil, nil, false // too short to check all three, or no single fractional digit. leave it to the scan
+	}
+	if i := len(bs) - 5; bs[i] == ';' {
+		return bs[:i], bs[i+1:], true
+	} else if i := len(bs) - 4; bs[i] == ';' {
+		return bs[:i], bs[i+1:], true
+	} else if i := len(bs) - 6; bs[i] == ';' {
+		return bs[:i], bs[i+1:], true
+	}
+	return nil, nil, false
+}
+
+// trimCR drops the \r of a windows line ending. the last byte of the line is in cache already and LF-only input
+// always takes the same branch, so it costs next to nothing when there's no \r.
+func trimCR(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		return line[:len(line)-1]
+	}
+	return line
+}
+
+// stationHash returns the key stations are stored under. names of up to 7 bytes, which are most of them, are packed
+// into it as they are, with the length in the top byte, which is quicker than hashing them and can't collide. longer
+// names are hashed.
+func stationHash(name []byte) uint64 {
+	if len(name) >= 8 {
+		return xxhash.Sum64(name)
+	}
+	var k uint64
+	if cap(name) >= 8 {
+		// the name is nearly always followed by at least the rest of its line, so load 8 bytes and mask off the extra
+		k = binary.LittleEndian.Uint64(name[:8]) & (1<<(8*len(name)) - 1)
+	} else {
+		var buf [8]byte
+		copy(buf[:], name)
+		k = binary.LittleEndian.Uint64(buf[:])
+	}
+	return k | uint64(len(name))<<56
+}
+
+// parseTemp is parseFloat, but loads 8 bytes at once when bs has the capacity, which it nearly always does since it's
+// a slice of a bigger buffer.
+func parseTemp(bs []byte) (float32, bool) {
+	if cap(bs) < 8 {
+		return parseFloat(bs)
+	}
+	return parseFloatSWAR(binary.LittleEndian.Uint64(bs[:8]), len(bs))
+}
+
+// tempTable holds the float32 of every temperature by sign and absolute value in tenths, computed the way parseFloat
+// does, -0.0 included, so results don't depend on which of them parsed a reading.
+var tempTable = func() (t [2][1000]float32) {
+	for a := range 1000 {
+		v := float32(a/10) + float32(a%10)/10
+		t[0][a], t[1][a] = v, -1*v
+	}
+	return t
+}()
+
+// parseFloatSWAR parses an official format temperature, n bytes long, from the low bytes of w (which holds it in
+// memory order) without branching on its sign or length: the position of the dot tells them apart, the sign becomes a
+// mask, and one multiplication combines the digits. it's the well known trick from the java 1brc entries, plus checks
+// that the bytes really are a temperature, so the guess in parseLineBytes can still fall back.
+func parseFloatSWAR(w uint64, n int) (float32, bool) {
+	// digits have bit 4 set, the dot and the minus sign don't. the dot is the first byte after the sign without it
+	dot := bits.TrailingZeros64(^w & 0x10101000)
+	neg := uint64(int64(^w<<59) >> 63) // all ones if there's a minus sign
+	x := (w &^ (neg & 0xff)) << (28 - dot&31)
+	// now x is laid out as 0, tens (or 0), ones, dot, tenths from the lowest byte up, whatever the sign and length
+	abs := ((x & 0x0f000f0f00) * 0x640a0001 >> 32) & 0x3ff
+
+	// the tens may be missing, in which case they're 0 and get filled in with a '0' so the digit checks pass
+	y := x | ((x&0xff00-1)>>63)*0x3000
+	ok := dot <= 28 && n == dot>>3+2 &&
+		(w^'-')&neg&0xff == 0 &&
+		x&0xff0000ff == 0x2e000000 &&
+		y&0xf000f0f000 == 0x3000303000 && (y&0xff00ffff00+0x0600060600)&0xf000f0f000 == 0x3000303000
+	if !ok {
+		return 0, false
+	}
+	return tempTable[neg&1][abs], true
+}
+
+// parseFloat parses an official format temperature, or returns false if bs isn't one.
+func parseFloat(bs []byte) (float32, bool) {
+	// Temperature value: non null double between -99.9 (inclusive) and 99.9 (inclusive), always with one fractional digit
+	sign := float32(1.)
+	if len(bs) > 0 && bs[0] == '-' {
+		sign = -1.
+		bs = bs[1:]
+	}
+	if len(bs) < 3 || len(bs) > 4 || bs[len(bs)-2] != '.' {
+		return 0, false
+	}
+
+	intPart := bs[:len(bs)-2]
+	fracPart := bs[len(bs)-1] - '0'
+
+	// the digits are bytes, so anything below '0' wraps around and fails the > 9 checks too
+	var ip int
+	if len(intPart) == 2 {
+		tens, ones := intPart[0]-'0', intPart[1]-'0'
+		if tens > 9 || ones > 9 || fracPart > 9 {
+			return 0, false
+		}
+		ip = int(tens*10 + ones)
+	} else {
+		ones := intPart[0] - '0'
+		if ones > 9 || fracPart > 9 {
+			return 0, false
+		}
+		ip = int(ones)
+	}
+
+	return sign * (float32(ip) + float32(fracPart)/10), true
+}
@@ -0,0 +1,126 @@
+package brc
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestParseLineCR(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		station string
+		temp    float32
+		ok      bool
+	}{
+		{"X;1.0", "X", 1, true},
+		{"X;1.0\r", "X", 1, true},
+		{"Station;-12.3\r", "Station", -12.3, true},
+		// only one \r is part of the line ending
+		{"X;1.0\r\r", "", 0, false},
+		{"Station;-12.3\r\r", "", 0, false},
+		{"X;0\r\r", "", 0, false},
+		{"X;1.0\r\n", "", 0, false},
+	} {
+		w := newWorker()
+		station, _, temp, err := w.parseLineBytes([]byte(tc.line))
+		if ok := err == nil; ok != tc.ok || ok && (string(station) != tc.station || temp != tc.temp) {
+			t.Errorf("parseLineBytes(%q) = %q, %v, %v, want %q, %v, ok %v", tc.line, station, temp, err, tc.station, tc.temp, tc.ok)
+		}
+		station, temp, err = w.parseLine([]byte(tc.line))
+		if ok := err == nil; ok != tc.ok || ok && (string(station) != tc.station || temp != tc.temp) {
+			t.Errorf("parseLine(%q) = %q, %v, %v, want %q, %v, ok %v", tc.line, station, temp, err, tc.station, tc.temp, tc.ok)
+		}
+		if err != nil && !errors.Is(err, ErrMalformedLine) {
+			t.Errorf("parseLine(%q) = %v, want a malformed line", tc.line, err)
+		}
+	}
+}
+
+// a line the engine rejects is one WithOnError("skip") drops, and the other way around.
+func TestParseLineCRSkip(t *testing.T) {
+	res, err := Process(strings.NewReader("X;1.0\r\nX;2.0\r\r\nY;3.0\r\n"), WithOnError("skip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Rejected != 1 || len(res.Stations) != 2 || res.Stations[0].Count != 1 {
+		t.Errorf("got %d rejected and %+v, want X once, Y once and 1 rejected", res.Rejected, res.Stations)
+	}
+	if _, err := Process(strings.NewReader("X;1.0\r\nX;2.0\r\r\n")); !errors.Is(err, ErrMalformedLine) {
+		t.Errorf("got %v, want a malformed line", err)
+	}
+}
+
+// temperatures that aren't in the official format are malformed, whichever way the line is checked, unless
+// WithRelaxed asks for them.
+func TestParseLineStrict(t *testing.T) {
+	for _, line := range []string{"A;12", "A;+3.", "A;.5", "A;123.45", "A;1.25", "A;+1.0", "A;1e1"} {
+		w := newWorker()
+		if _, _, _, err := w.parseLineBytes([]byte(line)); !errors.Is(err, ErrBadTemperature) {
+			t.Errorf("parseLineBytes(%q) = %v, want a bad temperature", line, err)
+		}
+		if _, err := Process(strings.NewReader(line + "\n")); !errors.Is(err, ErrBadTemperature) {
+			t.Errorf("%q: got %v, want a bad temperature", line, err)
+		}
+		res, err := Process(strings.NewReader(line+"\n"), WithOnError("skip"))
+		if err != nil {
+			t.Errorf("%q with WithOnError(skip): %v", line, err)
+		} else if res.Rejected != 1 {
+			t.Errorf("%q with WithOnError(skip): got %d rejected, want it rejected", line, res.Rejected)
+		}
+	}
+	for _, line := range []string{"A;12", "A;+3.", "A;.5", "A;123.45"} {
+		res, err := Process(strings.NewReader(line+"\n"), WithRelaxed(true))
+		if err != nil || len(res.Stations) != 1 {
+			t.Errorf("%q with WithRelaxed: got %v, want it aggregated", line, err)
+		}
+	}
+	// lines too short for the guess still parse
+	for _, line := range []string{"A;1.0", "A;-1.0", "AB;1.0"} {
+		if _, _, _, err := newWorker().parseLineBytes([]byte(line)); err != nil {
+			t.Errorf("parseLineBytes(%q) = %v", line, err)
+		}
+	}
+}
+
+// benchChunk returns about n bytes of lines for a few hundred stations with names of all sorts of lengths, in the
+// official format.
+func benchChunk(n int) []byte {
+	rng := rand.New(rand.NewSource(1))
+	names := make([]string, 400)
+	for i := range names {
+		names[i] = fmt.Sprintf("%.*s%d", rng.Intn(24), "abcdefghijklmnopqrstuvwxyz", i)
+	}
+	b := make([]byte, 0, n+64)
+	for len(b) < n {
+		b = fmt.Appendf(b, "%s;%.1f\n", names[rng.Intn(len(names))], float64(rng.Intn(1999)-999)/10)
+	}
+	return b
+}
+
+// the ways the default engine can find line ends, with and without WithStddev's sums. on arm64 Run batches them with
+// neon, elsewhere with the portable scanNewlines, which is what the graveyard in ProcessFile compares against.
+func BenchmarkRun(b *testing.B) {
+	chunk := benchChunk(16 << 20)
+	for _, r := range []struct {
+		name string
+		run  func(w *worker, chunk []byte, p *Partial) error
+	}{
+		{"bytes", (*worker).runBytes},
+		{"batched", (*worker).runBatched},
+	} {
+		for _, stddev := range []bool{false, true} {
+			o := newOptions([]Option{WithStddev(stddev)})
+			b.Run(fmt.Sprintf("%s/stddev=%v", r.name, stddev), func(b *testing.B) {
+				b.SetBytes(int64(len(chunk)))
+				for range b.N {
+					if err := r.run(newWorker(), chunk, newPartial(o)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package brc
+
+import "time"
+
+// WorkerStats describes where one worker's time went, for diagnosing chunk imbalance and starvation.
+type WorkerStats struct {
+	Worker   int
+	Start    time.Duration // from the start of processing until the worker got going
+	Busy     time.Duration // aggregating
+	Idle     time.Duration // waiting, for input or for the other workers to finish
+	Bytes    int64
+	Lines    int64
+	Stations int // distinct stations, i.e. map inserts. every other line was a map hit
+
+	// how the worker's table fared, for picking hashes (see WithHash) and table sizes
+	Slots      int     // size of the table
+	LoadFactor float64 // used slots over Slots
+	MeanProbe  float64 // slots looked at per lookup, averaged over lines
+	MaxProbe   int     // slots looked at by the longest lookup
+	Collisions int     // stations that share their key with an earlier one. only detected with hashes other than xxhash
+}
+
+// finishWorkerStats fills in the fields of ws that can be derived once all workers are done. total is the wall time
+// of the whole processing phase.
+func finishWorkerStats(ws []WorkerStats, partials []*Partial, total time.Duration) {
+	for i := range ws {
+		ws[i].Worker = i
+		ws[i].Idle = total - ws[i].Start - ws[i].Busy
+		m := partials[i].m
+		var probes float64
+		m.probes(func(s *stats, n int) {
+			ws[i].Stations++
+			ws[i].Lines += int64(s.count)
+			probes += float64(n) * float64(s.count)
+			ws[i].MaxProbe = max(ws[i].MaxProbe, n)
+		})
+		ws[i].Slots = len(m.slots)
+		ws[i].LoadFactor = float64(m.len) / float64(len(m.slots))
+		ws[i].Collisions = ws[i].Stations - m.len
+		if ws[i].Lines > 0 {
+			ws[i].MeanProbe = probes / float64(ws[i].Lines)
+		}
+	}
+}
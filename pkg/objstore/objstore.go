@@ -0,0 +1,122 @@
+// Package objstore reads objects from http(s) urls and s3:// and gs:// buckets with ranged GETs, so inputs don't have
+// to be copied locally first.
+//
+// s3 requests are signed with the usual AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, in
+// AWS_REGION (us-east-1 by default), against AWS_ENDPOINT_URL if set (path style, for minio and friends). without
+// credentials, requests go out unsigned, which works for public buckets. gs objects are fetched through the xml api
+// (storage.googleapis.com/bucket/object), with GOOGLE_OAUTH_ACCESS_TOKEN as a bearer token if it's set.
+package objstore
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// IsURL reports whether path is something Open handles rather than a local file.
+func IsURL(path string) bool {
+	for _, scheme := range []string{"http://", "https://", "s3://", "gs://"} {
+		if strings.HasPrefix(path, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// Object is a remote object of known size. it implements io.ReaderAt with one ranged GET per call, and is safe for
+// concurrent use.
+type Object struct {
+	url    string
+	size   int64
+	sign   func(req *http.Request) // adds auth, if any
+	client *http.Client
+}
+
+// Open resolves rawURL and fetches the object's size.
+func Open(ctx context.Context, rawURL string) (*Object, error) {
+	o := &Object{client: http.DefaultClient, sign: func(*http.Request) {}}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		o.url = rawURL
+	case "s3":
+		o.url, o.sign = s3Request(u.Host, strings.TrimPrefix(u.Path, "/"))
+	case "gs":
+		o.url = "https://storage.googleapis.com/" + u.Host + u.EscapedPath()
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+			o.sign = func(req *http.Request) { req.Header.Set("Authorization", "Bearer "+token) }
+		}
+	default:
+		return nil, fmt.Errorf("unsupported url scheme %q", u.Scheme)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, o.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	o.sign(req)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("HEAD %s: %s: %w", o.url, resp.Status, fs.ErrNotExist)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD %s: %s", o.url, resp.Status)
+	}
+	if resp.ContentLength < 0 {
+		return nil, fmt.Errorf("HEAD %s: no content length", o.url)
+	}
+	o.size = resp.ContentLength
+	return o, nil
+}
+
+// Size returns the object's size in bytes.
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ReadAt reads len(p) bytes at off, or up to the end of the object.
+func (o *Object) ReadAt(p []byte, off int64) (int, error) {
+	return o.ReadAtContext(context.Background(), p, off)
+}
+
+// ReadAtContext is ReadAt with a context for the request.
+func (o *Object) ReadAtContext(ctx context.Context, p []byte, off int64) (int, error) {
+	if off >= o.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), o.size)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, o.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, end-1))
+	o.sign(req)
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("GET %s (bytes %d-%d): %s", o.url, off, end-1, resp.Status)
+	}
+	n, err := io.ReadFull(resp.Body, p[:end-off])
+	if err != nil {
+		return n, fmt.Errorf("GET %s (bytes %d-%d): %w", o.url, off, end-1, err)
+	}
+	if end == o.size && int(end-off) < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"path/filepath"
+	"plugin"
+	"slices"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var engineName = flag.String("engine", "default", "worker implementation to aggregate chunks with (see -engine-plugin)")
+var enginePlugins = flag.String("engine-plugin", "", "comma separated go plugins (.so) to load extra engines from; each is registered under its file name")
+
+// loadEnginePlugins loads any -engine-plugin plugins and registers them as engines. experiments that are go packages
+// can skip this and register themselves with brc.RegisterEngine from a main of their own.
+func loadEnginePlugins() error {
+	if *enginePlugins == "" {
+		return nil
+	}
+	for _, path := range strings.Split(*enginePlugins, ",") {
+		name := strings.TrimSuffix(filepath.Base(path), ".so")
+		if slices.Contains(brc.Engines(), name) {
+			continue // already loaded by a previous run in this process
+		}
+		agg, err := loadEnginePlugin(path)
+		if err != nil {
+			return fmt.Errorf("loading engine plugin %s: %w", path, err)
+		}
+		brc.RegisterEngine(name, func() brc.Engine { return pluginEngine{aggregate: agg} })
+	}
+	return nil
+}
+
+// pluginAggregate is the symbol an engine plugin has to export as `Aggregate`. plugins can't share our types, so it
+// gets the chunk and returns its per-station partials as [min, max, sum, count].
+//
+//	func Aggregate(chunk []byte) (map[string][4]float64, error)
+type pluginAggregate = func(chunk []byte) (map[string][4]float64, error)
+
+func loadEnginePlugin(path string) (pluginAggregate, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	sym, err := p.Lookup("Aggregate")
+	if err != nil {
+		return nil, err
+	}
+	agg, ok := sym.(pluginAggregate)
+	if !ok {
+		return nil, fmt.Errorf("Aggregate is a %T, want %T", sym, pluginAggregate(nil))
+	}
+	return agg, nil
+}
+
+type pluginEngine struct {
+	aggregate pluginAggregate
+}
+
+func (p pluginEngine) Run(chunk []byte, res *brc.Partial) error {
+	partials, err := p.aggregate(chunk)
+	if err != nil {
+		return err
+	}
+	for name, v := range partials {
+		res.Add([]byte(name), float32(v[0]), float32(v[1]), float32(v[2]), int64(v[3]))
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// the api of `1brc grpc-serve`, which `1brc coordinate` is a client of. brc.pb.go and brc_grpc.pb.go are generated from
+// it, see doc.go.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: brc.proto
+
+package brcpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Aggregator_Aggregate_FullMethodName      = "/brc.v1.Aggregator/Aggregate"
+	Aggregator_AggregateRange_FullMethodName = "/brc.v1.Aggregator/AggregateRange"
+)
+
+// AggregatorClient is the client API for Aggregator service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type AggregatorClient interface {
+	// Aggregate takes a measurements file as a stream of raw chunks and returns the aggregates once the stream ends.
+	// chunks don't need to end on line boundaries.
+	Aggregate(ctx context.Context, opts ...grpc.CallOption) (Aggregator_AggregateClient, error)
+	// AggregateRange aggregates the lines starting in a byte range of an input the server can open itself, and returns
+	// the partial aggregates for the caller to merge with those of the other ranges. see `1brc coordinate`.
+	AggregateRange(ctx context.Context, in *Range, opts ...grpc.CallOption) (*Partials, error)
+}
+
+type aggregatorClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewAggregatorClient(cc grpc.ClientConnInterface) AggregatorClient {
+	return &aggregatorClient{cc}
+}
+
+func (c *aggregatorClient) Aggregate(ctx context.Context, opts ...grpc.CallOption) (Aggregator_AggregateClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Aggregator_ServiceDesc.Streams[0], Aggregator_Aggregate_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &aggregatorAggregateClient{stream}
+	return x, nil
+}
+
+type Aggregator_AggregateClient interface {
+	Send(*Chunk) error
+	CloseAndRecv() (*Results, error)
+	grpc.ClientStream
+}
+
+type aggregatorAggregateClient struct {
+	grpc.ClientStream
+}
+
+func (x *aggregatorAggregateClient) Send(m *Chunk) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *aggregatorAggregateClient) CloseAndRecv() (*Results, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Results)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *aggregatorClient) AggregateRange(ctx context.Context, in *Range, opts ...grpc.CallOption) (*Partials, error) {
+	out := new(Partials)
+	err := c.cc.Invoke(ctx, Aggregator_AggregateRange_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AggregatorServer is the server API for Aggregator service.
+// All implementations must embed UnimplementedAggregatorServer
+// for forward compatibility
+type AggregatorServer interface {
+	// Aggregate takes a measurements file as a stream of raw chunks and returns the aggregates once the stream ends.
+	// chunks don't need to end on line boundaries.
+	Aggregate(Aggregator_AggregateServer) error
+	// AggregateRange aggregates the lines starting in a byte range of an input the server can open itself, and returns
+	// the partial aggregates for the caller to merge with those of the other ranges. see `1brc coordinate`.
+	AggregateRange(context.Context, *Range) (*Partials, error)
+	mustEmbedUnimplementedAggregatorServer()
+}
+
+// UnimplementedAggregatorServer must be embedded to have forward compatible implementations.
+type UnimplementedAggregatorServer struct {
+}
+
+func (UnimplementedAggregatorServer) Aggregate(Aggregator_AggregateServer) error {
+	return status.Errorf(codes.Unimplemented, "method Aggregate not implemented")
+}
+func (UnimplementedAggregatorServer) AggregateRange(context.Context, *Range) (*Partials, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AggregateRange not implemented")
+}
+func (UnimplementedAggregatorServer) mustEmbedUnimplementedAggregatorServer() {}
+
+// UnsafeAggregatorServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to AggregatorServer will
+// result in compilation errors.
+type UnsafeAggregatorServer interface {
+	mustEmbedUnimplementedAggregatorServer()
+}
+
+func RegisterAggregatorServer(s grpc.ServiceRegistrar, srv AggregatorServer) {
+	s.RegisterService(&Aggregator_ServiceDesc, srv)
+}
+
+func _Aggregator_Aggregate_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(AggregatorServer).Aggregate(&aggregatorAggregateServer{stream})
+}
+
+type Aggregator_AggregateServer interface {
+	SendAndClose(*Results) error
+	Recv() (*Chunk, error)
+	grpc.ServerStream
+}
+
+type aggregatorAggregateServer struct {
+	grpc.ServerStream
+}
+
+func (x *aggregatorAggregateServer) SendAndClose(m *Results) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *aggregatorAggregateServer) Recv() (*Chunk, error) {
+	m := new(Chunk)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Aggregator_AggregateRange_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Range)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AggregatorServer).AggregateRange(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Aggregator_AggregateRange_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AggregatorServer).AggregateRange(ctx, req.(*Range))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Aggregator_ServiceDesc is the grpc.ServiceDesc for Aggregator service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Aggregator_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "brc.v1.Aggregator",
+	HandlerType: (*AggregatorServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "AggregateRange",
+			Handler:    _Aggregator_AggregateRange_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Aggregate",
+			Handler:       _Aggregator_Aggregate_Handler,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "brc.proto",
+}
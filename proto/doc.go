@@ -0,0 +1,5 @@
+// Package brcpb is the generated code for brc.proto, the api of `1brc grpc-serve`. regenerating it takes protoc with
+// protoc-gen-go and protoc-gen-go-grpc on the PATH.
+package brcpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative brc.proto
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var aggregateSpec = flag.String("aggregate", "", "comma separated values to print per station instead of min/mean/max: min, max, mean, sum, count, stddev, a percentile like p95, or count(op:x), the number of readings gt, ge, lt or le x. e.g. min,max,mean,count(gt:30.0)")
+
+// reducers are the parsed -aggregate, nil without it.
+var reducers []reducer
+
+// a reducer is one of the values -aggregate prints for a station.
+type reducer struct {
+	name   string // as written, for column headers
+	append func(b []byte, s *brc.Station) []byte
+}
+
+// a threshold is the condition of a count(op:x) reducer.
+type threshold struct {
+	op string
+	x  float32
+}
+
+func (t threshold) match(v float32) bool {
+	switch t.op {
+	case "gt":
+		return v > t.x
+	case "ge":
+		return v >= t.x
+	case "lt":
+		return v < t.x
+	default:
+		return v <= t.x
+	}
+}
+
+// thresholdCounts is the brc.Aggregator behind the count(op:x) reducers: it counts the readings matching each of the
+// thresholds, all of them in one Aggregator so there's a single interface call per reading however many there are.
+type thresholdCounts struct {
+	thresholds []threshold
+	n          []int64
+}
+
+func (c *thresholdCounts) Observe(_ uint64, temp float32) {
+	for i, t := range c.thresholds {
+		if t.match(temp) {
+			c.n[i]++
+		}
+	}
+}
+
+func (c *thresholdCounts) Merge(other brc.Aggregator) {
+	for i, n := range other.(*thresholdCounts).n {
+		c.n[i] += n
+	}
+}
+
+func (c *thresholdCounts) Result() any { return c.n }
+
+// parseReducers parses an -aggregate spec. it returns the thresholds of its count(op:x) reducers, whose counts end up
+// in that order in each station's Aggregate, and whether it has percentiles, which need brc.WithQuantiles.
+func parseReducers(spec string) ([]reducer, []threshold, bool, error) {
+	var rs []reducer
+	var thresholds []threshold
+	var digests bool
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		r := reducer{name: name}
+		switch name {
+		case "min":
+			r.append = func(b []byte, s *brc.Station) []byte { return appendTenths(b, s.Min) }
+		case "max":
+			r.append = func(b []byte, s *brc.Station) []byte { return appendTenths(b, s.Max) }
+		case "mean":
+			r.append = func(b []byte, s *brc.Station) []byte { return appendTenths(b, s.Mean) }
+		case "sum":
+			r.append = func(b []byte, s *brc.Station) []byte { return appendTenths(b, s.Sum) }
+		case "stddev":
+			r.append = func(b []byte, s *brc.Station) []byte { return appendTenths(b, s.Stddev) }
+		case "count":
+			r.append = func(b []byte, s *brc.Station) []byte { return strconv.AppendInt(b, s.Count, 10) }
+		default:
+			if arg, ok := strings.CutPrefix(name, "count("); ok && strings.HasSuffix(arg, ")") {
+				op, x, _ := strings.Cut(strings.TrimSuffix(arg, ")"), ":")
+				op = strings.TrimSpace(op)
+				v, err := strconv.ParseFloat(strings.TrimSpace(x), 32)
+				if err != nil || !slices.Contains([]string{"gt", "ge", "lt", "le"}, op) {
+					return nil, nil, false, fmt.Errorf("bad -aggregate %q, want count(op:x) with op gt, ge, lt or le", name)
+				}
+				i := len(thresholds)
+				thresholds = append(thresholds, threshold{op, float32(v)})
+				r.append = func(b []byte, s *brc.Station) []byte {
+					counts, ok := s.Aggregate.([]int64)
+					if !ok {
+						return append(b, *missingPlaceholder...) // an engine only provided aggregates
+					}
+					return strconv.AppendInt(b, counts[i], 10)
+				}
+				break
+			}
+			if strings.HasPrefix(name, "p") {
+				qs, err := parsePercentiles(name)
+				if err != nil {
+					return nil, nil, false, fmt.Errorf("bad -aggregate %q: %w", name, err)
+				}
+				digests = true
+				r.append = func(b []byte, s *brc.Station) []byte {
+					if v, ok := s.Quantile(qs[0]); ok {
+						return appendTenths(b, v)
+					}
+					return append(b, *missingPlaceholder...)
+				}
+				break
+			}
+			return nil, nil, false, fmt.Errorf("unknown -aggregate %q (have min, max, mean, sum, count, stddev, pN and count(op:x))", name)
+		}
+		rs = append(rs, r)
+	}
+	return rs, thresholds, digests, nil
+}
+
+// reducerOptions parses -aggregate into reducers, and returns the library options the reducers need.
+func reducerOptions() ([]brc.Option, error) {
+	if *aggregateSpec == "" {
+		return nil, nil
+	}
+	if *withStddev || *percentiles != "" {
+		return nil, fmt.Errorf("-aggregate replaces -stddev and -percentiles, use stddev and pN in it instead")
+	}
+	if *format != "text" && *format != "tsv" && *format != "table" {
+		return nil, fmt.Errorf("-aggregate only works with the text, tsv and table formats")
+	}
+	rs, thresholds, digests, err := parseReducers(*aggregateSpec)
+	if err != nil {
+		return nil, err
+	}
+	reducers = rs
+	var opts []brc.Option
+	if digests {
+		opts = append(opts, brc.WithQuantiles(true))
+	}
+	if slices.ContainsFunc(rs, func(r reducer) bool { return r.name == "stddev" }) {
+		opts = append(opts, brc.WithStddev(true))
+	}
+	if len(thresholds) > 0 {
+		opts = append(opts, brc.WithAggregator(func() brc.Aggregator {
+			return &thresholdCounts{thresholds: thresholds, n: make([]int64, len(thresholds))}
+		}))
+	}
+	return opts, nil
+}
+
+// appendReduced appends the -aggregate values for s, separated by slashes.
+func appendReduced(b []byte, s *brc.Station) []byte {
+	for i, r := range reducers {
+		if i > 0 {
+			b = append(b, '/')
+		}
+		b = r.append(b, s)
+	}
+	return b
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+	"go.coldcutz.net/1brc/pkg/objstore"
+)
+
+const defaultInput = "measurements.txt"
+
+var inputBlockSize = flag.Int("input-block-size", 16<<20, "with a remote -input, fetch the object in ranged reads of this many `bytes`")
+var inputConcurrency = flag.Int("input-concurrency", 8, "with a remote -input, how many ranged reads to keep in flight (at least one per cpu, unless -workers is set)")
+
+// processRemote aggregates an object in a bucket or behind a url without downloading it first: like for local files,
+// the object is split into one chunk per worker, and each worker fetches its own chunk with ranged reads. there are
+// more workers than usual so enough reads are in flight to make up for the latency.
+func processRemote(ctx context.Context, url string, opts []brc.Option) (*brc.Results, error) {
+	obj, err := objstore.Open(ctx, url)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", url, err)
+	}
+	if *workers == 0 {
+		opts = append(opts, brc.WithWorkers(max(brc.AvailableCPUs(), *inputConcurrency)))
+	}
+	opts = append(opts, brc.WithReadBlockSize(*inputBlockSize))
+	return brc.ProcessReaderAt(obj, obj.Size(), opts...)
+}
+
+// openInput opens a local file or a remote object for ranged reads, returning its size and a func to close it.
+func openInput(ctx context.Context, path string) (io.ReaderAt, int64, func() error, error) {
+	if compressedInput(path) {
+		return nil, 0, nil, fmt.Errorf("%s is compressed, so it can't be split into byte ranges", path)
+	}
+	if objstore.IsURL(path) {
+		obj, err := objstore.Open(ctx, path)
+		if err != nil {
+			return nil, 0, nil, fmt.Errorf("opening %s: %w", path, err)
+		}
+		return obj, obj.Size(), func() error { return nil }, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, nil, err
+	}
+	return f, fi.Size(), f.Close, nil
+}
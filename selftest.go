@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// the selftest sample is 20k generated rows under a header line, plus some edge cases at the end (-0.0, the extreme
+// temperatures, names around the 8 byte mark, utf-8 and commas in names, and a last line without a newline).
+// expected.txt is its output from a build that was checked against a straightforward reference implementation.
+var (
+	//go:embed selftest/measurements.txt
+	selftestInput []byte
+	//go:embed selftest/expected.txt
+	selftestExpected []byte
+)
+
+// runSelftest is the `selftest` subcommand: it aggregates the embedded sample every way there is, with small chunks
+// and blocks so there are plenty of boundaries, and compares each output against the embedded golden one. it's a
+// quick sanity check after an optimization, that needs neither an input nor a reference output.
+func runSelftest(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := flag.NewFlagSet("selftest", flag.ExitOnError)
+	shareFlags(fs, "errors", "v", "q")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "1brc-selftest")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "measurements.txt")
+	if err := os.WriteFile(path, selftestInput, 0o644); err != nil {
+		return err
+	}
+
+	opts := []brc.Option{brc.WithContext(ctx), brc.WithLogger(log), brc.WithWorkers(4), brc.WithUseIndex(false)}
+	with := func(extra ...brc.Option) []brc.Option {
+		return append(append([]brc.Option(nil), opts...), extra...)
+	}
+	size := int64(len(selftestInput))
+	checks := []struct {
+		name string
+		run  func() (*brc.Results, error)
+	}{
+		{"mmap", func() (*brc.Results, error) { return brc.ProcessFile(path, opts...) }},
+		{"perfect hash", func() (*brc.Results, error) { return brc.ProcessFile(path, with(brc.WithPerfectHash(true))...) }},
+		{"scanner", func() (*brc.Results, error) {
+			return brc.Process(bytes.NewReader(selftestInput), with(brc.WithReadBlockSize(1<<10))...)
+		}},
+		{"pread", func() (*brc.Results, error) {
+			return brc.ProcessReaderAt(bytes.NewReader(selftestInput), size, with(brc.WithReadBlockSize(4<<10))...)
+		}},
+		{"readahead", func() (*brc.Results, error) {
+			return brc.ProcessReaderAt(bytes.NewReader(selftestInput), size, with(brc.WithReadBlockSize(4<<10), brc.WithReadAhead(true))...)
+		}},
+		{"shards", func() (*brc.Results, error) { return selftestShards(size, 7, opts) }},
+	}
+
+	failed := 0
+	for _, c := range checks {
+		res, err := c.run()
+		if err != nil {
+			return fmt.Errorf("%s: %w", c.name, err)
+		}
+		var got bytes.Buffer
+		printRes(&got, res.Stations, nil)
+		if n, err := compareOutputsLoosely(selftestExpected, got.Bytes()); err != nil {
+			log.Error("selftest failed", "check", c.name, "err", err)
+			failed++
+		} else {
+			log.Debug("selftest passed", "check", c.name, "stations", n)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d selftest checks failed", failed, len(checks))
+	}
+	fmt.Printf("ok, %d checks passed\n", len(checks))
+	return nil
+}
+
+// selftestShards aggregates the sample in n ranges, round trips their partials through the serialized format, and
+// merges them, like `1brc -shard` and `1brc merge` do.
+func selftestShards(size int64, n int64, opts []brc.Option) (*brc.Results, error) {
+	var all []*brc.Partial
+	for k := range n {
+		partials, err := brc.ProcessRange(bytes.NewReader(selftestInput), size, size*k/n, size*(k+1)/n, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("shard %d/%d: %w", k+1, n, err)
+		}
+		all = append(all, partials...)
+	}
+	var buf bytes.Buffer
+	if err := brc.WritePartials(&buf, all); err != nil {
+		return nil, err
+	}
+	partials, err := brc.ReadPartials(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return brc.Merge(partials, opts...), nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"time"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// runServe is the `serve` subcommand. it runs the aggregation on demand over http:
+//
+//	POST /aggregate            with the measurements as the request body
+//	GET  /aggregate?path=file  for a file on the server (needs -allow-paths)
+//	GET  /aggregate?url=url    for a file fetched over http(s) (needs -allow-urls)
+//
+// and responds with the results as json.
+func runServe(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("serve", without(aggregationFlags, "input")...) // the input comes with the request
+	addr := fs.String("addr", "localhost:8080", "`address` to listen on")
+	allowPaths := fs.Bool("allow-paths", false, "let requests aggregate arbitrary files on this machine with ?path=")
+	allowURLs := fs.Bool("allow-urls", false, "let requests have the server fetch and aggregate ?url=")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if err := loadEnginePlugins(); err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/aggregate", func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		opts, err := aggregationOptions(r.Context(), log)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		opts = append(opts, brc.WithStddev(true)) // the json has them
+
+		var res *brc.Results
+		path, url := r.URL.Query().Get("path"), r.URL.Query().Get("url")
+		switch {
+		case path != "":
+			if !*allowPaths {
+				http.Error(w, "aggregating paths is disabled, see -allow-paths", http.StatusForbidden)
+				return
+			}
+			res, err = processLocal(path, opts)
+		case url != "":
+			if !*allowURLs {
+				http.Error(w, "aggregating urls is disabled, see -allow-urls", http.StatusForbidden)
+				return
+			}
+			res, err = processURL(r.Context(), url, opts)
+		case r.Method == http.MethodPost:
+			res, err = brc.Process(r.Body, opts...)
+		default:
+			http.Error(w, "POST the measurements, or pass ?path= or ?url=", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Warn("aggregation failed", "err", err)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		log.Info("aggregated", "rows", res.Rows(), "stations", len(res.Stations), "elapsed", time.Since(start))
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(newJSONResults(res)); err != nil {
+			log.Warn("writing response", "err", err)
+		}
+	})
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", *addr, err)
+	}
+	srv := &http.Server{Handler: mux, BaseContext: func(net.Listener) context.Context { return ctx }}
+	context.AfterFunc(ctx, func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	})
+	log.Info("serving", "addr", ln.Addr().String())
+	if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// processURL aggregates the file at url as it downloads.
+func processURL(ctx context.Context, url string, opts []brc.Option) (*brc.Results, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %s: %s", url, resp.Status)
+	}
+	return brc.Process(resp.Body, opts...)
+}
+
+type jsonStation struct {
+	Name   string   `json:"name"`
+	Keys   []string `json:"keys,omitempty"`   // with -group-by
+	Window string   `json:"window,omitempty"` // with -window
+	Metric string   `json:"metric,omitempty"` // with -columns
+	Min    float64  `json:"min"`
+	Mean   float64  `json:"mean"`
+	Max    float64  `json:"max"`
+	Stddev float64  `json:"stddev"`
+	Count  int64    `json:"count"`
+}
+
+type jsonResults struct {
+	Rows     int64         `json:"rows"`
+	Stations []jsonStation `json:"stations"`
+}
+
+// newJSONResults converts res for json output. values are rounded to one decimal like the text output, otherwise the
+// float32 accumulators show through (-99.9000015258789).
+func newJSONResults(res *brc.Results) jsonResults {
+	out := jsonResults{Rows: res.Rows(), Stations: make([]jsonStation, len(res.Stations))}
+	for i := range res.Stations {
+		out.Stations[i] = newJSONStation(&res.Stations[i])
+	}
+	return out
+}
+
+func newJSONStation(s *brc.Station) jsonStation {
+	out := jsonStation{Name: s.Name, Keys: s.Keys, Metric: s.Metric, Min: round1(s.Min), Mean: round1(s.Mean), Max: round1(s.Max), Stddev: round1(s.Stddev), Count: s.Count}
+	if !s.Window.IsZero() {
+		out.Window = s.Window.Format(time.RFC3339)
+	}
+	return out
+}
+
+func round1(f float64) float64 {
+	return math.Round(f*10) / 10
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var sqlitePath = flag.String("sqlite", "", "also store the results in the station_stats table of the sqlite database at `file`, creating it if needed (uses the sqlite3 cli)")
+var runID = flag.String("run-id", "", "with -sqlite, tag the rows with this id so several runs can live in the same table. rerunning with the same id replaces its rows")
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS station_stats (
+	run_id TEXT NOT NULL DEFAULT '',
+	station TEXT NOT NULL,
+	window_start TEXT NOT NULL DEFAULT '',
+	metric TEXT NOT NULL DEFAULT '',
+	min REAL,
+	mean REAL,
+	max REAL,
+	stddev REAL,
+	count INTEGER,
+	PRIMARY KEY (run_id, station, window_start, metric)
+);
+`
+
+// writeSQLite replaces runID's rows in the station_stats table with stations, in a single transaction. min and max
+// are readings, so they're rounded back to one decimal to hide the float32 noise. there's no sqlite driver in our
+// dependencies (and the usual one needs cgo), so this feeds a script to the sqlite3 cli instead.
+func writeSQLite(path, runID string, stations []brc.Station) error {
+	var script bytes.Buffer
+	script.WriteString(".bail on\nBEGIN;\n")
+	script.WriteString(sqliteSchema)
+	// stations the rerun doesn't have mustn't keep their old rows
+	fmt.Fprintf(&script, "DELETE FROM station_stats WHERE run_id = %s;\n", sqlQuote(runID))
+	for _, s := range stations {
+		window := ""
+		if !s.Window.IsZero() {
+			window = s.Window.Format(time.RFC3339)
+		}
+		fmt.Fprintf(&script, "INSERT OR REPLACE INTO station_stats VALUES (%s, %s, %s, %s, %s, %s, %s, %s, %d);\n",
+			sqlQuote(runID), sqlQuote(s.Name), sqlQuote(window), sqlQuote(s.Metric),
+			sqlReal(round1(s.Min)), sqlReal(s.Mean), sqlReal(round1(s.Max)), sqlReal(s.Stddev), s.Count)
+	}
+	script.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", path)
+	cmd.Stdin = &script
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running sqlite3: %w: %s", err, bytes.TrimSpace(out))
+	}
+	return nil
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func sqlReal(f float64) string {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return "NULL"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
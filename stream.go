@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// streamInput reports whether path is an input that can only be read front to back: a unix:path socket to listen on,
+// or a named pipe or unix socket on disk.
+func streamInput(path string) bool {
+	if strings.HasPrefix(path, "unix:") {
+		return true
+	}
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode()&(os.ModeNamedPipe|os.ModeSocket) != 0
+}
+
+// processStream aggregates measurements another process streams in, without them touching disk. with -input
+// unix:path it listens on a unix socket at path and reads the first connection until the other end closes it, a
+// socket on disk is connected to and read until it closes, and a named pipe is read until all its writers are done.
+// none of them can be mapped or split between the workers, so they're always read like -impl scanner.
+func processStream(ctx context.Context, path string, opts []brc.Option) (*brc.Results, error) {
+	if *ioMode != "cached" {
+		return nil, fmt.Errorf("-io %s doesn't work with a socket or pipe -input", *ioMode)
+	}
+	if *implName != "mmap" && *implName != "scanner" {
+		return nil, fmt.Errorf("-impl %s doesn't work with a socket or pipe -input, it's always read like -impl scanner", *implName)
+	}
+	var conn io.ReadCloser
+	if addr, ok := strings.CutPrefix(path, "unix:"); ok {
+		c, err := acceptUnix(ctx, addr)
+		if err != nil {
+			return nil, err
+		}
+		conn = c
+	} else if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		c, err := new(net.Dialer).DialContext(ctx, "unix", path)
+		if err != nil {
+			return nil, fmt.Errorf("connecting to %s: %w", path, err)
+		}
+		conn = c
+	} else {
+		// opening blocks until there's a writer
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		conn = f
+	}
+	defer conn.Close()
+	// the read blocks for as long as the other end keeps quiet, so closing is the way to stop it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	res, err := brc.Process(conn, opts...)
+	if ctx.Err() != nil {
+		err = ctx.Err()
+	}
+	return res, err
+}
+
+// acceptUnix listens on a unix socket at path and returns the first connection to it. the socket file is removed
+// again once it's accepted, so a run doesn't leave a stale one behind.
+func acceptUnix(ctx context.Context, path string) (net.Conn, error) {
+	l, err := new(net.ListenConfig).Listen(ctx, "unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+	defer l.Close()
+	stop := context.AfterFunc(ctx, func() { l.Close() })
+	defer stop()
+	c, err := l.Accept()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("accepting on %s: %w", path, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,74 @@
+//go:build linux && !purego
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// see ioprio_set(2). x/sys doesn't have these.
+const (
+	ioprioWhoProcess = 1
+	ioprioClassShift = 13
+)
+
+var ioprioClasses = map[string]int{"realtime": 1, "best-effort": 2, "idle": 3}
+
+// setPriority sets the nice value and/or io priority (as "class" or "class:level", like ionice) of every thread in the
+// process. on linux both are per-thread, and go has already started a handful of threads by now, so we walk
+// /proc/self/task. threads started later inherit the values from whichever thread spawns them.
+func setPriority(nice *int, ionice string) error {
+	ioprio := -1
+	if ionice != "" {
+		className, levelStr, _ := strings.Cut(ionice, ":")
+		class, ok := ioprioClasses[className]
+		if !ok {
+			return fmt.Errorf("unknown ionice class %q (want realtime, best-effort or idle)", className)
+		}
+		level := 4 // the kernel's default
+		if levelStr != "" {
+			var err error
+			if level, err = strconv.Atoi(levelStr); err != nil || level < 0 || level > 7 {
+				return fmt.Errorf("bad ionice level %q (want 0-7)", levelStr)
+			}
+		}
+		ioprio = class<<ioprioClassShift | level
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return fmt.Errorf("listing threads: %w", err)
+	}
+	for _, task := range tasks {
+		tid, err := strconv.Atoi(task.Name())
+		if err != nil {
+			continue
+		}
+		if nice != nil {
+			if err := unix.Setpriority(unix.PRIO_PROCESS, tid, *nice); err != nil {
+				return fmt.Errorf("setpriority %d: %w", *nice, err)
+			}
+		}
+		if ioprio >= 0 {
+			if _, _, errno := unix.Syscall(unix.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(tid), uintptr(ioprio)); errno != 0 {
+				return fmt.Errorf("ioprio_set %s: %w", ionice, errno)
+			}
+		}
+	}
+	return nil
+}
+
+// dropPageCache flushes dirty pages and evicts the page cache, so the next run reads the file from disk.
+func dropPageCache() error {
+	syscall.Sync()
+	if err := os.WriteFile("/proc/sys/vm/drop_caches", []byte("3\n"), 0); err != nil {
+		return fmt.Errorf("writing drop_caches: %w", err)
+	}
+	return nil
+}
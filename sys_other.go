@@ -0,0 +1,16 @@
+//go:build !linux || purego
+
+package main
+
+import "fmt"
+
+func setPriority(nice *int, ionice string) error {
+	if nice != nil || ionice != "" {
+		return fmt.Errorf("setting process priority is only supported on linux, and not with -tags purego")
+	}
+	return nil
+}
+
+func dropPageCache() error {
+	return fmt.Errorf("dropping the page cache is only supported on linux, and not with -tags purego")
+}
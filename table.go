@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+var tableBox = flag.Bool("table-box", false, "with -format table, draw the table with unicode box drawing characters")
+
+// writeTable writes stations as an aligned table for reading in a terminal: names on the left, numbers right aligned.
+// column widths count runes, so names in scripts with double width characters throw the alignment off a bit.
+func writeTable(w io.Writer, stations []brc.Station) error {
+	header := slices.Clone(keyLabels())
+	if *window > 0 {
+		header = append(header, "window")
+	}
+	if *columns != "" {
+		header = append(header, "metric")
+	}
+	labels := len(header)
+	for _, r := range reducers {
+		header = append(header, r.name)
+	}
+	if reducers == nil {
+		header = append(header, "min", "mean", "max")
+		if *withStddev {
+			header = append(header, "stddev")
+		}
+		for _, q := range quantiles {
+			header = append(header, "p"+strconv.FormatFloat(q*100, 'f', -1, 64))
+		}
+		header = append(header, "count")
+	}
+
+	rows := [][]string{header}
+	for i := range stations {
+		s := &stations[i]
+		row := keyValues(s)
+		if *window > 0 {
+			row = append(row, s.Window.Format(time.RFC3339))
+		}
+		if *columns != "" {
+			row = append(row, s.Metric)
+		}
+		if reducers != nil {
+			for _, r := range reducers {
+				row = append(row, string(r.append(nil, s)))
+			}
+			rows = append(rows, row)
+			continue
+		}
+		row = append(row, tenths(s.Min), tenths(s.Mean), tenths(s.Max))
+		if *withStddev {
+			row = append(row, tenths(s.Stddev))
+		}
+		for _, q := range quantiles {
+			if v, ok := s.Quantile(q); ok {
+				row = append(row, tenths(v))
+			} else {
+				row = append(row, *missingPlaceholder)
+			}
+		}
+		rows = append(rows, append(row, strconv.FormatInt(s.Count, 10)))
+	}
+
+	widths := make([]int, len(header))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], utf8.RuneCountInString(cell))
+		}
+	}
+
+	// rule draws a horizontal line of the box, with left and right at its ends and cross where it meets a column border
+	rule := func(b []byte, left, cross, right string) []byte {
+		b = append(b, left...)
+		for i, width := range widths {
+			if i > 0 {
+				b = append(b, cross...)
+			}
+			b = append(b, strings.Repeat("─", width+2)...)
+		}
+		return append(b, right+"\n"...)
+	}
+	var b []byte
+	if *tableBox {
+		b = rule(b, "┌", "┬", "┐")
+	}
+	for r, row := range rows {
+		if *tableBox {
+			b = append(b, "│ "...)
+		}
+		for i, cell := range row {
+			if i > 0 {
+				if *tableBox {
+					b = append(b, " │ "...)
+				} else {
+					b = append(b, "  "...)
+				}
+			}
+			pad := strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell))
+			if i < labels {
+				b = append(b, cell+pad...)
+			} else {
+				b = append(b, pad+cell...)
+			}
+		}
+		if *tableBox {
+			b = append(b, " │"...)
+		}
+		b = append(b, '\n')
+		if r == 0 && *tableBox {
+			b = rule(b, "├", "┼", "┤")
+		}
+	}
+	if *tableBox {
+		b = rule(b, "└", "┴", "┘")
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func tenths(v float64) string {
+	return string(appendTenths(nil, v))
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.coldcutz.net/1brc/pkg/brc"
+)
+
+// writeTSV writes stations as tab separated values, one row per station under a header row, for awk and cut. there's
+// no quoting: station names are written as they are. temperatures have one decimal, like in the text format.
+func writeTSV(w io.Writer, stations []brc.Station) error {
+	b := []byte(strings.Join(keyLabels(), "\t"))
+	if *window > 0 {
+		b = append(b, "\twindow"...)
+	}
+	if *columns != "" {
+		b = append(b, "\tmetric"...)
+	}
+	if reducers != nil {
+		for _, r := range reducers {
+			b = append(b, '\t')
+			b = append(b, r.name...)
+		}
+	} else {
+		b = append(b, "\tmin\tmean\tmax\tstddev\tcount"...)
+		for _, q := range quantiles {
+			b = append(b, "\tp"...)
+			b = strconv.AppendFloat(b, q*100, 'f', -1, 64)
+		}
+	}
+	b = append(b, '\n')
+
+	for i := range stations {
+		s := &stations[i]
+		b = append(b, strings.Join(keyValues(s), "\t")...)
+		if *window > 0 {
+			b = append(b, '\t')
+			b = s.Window.AppendFormat(b, time.RFC3339)
+		}
+		if *columns != "" {
+			b = append(b, '\t')
+			b = append(b, s.Metric...)
+		}
+		if reducers != nil {
+			for _, r := range reducers {
+				b = append(b, '\t')
+				b = r.append(b, s)
+			}
+			b = append(b, '\n')
+			continue
+		}
+		for _, v := range []float64{s.Min, s.Mean, s.Max, s.Stddev} {
+			b = append(b, '\t')
+			b = appendTenths(b, v)
+		}
+		b = append(b, '\t')
+		b = strconv.AppendInt(b, s.Count, 10)
+		for _, q := range quantiles {
+			b = append(b, '\t')
+			if v, ok := s.Quantile(q); ok {
+				b = appendTenths(b, v)
+			} else {
+				b = append(b, *missingPlaceholder...)
+			}
+		}
+		b = append(b, '\n')
+	}
+	_, err := w.Write(b)
+	return err
+}
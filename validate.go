@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// runValidate is the `validate` subcommand: it runs the aggregation and diffs the output against a reference output,
+// so optimizations can't silently break correctness.
+func runValidate(ctx context.Context, log *slog.Logger, args []string) error {
+	fs := subcommandFlags("validate", aggregationFlags...)
+	expectedPath := fs.String("expected", "", "reference output `file` to compare against (required)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *expectedPath == "" {
+		return fmt.Errorf("-expected is required")
+	}
+
+	expected, err := os.ReadFile(*expectedPath)
+	if err != nil {
+		return fmt.Errorf("reading expected output: %w", err)
+	}
+
+	res, err := aggregate(ctx, log)
+	if err != nil {
+		return err
+	}
+	var got bytes.Buffer
+	printRes(&got, res.Stations, nil)
+
+	n, err := compareOutputs(expected, got.Bytes())
+	if err != nil {
+		return fmt.Errorf("output doesn't match %s: %w", *expectedPath, err)
+	}
+	log.Info("output matches", "expected", *expectedPath, "stations", n)
+	return nil
+}
+
+type outputEntry struct {
+	station string
+	values  [3]string // min, mean, max as printed
+}
+
+// outputValueRe matches the "=min/mean/max" part of an entry along with the separator after it. station names can
+// contain commas (e.g. "Washington, D.C."), so we find entries by their values rather than by splitting on commas.
+var outputValueRe = regexp.MustCompile(`=([^/,{}=]+)/([^/,{}=]+)/([^/,{}=]+)(?:, ?|\}\s*$)`)
+
+// parseOutput parses the {name=min/mean/max, ...} format. it accepts both the official ", " separators and our
+// trailing-comma style.
+func parseOutput(out []byte) ([]outputEntry, error) {
+	out = bytes.TrimSpace(out)
+	if !bytes.HasPrefix(out, []byte("{")) || !bytes.HasSuffix(out, []byte("}")) {
+		return nil, fmt.Errorf("output isn't wrapped in braces")
+	}
+	var entries []outputEntry
+	prev := 1
+	for _, m := range outputValueRe.FindAllSubmatchIndex(out, -1) {
+		if m[0] <= prev {
+			return nil, fmt.Errorf("empty station name at byte %d", m[0])
+		}
+		entries = append(entries, outputEntry{
+			station: string(out[prev:m[0]]),
+			values:  [3]string{string(out[m[2]:m[3]]), string(out[m[4]:m[5]]), string(out[m[6]:m[7]])},
+		})
+		prev = m[1]
+	}
+	if prev < len(out)-1 {
+		return nil, fmt.Errorf("unparseable output at byte %d: %.40q", prev, out[prev:])
+	}
+	return entries, nil
+}
+
+// compareOutputs returns the number of matching stations, or an error describing the first mismatch.
+func compareOutputs(expected, got []byte) (int, error) {
+	want, err := parseOutput(expected)
+	if err != nil {
+		return 0, fmt.Errorf("parsing expected output: %w", err)
+	}
+	have, err := parseOutput(got)
+	if err != nil {
+		return 0, fmt.Errorf("parsing our output: %w", err)
+	}
+
+	valueNames := [3]string{"min", "mean", "max"}
+	for i := range min(len(want), len(have)) {
+		w, h := want[i], have[i]
+		if w.station != h.station {
+			return i, fmt.Errorf("station %d: expected %q, got %q", i, w.station, h.station)
+		}
+		for vi := range w.values {
+			if w.values[vi] != h.values[vi] {
+				return i, fmt.Errorf("%s %s: expected %s, got %s", w.station, valueNames[vi], w.values[vi], h.values[vi])
+			}
+		}
+	}
+	if len(want) > len(have) {
+		return len(have), fmt.Errorf("missing stations, starting with %q", want[len(have)].station)
+	}
+	if len(have) > len(want) {
+		return len(want), fmt.Errorf("unexpected stations, starting with %q", have[len(want)].station)
+	}
+	return len(want), nil
+}
+
+// compareOutputsLoosely is compareOutputs, except that means may be a tenth apart: sums are float32, so how the input
+// is split up can move a mean that's right on a rounding boundary to the other side of it. mins and maxes are exact.
+func compareOutputsLoosely(expected, got []byte) (int, error) {
+	want, err := parseOutput(expected)
+	if err != nil {
+		return 0, fmt.Errorf("parsing expected output: %w", err)
+	}
+	have, err := parseOutput(got)
+	if err != nil {
+		return 0, fmt.Errorf("parsing our output: %w", err)
+	}
+	if len(want) != len(have) {
+		return 0, fmt.Errorf("expected %d stations, got %d", len(want), len(have))
+	}
+	for i, w := range want {
+		h := have[i]
+		if w.station != h.station {
+			return i, fmt.Errorf("station %d: expected %q, got %q", i, w.station, h.station)
+		}
+		wm, err1 := strconv.ParseFloat(w.values[1], 64)
+		hm, err2 := strconv.ParseFloat(h.values[1], 64)
+		if w.values[0] != h.values[0] || w.values[2] != h.values[2] || err1 != nil || err2 != nil || max(wm-hm, hm-wm) > 0.1001 {
+			return i, fmt.Errorf("%s: expected %s/%s/%s, got %s/%s/%s", w.station,
+				w.values[0], w.values[1], w.values[2], h.values[0], h.values[1], h.values[2])
+		}
+	}
+	return len(want), nil
+}